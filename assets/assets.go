@@ -0,0 +1,333 @@
+// Package assets serves static files with fingerprinted names and caching headers.
+//
+// Every file gets a content-hashed name, so "css/style.css" is also served as
+// "css/style.3f2a1b9c04d2.css". Templates link to the hashed name through the
+// asset function, which lets browsers cache it forever; the plain name stays
+// available with a revalidating Cache-Control for links that cannot be
+// rewritten. Precompressed "name.br" and "name.gz" files next to an asset are
+// served to clients that accept them, and text files without a ".gz" variant
+// are gzipped once when loaded.
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	hashLength = 12
+
+	// immutableCacheControl is sent for fingerprinted names, whose content never changes
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// revalidateCacheControl is sent for plain names, which change on deploy
+	revalidateCacheControl = "public, no-cache"
+
+	// minGzipSize is the smallest file worth compressing
+	minGzipSize = 1024
+)
+
+// Options configures the asset handler
+type Options struct {
+	FS     fs.FS  // Asset files, e.g. an embed.FS; defaults to os.DirFS(Dir)
+	Dir    string // Directory used when FS is nil, defaults to "static"
+	Prefix string // URL path the assets are mounted at, defaults to "/static/"
+	Reload bool   // Re-read the files when they change on disk; for development only
+}
+
+// asset is one loaded file and its compressed variants
+type asset struct {
+	name    string // Logical name, e.g. "css/style.css"
+	hashed  string // Fingerprinted name, e.g. "css/style.3f2a1b9c04d2.css"
+	hash    string
+	content []byte
+	gzip    []byte
+	brotli  []byte
+	modTime time.Time
+}
+
+// Assets holds the loaded files and serves them over HTTP
+type Assets struct {
+	options Options
+
+	mu       sync.RWMutex
+	byName   map[string]*asset // Logical and fingerprinted names
+	manifest map[string]string // Logical name to fingerprinted name
+	stamp    string            // Summary of the loaded files, see stampFiles
+
+	reloadMu sync.Mutex // Lets one request at a time check for changes
+}
+
+// New loads every file below the asset root
+func New(options Options) (*Assets, error) {
+	if options.FS == nil {
+		if options.Dir == "" {
+			options.Dir = "static"
+		}
+		options.FS = os.DirFS(options.Dir)
+	}
+	if options.Prefix == "" {
+		options.Prefix = "/static/"
+	}
+	if !strings.HasSuffix(options.Prefix, "/") {
+		options.Prefix += "/"
+	}
+
+	a := &Assets{options: options}
+	if err := a.Load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Load reads and fingerprints the files, replacing the current set on success
+func (a *Assets) Load() error {
+	files := map[string][]byte{}
+	modTimes := map[string]time.Time{}
+	stamp := sha256.New()
+	err := fs.WalkDir(a.options.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		content, err := fs.ReadFile(a.options.FS, p)
+		if err != nil {
+			return err
+		}
+		files[p] = content
+		modTimes[p] = info.ModTime()
+		addToStamp(stamp, p, info)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("assets: %w", err)
+	}
+
+	byName := map[string]*asset{}
+	manifest := map[string]string{}
+	for name, content := range files {
+		// Compressed variants are served alongside their original, not on their own
+		if original := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".br"); original != name {
+			if _, ok := files[original]; ok {
+				continue
+			}
+		}
+
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])[:hashLength]
+		item := &asset{
+			name:    name,
+			hashed:  fingerprint(name, hash),
+			hash:    hash,
+			content: content,
+			gzip:    files[name+".gz"],
+			brotli:  files[name+".br"],
+			modTime: modTimes[name],
+		}
+		if item.gzip == nil && len(content) >= minGzipSize && compressible(name) {
+			if item.gzip, err = gzipBytes(content); err != nil {
+				return fmt.Errorf("assets: compressing %s: %w", name, err)
+			}
+		}
+
+		byName[item.name] = item
+		byName[item.hashed] = item
+		manifest[item.name] = item.hashed
+	}
+
+	a.mu.Lock()
+	a.byName = byName
+	a.manifest = manifest
+	a.stamp = hex.EncodeToString(stamp.Sum(nil))
+	a.mu.Unlock()
+	return nil
+}
+
+// reload loads the files again if any has been added, removed or modified
+// since they were last loaded. Errors are logged and the previous files kept,
+// so a half-saved file does not take the site down.
+func (a *Assets) reload() {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	stamp, err := a.stampFiles()
+	if err == nil {
+		a.mu.RLock()
+		changed := stamp != a.stamp
+		a.mu.RUnlock()
+		if !changed {
+			return
+		}
+		err = a.Load()
+	}
+	if err != nil {
+		log.Printf("assets: reloading: %v", err)
+	}
+}
+
+// stampFiles summarizes the names, sizes and modification times of the files,
+// which tells whether they changed without reading them
+func (a *Assets) stampFiles() (string, error) {
+	stamp := sha256.New()
+	err := fs.WalkDir(a.options.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		addToStamp(stamp, p, info)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("assets: %w", err)
+	}
+	return hex.EncodeToString(stamp.Sum(nil)), nil
+}
+
+// addToStamp adds one file to a stamp
+func addToStamp(stamp io.Writer, name string, info fs.FileInfo) {
+	fmt.Fprintf(stamp, "%s\x00%d\x00%d\n", name, info.Size(), info.ModTime().UnixNano())
+}
+
+// Manifest returns a copy of the logical to fingerprinted name mapping
+func (a *Assets) Manifest() map[string]string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	manifest := make(map[string]string, len(a.manifest))
+	for name, hashed := range a.manifest {
+		manifest[name] = hashed
+	}
+	return manifest
+}
+
+// Path returns the URL of an asset under its fingerprinted name, for the
+// template function "asset". Unknown names are returned unhashed so a typo
+// shows up as a 404 rather than a template error.
+func (a *Assets) Path(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if a.options.Reload {
+		a.reload()
+	}
+
+	a.mu.RLock()
+	hashed, ok := a.manifest[name]
+	a.mu.RUnlock()
+	if !ok {
+		return a.options.Prefix + name
+	}
+	return a.options.Prefix + hashed
+}
+
+// ServeHTTP serves an asset by logical or fingerprinted name. Directories are
+// never listed.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if a.options.Reload {
+		a.reload()
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, a.options.Prefix)
+	a.mu.RLock()
+	item, ok := a.byName[name]
+	a.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	header := w.Header()
+	if name == item.hashed && !a.options.Reload {
+		header.Set("Cache-Control", immutableCacheControl)
+	} else {
+		header.Set("Cache-Control", revalidateCacheControl)
+	}
+	if contentType := mime.TypeByExtension(path.Ext(item.name)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	content, etag := item.content, item.hash
+	if item.gzip != nil || item.brotli != nil {
+		header.Add("Vary", "Accept-Encoding")
+		switch accepted := r.Header.Get("Accept-Encoding"); {
+		case item.brotli != nil && acceptsEncoding(accepted, "br"):
+			content, etag = item.brotli, item.hash+".br"
+			header.Set("Content-Encoding", "br")
+		case item.gzip != nil && acceptsEncoding(accepted, "gzip"):
+			content, etag = item.gzip, item.hash+".gz"
+			header.Set("Content-Encoding", "gzip")
+		}
+	}
+	header.Set("ETag", `"`+etag+`"`)
+
+	http.ServeContent(w, r, item.name, item.modTime, bytes.NewReader(content))
+}
+
+// fingerprint inserts the hash before the file extension
+func fingerprint(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// compressible reports whether a file is text worth gzipping
+func compressible(name string) bool {
+	switch path.Ext(name) {
+	case ".css", ".js", ".mjs", ".json", ".map", ".svg", ".html", ".txt", ".xml":
+		return true
+	}
+	return false
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows an encoding
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != encoding {
+			continue
+		}
+		for _, param := range fields[1:] {
+			if q := strings.TrimSpace(param); q == "q=0" || q == "q=0.0" || q == "q=0.00" || q == "q=0.000" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func gzipBytes(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(content); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,366 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUserPageSize = 20
+	maxUserPageSize     = 100
+)
+
+// sortableUserColumns maps the sort keys accepted by ListUsers to their columns
+var sortableUserColumns = map[string]string{
+	"id":          "id",
+	"username":    "username",
+	"email":       "email",
+	"date_joined": "date_joined",
+}
+
+// UserFilter describes the search, filter, sort and pagination options for ListUsers
+type UserFilter struct {
+	Search         string
+	IsActive       *bool
+	IsSuperuser    *bool
+	DateJoinedFrom *time.Time
+	DateJoinedTo   *time.Time
+	SortBy         string
+	SortDesc       bool
+	Cursor         string
+	Limit          int
+	Deleted        bool // Users removed by DeleteUser instead of current ones
+}
+
+// UserPage is a single page of users returned by ListUsers
+type UserPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// userCursor is the opaque position encoded into UserPage.NextCursor
+type userCursor struct {
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// ListUsers calls ListUsersContext with the service's context
+func (s *Service) ListUsers(filter UserFilter) (*UserPage, error) {
+	return s.ListUsersContext(s.context(), filter)
+}
+
+// ListUsersContext returns users matching the filter using keyset (cursor) pagination
+func (s *Service) ListUsersContext(ctx context.Context, filter UserFilter) (*UserPage, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if filter.SortBy == "" {
+		filter.SortBy = "id"
+	}
+	column, ok := sortableUserColumns[filter.SortBy]
+	if !ok {
+		return nil, ErrInvalidSortField
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultUserPageSize
+	}
+	if filter.Limit > maxUserPageSize {
+		filter.Limit = maxUserPageSize
+	}
+
+	query := UserQuery{
+		Search:         strings.TrimSpace(filter.Search),
+		IsActive:       filter.IsActive,
+		IsSuperuser:    filter.IsSuperuser,
+		DateJoinedFrom: filter.DateJoinedFrom,
+		DateJoinedTo:   filter.DateJoinedTo,
+		SortBy:         filter.SortBy,
+		SortDesc:       filter.SortDesc,
+		Deleted:        filter.Deleted,
+		// Fetch one extra row to know whether another page exists
+		Limit: filter.Limit + 1,
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeUserCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if query.AfterValue, err = cursorValue(column, cursor.Value); err != nil {
+			return nil, err
+		}
+		query.AfterID = cursor.ID
+	}
+
+	users, err := s.store().Users().List(s.context(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &UserPage{Users: users}
+	if len(users) > filter.Limit {
+		page.Users = users[:filter.Limit]
+		last := page.Users[len(page.Users)-1]
+		page.NextCursor = encodeUserCursor(userCursor{
+			Value: userSortValue(column, &last),
+			ID:    last.ID,
+		})
+	}
+
+	return page, nil
+}
+
+// CreateUser calls CreateUserContext with the service's context
+func (s *Service) CreateUser(user User, password string) (*User, error) {
+	return s.CreateUserContext(s.context(), user, password)
+}
+
+// CreateUserContext registers a user on behalf of an administrator, honouring the
+// is_active and is_superuser flags that self-registration ignores
+func (s *Service) CreateUserContext(ctx context.Context, user User, password string) (*User, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	isActive := user.IsActive
+
+	var userID uint
+	err := s.atomically(func(s *Service) error {
+		var err error
+		if userID, err = s.Register(user, password); err != nil {
+			return err
+		}
+
+		// GORM skips zero values on create, so an inactive account needs an explicit update
+		if !isActive {
+			return s.store().Users().Update(s.context(), userID, map[string]interface{}{"is_active": false})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(AuditUserCreated, 0, userID, AuditMetadata{"is_active": isActive, "is_superuser": user.IsSuperuser})
+
+	return s.GetUserByID(userID)
+}
+
+// DeactivateUser calls DeactivateUserContext with the service's context
+func (s *Service) DeactivateUser(userID uint) error {
+	return s.DeactivateUserContext(s.context(), userID)
+}
+
+// DeactivateUserContext marks a user as inactive so they can no longer log in
+// and revokes their sessions, ending access with tokens already issued
+func (s *Service) DeactivateUserContext(ctx context.Context, userID uint) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	now := time.Now()
+	event := UserDeactivated{UserID: userID, OccurredAt: now}
+	err := s.transaction(func(tx Store) error {
+		if _, err := s.lockUser(tx, userID); err != nil {
+			return err
+		}
+		if err := tx.Users().Update(s.context(), userID, map[string]interface{}{"is_active": false}); err != nil {
+			return err
+		}
+		if _, err := tx.Sessions().RevokeOthers(s.context(), userID, 0, now); err != nil {
+			return err
+		}
+		return s.enqueueEvent(tx, event)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.publish(event)
+	s.audit(AuditUserDeactivated, 0, userID, nil)
+	return nil
+}
+
+// DeleteUser calls DeleteUserContext with the service's context
+func (s *Service) DeleteUser(userID uint) error {
+	return s.DeleteUserContext(s.context(), userID)
+}
+
+// DeleteUserContext soft-deletes a user: they disappear from lookups and their
+// sessions are revoked, but their records are kept so RestoreUser can bring
+// them back until PurgeDeletedUsers removes them for good
+func (s *Service) DeleteUserContext(ctx context.Context, userID uint) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	now := time.Now()
+	err := s.transaction(func(tx Store) error {
+		if err := tx.Users().SoftDelete(s.context(), userID, now); err != nil {
+			return err
+		}
+		_, err := tx.Sessions().RevokeOthers(s.context(), userID, 0, now)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	s.audit(AuditUserDeleted, 0, userID, nil)
+	return nil
+}
+
+// RestoreUser calls RestoreUserContext with the service's context
+func (s *Service) RestoreUser(userID uint) error {
+	return s.RestoreUserContext(s.context(), userID)
+}
+
+// RestoreUserContext undoes DeleteUser. It fails with ErrUsernameExists or
+// ErrEmailExists when another user has taken the username or email since.
+func (s *Service) RestoreUserContext(ctx context.Context, userID uint) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if err := s.store().Users().Restore(s.context(), userID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	s.audit(AuditUserRestored, 0, userID, nil)
+	return nil
+}
+
+// PurgeDeletedUsers calls PurgeDeletedUsersContext with the service's context
+func (s *Service) PurgeDeletedUsers() (int, error) {
+	return s.PurgeDeletedUsersContext(s.context())
+}
+
+// PurgeDeletedUsersContext permanently removes the users deleted more than
+// Config.DeletedUserRetention ago, along with their records, and returns how
+// many it removed. Audit events and account deletion requests are kept.
+func (s *Service) PurgeDeletedUsersContext(ctx context.Context) (int, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	cutoff := time.Now().Add(-s.config.DeletedUserRetention)
+	query := UserQuery{Deleted: true, DeletedBefore: &cutoff, SortBy: "id", Limit: maxUserPageSize}
+
+	purged := 0
+	for {
+		users, err := s.store().Users().List(s.context(), query)
+		if err != nil {
+			return purged, err
+		}
+
+		for _, user := range users {
+			restored := false
+			err := s.transaction(func(tx Store) error {
+				// The user may have been restored since the query above, or
+				// be restored concurrently; Purge only deletes them if not
+				err := tx.Users().Purge(s.context(), user.ID, cutoff)
+				if errors.Is(err, ErrNotFound) {
+					restored = true
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				return tx.LoginAttempts().DeleteForUser(s.context(), user.ID)
+			})
+			if err != nil {
+				log.Printf("Failed to purge user %d: %v", user.ID, err)
+				continue
+			}
+			if restored {
+				continue
+			}
+			s.audit(AuditUserPurged, 0, user.ID, AuditMetadata{"deleted_at": user.DeletedAt.Time})
+			purged++
+		}
+
+		if len(users) < query.Limit {
+			return purged, nil
+		}
+		query.AfterID = users[len(users)-1].ID
+	}
+}
+
+// StartPurgeWorker runs PurgeDeletedUsers on the given interval until the
+// returned stop function is called
+func (s *Service) StartPurgeWorker(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if n, err := s.PurgeDeletedUsers(); err != nil {
+					log.Printf("Deleted user purge failed: %v", err)
+				} else if n > 0 {
+					log.Printf("Purged %d deleted user(s)", n)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// encodeUserCursor serializes a cursor into an opaque URL-safe string
+func encodeUserCursor(cursor userCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeUserCursor parses a cursor produced by encodeUserCursor
+func decodeUserCursor(raw string) (*userCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor userCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+// userSortValue returns the string form of the sort column for a user
+func userSortValue(column string, user *User) string {
+	switch column {
+	case "username":
+		return user.Username
+	case "email":
+		return user.Email
+	case "date_joined":
+		return user.DateJoined.UTC().Format(time.RFC3339Nano)
+	default:
+		return ""
+	}
+}
+
+// cursorValue converts a cursor value back into the type of its sort column
+func cursorValue(column, value string) (interface{}, error) {
+	if column != "date_joined" {
+		return value, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return t, nil
+}
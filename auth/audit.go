@@ -0,0 +1,357 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit actions recorded by the Service
+const (
+	AuditUserRegistered           = "user.registered"
+	AuditLoginSucceeded           = "auth.login_succeeded"
+	AuditLoginFailed              = "auth.login_failed"
+	AuditPasswordChanged          = "auth.password_changed"
+	AuditPasswordChangeFailed     = "auth.password_change_failed"
+	AuditPasswordReset            = "auth.password_reset"
+	AuditOTPGenerated             = "auth.otp_generated"
+	AuditOTPVerified              = "auth.otp_verified"
+	AuditOTPFailed                = "auth.otp_failed"
+	AuditProfileUpdated           = "user.profile_updated"
+	AuditEmailChangeRequested     = "user.email_change_requested"
+	AuditEmailChanged             = "user.email_changed"
+	AuditDataExported             = "user.data_exported"
+	AuditAccountDeletionRequested = "user.deletion_requested"
+	AuditAccountDeletionCancelled = "user.deletion_cancelled"
+	AuditAccountErased            = "user.erased"
+	AuditUserCreated              = "admin.user_created"
+	AuditUserUpdated              = "admin.user_updated"
+	AuditUserDeactivated          = "admin.user_deactivated"
+	AuditUserDeleted              = "admin.user_deleted"
+	AuditUserRestored             = "admin.user_restored"
+	AuditUserPurged               = "admin.user_purged"
+	AuditImpersonationStarted     = "admin.impersonation_started"
+	AuditImpersonationStopped     = "admin.impersonation_stopped"
+	AuditSessionRevoked           = "user.session_revoked"
+	AuditLoginStepUpRequired      = "auth.login_step_up_required"
+	AuditReauthenticated          = "auth.reauthenticated"
+	AuditReauthenticationFailed   = "auth.reauthentication_failed"
+	AuditLoggedOut                = "auth.logged_out"
+)
+
+// failedAttemptActions are the audit actions recording an attempt that failed.
+// Inside atomically they are kept even when the transaction rolls back, since
+// the rollback does not undo the attempt; every other action records a change
+// and is only kept when it commits.
+var failedAttemptActions = map[string]bool{
+	AuditLoginFailed:            true,
+	AuditPasswordChangeFailed:   true,
+	AuditOTPFailed:              true,
+	AuditLoginStepUpRequired:    true,
+	AuditReauthenticationFailed: true,
+}
+
+// AuditMetadata is free-form detail attached to an audit event, stored as JSON
+type AuditMetadata map[string]interface{}
+
+// Value implements driver.Valuer
+func (m AuditMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (m *AuditMetadata) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*m = AuditMetadata{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported audit metadata type %T", value)
+	}
+	return json.Unmarshal(data, m)
+}
+
+// AuditEvent is a security-relevant event such as a login or an admin action.
+// Events are only ever appended; when Config.AuditHashChain is enabled each
+// event also carries the hash of its predecessor so tampering is detectable.
+type AuditEvent struct {
+	ID        uint          `gorm:"primaryKey" json:"id"`
+	ActorID   *uint         `gorm:"index" json:"actor_id"`
+	TargetID  *uint         `gorm:"index" json:"target_id"`
+	Action    string        `gorm:"size:64;index" json:"action"`
+	IP        string        `gorm:"size:64" json:"ip"`
+	UserAgent string        `gorm:"size:255" json:"user_agent"`
+	Metadata  AuditMetadata `gorm:"type:text" json:"metadata"`
+	CreatedAt time.Time     `gorm:"index" json:"created_at"`
+	PrevHash  string        `gorm:"size:64" json:"prev_hash,omitempty"`
+	Hash      string        `gorm:"size:64" json:"hash,omitempty"`
+}
+
+// auditChainHeadID is the ID of the single AuditChainHead row
+const auditChainHeadID = 1
+
+// AuditChainHead holds the hash of the newest event in the hash chain. Writers
+// lock its one row in the transaction that appends an event, so the events of
+// every server instance link into a single chain.
+type AuditChainHead struct {
+	ID   uint   `gorm:"primaryKey;autoIncrement:false"`
+	Hash string `gorm:"size:64;not null;default:''"`
+}
+
+// AuditFilter narrows the events returned by ListAuditEvents
+type AuditFilter struct {
+	ActorID  uint
+	TargetID uint
+	Action   string
+	From     *time.Time
+	To       *time.Time
+	BeforeID uint // Cursor: only return events older than this ID
+	Limit    int
+}
+
+// RequestInfo describes the HTTP request a Service call is made on behalf of
+type RequestInfo struct {
+	IP        string
+	UserAgent string
+	ActorID   uint // Authenticated user making the request, if any
+}
+
+// ForRequest returns a copy of the service that attributes audit events to the
+// client and authenticated user of the given request and runs its queries with
+// the request's context
+func (s *Service) ForRequest(r *http.Request) *Service {
+	scoped := *s
+	scoped.ctx = r.Context()
+	scoped.request = RequestInfo{
+		IP:        clientIP(r, s.config.TrustProxyHeaders, s.config.TrustedProxyHops),
+		UserAgent: r.UserAgent(),
+	}
+	if claims, err := GetUserFromContext(r.Context()); err == nil {
+		// During impersonation the real actor is the impersonating administrator
+		if claims.IsImpersonated() {
+			scoped.request.ActorID = claims.Actor.UserID
+		} else {
+			scoped.request.ActorID = claims.UserID
+		}
+	}
+	return &scoped
+}
+
+// audit records an event, logging rather than failing the calling operation on error.
+// An actorID of zero falls back to the authenticated user of the current request.
+func (s *Service) audit(action string, actorID, targetID uint, metadata AuditMetadata) {
+	// Inside atomically the event waits for the transaction, so the writer does
+	// not queue behind its locks: a change is recorded once it commits, a failed
+	// attempt once it ends either way
+	if s.work != nil {
+		outside := *s
+		outside.work = nil
+		record := func() { outside.audit(action, actorID, targetID, metadata) }
+		if failedAttemptActions[action] {
+			s.work.afterEnd = append(s.work.afterEnd, record)
+		} else {
+			s.work.afterCommit = append(s.work.afterCommit, record)
+		}
+		return
+	}
+
+	if actorID == 0 {
+		actorID = s.request.ActorID
+	}
+
+	event := AuditEvent{
+		Action:    action,
+		IP:        s.request.IP,
+		UserAgent: truncate(s.request.UserAgent, 255),
+		Metadata:  metadata,
+		// Truncated so the hash survives the precision of every supported database
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if actorID > 0 {
+		event.ActorID = &actorID
+	}
+	if targetID > 0 {
+		event.TargetID = &targetID
+	}
+
+	if err := s.appendAuditEvent(&event); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// appendAuditEvent inserts an event, linking it into the hash chain when enabled
+func (s *Service) appendAuditEvent(event *AuditEvent) error {
+	s = s.detached()
+	if !s.config.AuditHashChain {
+		return s.store().AuditEvents().Create(s.context(), event)
+	}
+
+	// Locking the chain head serializes writers across processes, so every
+	// event links to the one inserted just before it
+	return s.transaction(func(tx Store) error {
+		prevHash, err := tx.AuditEvents().LockChainHead(s.context())
+		if err != nil {
+			return err
+		}
+
+		event.PrevHash = prevHash
+		event.Hash = event.computeHash()
+		if err := tx.AuditEvents().Create(s.context(), event); err != nil {
+			return err
+		}
+		return tx.AuditEvents().SetChainHead(s.context(), event.Hash)
+	})
+}
+
+// computeHash returns the SHA-256 over the event's content and its predecessor's hash
+func (e *AuditEvent) computeHash() string {
+	metadata, _ := e.Metadata.Value()
+	parts := []string{
+		e.PrevHash,
+		optionalID(e.ActorID),
+		optionalID(e.TargetID),
+		e.Action,
+		e.IP,
+		e.UserAgent,
+		fmt.Sprint(metadata),
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ListAuditEvents calls ListAuditEventsContext with the service's context
+func (s *Service) ListAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	return s.ListAuditEventsContext(s.context(), filter)
+}
+
+// ListAuditEventsContext returns audit events matching the filter, newest first
+func (s *Service) ListAuditEventsContext(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultUserPageSize
+	}
+	if filter.Limit > maxUserPageSize {
+		filter.Limit = maxUserPageSize
+	}
+
+	return s.store().AuditEvents().List(s.context(), filter)
+}
+
+// VerifyAuditChain calls VerifyAuditChainContext with the service's context
+func (s *Service) VerifyAuditChain() (uint, error) {
+	return s.VerifyAuditChainContext(s.context())
+}
+
+// VerifyAuditChainContext walks the hash chain from the first hashed event and returns
+// ErrAuditChainBroken along with the ID of the first event that fails verification.
+// The chain must start from an empty hash and reach the recorded chain head, so
+// events removed from either end are caught as well
+func (s *Service) VerifyAuditChainContext(ctx context.Context) (uint, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	// The head is read first: events chained during the walk come after it
+	head, err := s.store().AuditEvents().ChainHead(s.context())
+	if err != nil {
+		return 0, err
+	}
+
+	var brokenID, lastID uint
+	prevHash := ""
+	reachedHead := head == ""
+
+	err = s.store().AuditEvents().EachHashed(s.context(), 500, func(batch []AuditEvent) error {
+		for i := range batch {
+			event := &batch[i]
+			if event.PrevHash != prevHash || event.computeHash() != event.Hash {
+				brokenID = event.ID
+				return ErrAuditChainBroken
+			}
+			if event.Hash == head {
+				reachedHead = true
+			}
+			prevHash = event.Hash
+			lastID = event.ID
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrAuditChainBroken) {
+			return brokenID, ErrAuditChainBroken
+		}
+		return 0, err
+	}
+	if !reachedHead {
+		// The newest events are missing, the chain ends early
+		return lastID, ErrAuditChainBroken
+	}
+
+	return 0, nil
+}
+
+// clientIP returns the request's client address, honouring X-Forwarded-For only when trusted.
+// Each of the trustedHops proxies appends the address it received the request
+// from, so the entry trustedHops from the right is the last one a trusted proxy
+// wrote; anything left of it was sent by the client and could be forged
+func clientIP(r *http.Request, trustProxy bool, trustedHops int) string {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			entries := strings.Split(strings.Join(forwarded, ","), ",")
+			i := len(entries) - trustedHops
+			if i < 0 {
+				i = 0
+			}
+			return strings.TrimSpace(entries[i])
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// optionalID formats a nullable ID for hashing
+func optionalID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
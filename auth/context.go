@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+)
+
+// unitOfWork is a transaction shared by several Service calls and the work
+// waiting for it to end
+type unitOfWork struct {
+	store       Store
+	afterCommit []func() // Events, notifications, after-hooks and audit events of changes
+	afterEnd    []func() // Audit events of failed attempts, recorded whether or not it commits
+}
+
+// WithContext returns a copy of the service whose queries run with ctx, so
+// cancelling it or reaching its deadline aborts them. Each method also has a
+// context-first variant, such as RegisterContext, that does the same for one
+// call and applies Config.QueryTimeout.
+func (s *Service) WithContext(ctx context.Context) *Service {
+	scoped := *s
+	scoped.ctx = ctx
+	return &scoped
+}
+
+// context returns the context queries run with, Background unless the service
+// was scoped by WithContext or ForRequest
+func (s *Service) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// store returns the configured store, or the open transaction inside atomically
+func (s *Service) store() Store {
+	if s.work != nil {
+		return s.work.store
+	}
+	return s.config.Store
+}
+
+// transaction runs fn in a transaction of the store; inside atomically it
+// becomes a nested one
+func (s *Service) transaction(fn func(tx Store) error) error {
+	return s.store().Transaction(s.context(), fn)
+}
+
+// atomically runs several Service calls as one transaction. fn receives a
+// copy of the service whose queries share it; events, notifications,
+// after-hooks and audit events are held back until it commits, and audit
+// events of failed attempts until it ends.
+func (s *Service) atomically(fn func(s *Service) error) error {
+	var work *unitOfWork
+	err := s.transaction(func(tx Store) error {
+		// A retry starts over, dropping what the failed attempt queued
+		work = &unitOfWork{store: tx}
+		scoped := *s
+		scoped.work = work
+		return fn(&scoped)
+	})
+	if work == nil {
+		return err
+	}
+
+	if s.work != nil {
+		if err == nil {
+			s.work.afterCommit = append(s.work.afterCommit, work.afterCommit...)
+		}
+		s.work.afterEnd = append(s.work.afterEnd, work.afterEnd...)
+		return err
+	}
+
+	for _, f := range work.afterEnd {
+		f()
+	}
+	if err == nil {
+		for _, f := range work.afterCommit {
+			f()
+		}
+	}
+	return err
+}
+
+// afterCommit runs fn once the current unit of work commits, or right away
+// outside atomically
+func (s *Service) afterCommit(fn func()) {
+	if s.work != nil {
+		s.work.afterCommit = append(s.work.afterCommit, fn)
+		return
+	}
+	fn()
+}
+
+// detached keeps the values of the service's context but not its
+// cancellation, for records such as audit events that must not be lost when
+// the client goes away
+func (s *Service) detached() *Service {
+	return s.WithContext(context.WithoutCancel(s.context()))
+}
+
+// scope binds the service to ctx for one call, limited to Config.QueryTimeout
+func (s *Service) scope(ctx context.Context) (*Service, context.CancelFunc) {
+	if s.config.QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.QueryTimeout)
+		return s.WithContext(ctx), cancel
+	}
+	return s.WithContext(ctx), func() {}
+}
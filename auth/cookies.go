@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/utils"
+)
+
+// Cookie and header names used in cookie mode
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	CSRFCookie         = "csrf_token"
+	CSRFHeader         = "X-CSRF-Token"
+	CSRFFormField      = "csrf_token"
+
+	// refreshCookiePath limits the refresh token to the endpoints that use it,
+	// unless Config.RefreshCookiePath says otherwise
+	refreshCookiePath = "/api/auth"
+)
+
+// TokenPair is an access token together with the refresh token that renews it
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+	UserID           uint
+}
+
+// CookieMode reports whether tokens are delivered in cookies rather than response bodies
+func (s *Service) CookieMode() bool {
+	return s.config.CookieMode
+}
+
+// NewTokenPair calls NewTokenPairContext with the service's context
+func (s *Service) NewTokenPair(accessToken string) (*TokenPair, error) {
+	return s.NewTokenPairContext(s.context(), accessToken)
+}
+
+// NewTokenPairContext attaches a refresh token to the session of an access token issued
+// by Login, extending the session to the refresh token's lifetime
+func (s *Service) NewTokenPairContext(ctx context.Context, accessToken string) (*TokenPair, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	claims, err := s.VerifyJWT(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID == 0 {
+		return nil, ErrSessionNotFound
+	}
+
+	refreshToken, refreshExpiresAt, err := s.rotateRefreshToken(claims.SessionID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  time.Unix(claims.ExpiresAt, 0),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+		UserID:           claims.UserID,
+	}, nil
+}
+
+// RefreshSession calls RefreshSessionContext with the service's context
+func (s *Service) RefreshSession(refreshToken string) (*TokenPair, error) {
+	return s.RefreshSessionContext(s.context(), refreshToken)
+}
+
+// RefreshSessionContext exchanges a refresh token for a new access token and a new
+// refresh token; the old refresh token stops working. The access token keeps
+// the session's original auth_time and amr.
+func (s *Service) RefreshSessionContext(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if refreshToken == "" {
+		return nil, ErrInvalidToken
+	}
+	refreshHash := hashToken(refreshToken)
+
+	session, err := s.store().Sessions().GetByRefreshHash(s.context(), refreshHash, time.Now())
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	model, err := s.GetUserModel(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !model.AuthUser().IsActive {
+		return nil, ErrUserInactive
+	}
+
+	// Rotating only if the hash is unchanged makes concurrent refreshes with the same token fail
+	newRefresh, refreshExpiresAt, err := s.rotateRefreshToken(session.ID, refreshHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var amr []string
+	if session.AMR != "" {
+		amr = strings.Split(session.AMR, ",")
+	}
+	accessToken, accessExpiresAt, err := s.generateToken(model, tokenOptions{
+		sessionID: session.ID,
+		authTime:  session.CreatedAt,
+		amr:       amr,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     newRefresh,
+		RefreshExpiresAt: refreshExpiresAt,
+		UserID:           session.UserID,
+	}, nil
+}
+
+// rotateRefreshToken stores a new refresh token on the session, which must
+// still carry currentHash when it is not empty
+func (s *Service) rotateRefreshToken(sessionID uint, currentHash string) (string, time.Time, error) {
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(s.config.RefreshTokenDuration)
+
+	rotated, err := s.store().Sessions().RotateRefresh(s.context(), sessionID, currentHash, hashToken(token), expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !rotated {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	return token, expiresAt, nil
+}
+
+// Logout calls LogoutContext with the service's context
+func (s *Service) Logout(claims *TokenClaims) error {
+	return s.LogoutContext(s.context(), claims)
+}
+
+// LogoutContext ends the session behind the claims. Tokens without a session are
+// left to expire on their own.
+func (s *Service) LogoutContext(ctx context.Context, claims *TokenClaims) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if claims.SessionID == 0 {
+		return nil
+	}
+	return s.endSession(claims.UserID, claims.SessionID)
+}
+
+// LogoutRefreshToken calls LogoutRefreshTokenContext with the service's context
+func (s *Service) LogoutRefreshToken(refreshToken string) error {
+	return s.LogoutRefreshTokenContext(s.context(), refreshToken)
+}
+
+// LogoutRefreshTokenContext ends the session a refresh token belongs to, so
+// clients can sign out after their access token has expired. Unknown and
+// expired refresh tokens are ignored.
+func (s *Service) LogoutRefreshTokenContext(ctx context.Context, refreshToken string) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	session, err := s.store().Sessions().GetByRefreshHash(s.context(), hashToken(refreshToken), time.Now())
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return s.endSession(session.UserID, session.ID)
+}
+
+// endSession ends one of a user's sessions
+func (s *Service) endSession(userID, sessionID uint) error {
+	if err := s.store().Sessions().End(s.context(), userID, sessionID, time.Now()); err != nil {
+		return err
+	}
+
+	s.audit(AuditLoggedOut, userID, userID, AuditMetadata{"session_id": sessionID})
+	return nil
+}
+
+// SetAuthCookies writes the access, refresh and CSRF cookies for the pair and
+// returns the CSRF token the client must echo in the X-CSRF-Token header
+func (s *Service) SetAuthCookies(w http.ResponseWriter, pair *TokenPair) (string, error) {
+	csrfToken, err := generateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	s.SetSessionCookies(w, pair)
+	http.SetCookie(w, s.NewCookie(CSRFCookie, csrfToken, "/", pair.RefreshExpiresAt, false))
+
+	return csrfToken, nil
+}
+
+// SetSessionCookies writes the access and refresh cookies for the pair but
+// keeps the CSRF cookie, so forms already on the page stay valid
+func (s *Service) SetSessionCookies(w http.ResponseWriter, pair *TokenPair) {
+	s.SetAccessCookie(w, pair.AccessToken, pair.AccessExpiresAt)
+	http.SetCookie(w, s.NewCookie(RefreshTokenCookie, pair.RefreshToken, s.config.RefreshCookiePath, pair.RefreshExpiresAt, true))
+}
+
+// SetAccessCookie replaces only the access token cookie, e.g. with an elevated token
+func (s *Service) SetAccessCookie(w http.ResponseWriter, token string, expiresAt time.Time) {
+	http.SetCookie(w, s.NewCookie(AccessTokenCookie, token, "/", expiresAt, true))
+}
+
+// ClearAuthCookies removes every auth cookie from the browser
+func (s *Service) ClearAuthCookies(w http.ResponseWriter) {
+	for _, c := range []*http.Cookie{
+		s.NewCookie(AccessTokenCookie, "", "/", time.Time{}, true),
+		s.NewCookie(RefreshTokenCookie, "", s.config.RefreshCookiePath, time.Time{}, true),
+		s.NewCookie(CSRFCookie, "", "/", time.Time{}, false),
+	} {
+		c.MaxAge = -1
+		http.SetCookie(w, c)
+	}
+}
+
+// NewCookie builds a cookie with the configured Domain, Secure and SameSite
+// attributes. A zero expires makes it a browser-session cookie.
+func (s *Service) NewCookie(name, value, path string, expires time.Time, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   s.config.CookieDomain,
+		Expires:  expires,
+		HttpOnly: httpOnly,
+		Secure:   !s.config.CookieInsecure,
+		SameSite: s.config.CookieSameSite,
+	}
+}
+
+// tokenFromRequest returns the access token from the Authorization header or,
+// in cookie mode, the access token cookie
+func (s *Service) tokenFromRequest(r *http.Request) (string, error) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return "", errors.New("Authorization header format must be Bearer <token>")
+		}
+		return parts[1], nil
+	}
+
+	if s.config.CookieMode {
+		if c, err := r.Cookie(AccessTokenCookie); err == nil && c.Value != "" {
+			return c.Value, nil
+		}
+	}
+
+	return "", errors.New("Authorization header is required")
+}
+
+// CSRFMiddleware protects state-changing requests authenticated by cookies using
+// the double-submit pattern: the X-CSRF-Token header, or the csrf_token field of
+// an HTML form, must match the csrf_token cookie. Requests with an Authorization
+// header carry no ambient credentials and are not checked.
+func (s *Service) CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.config.CookieMode || !isStateChanging(r.Method) || r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Only requests that carry auth cookies can be forged with the user's credentials
+		_, accessErr := r.Cookie(AccessTokenCookie)
+		_, refreshErr := r.Cookie(RefreshTokenCookie)
+		if accessErr != nil && refreshErr != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !ValidCSRFToken(r) {
+			utils.SendJSONError(w, "Missing or invalid CSRF token", http.StatusForbidden, "csrf_failed")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ValidCSRFToken reports whether the request echoes its csrf_token cookie in the
+// X-CSRF-Token header or the csrf_token form field
+func ValidCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(CSRFCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	submitted := r.Header.Get(CSRFHeader)
+	if submitted == "" {
+		submitted = r.PostFormValue(CSRFFormField)
+	}
+	return submitted != "" && subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(submitted)) == 1
+}
+
+func isStateChanging(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	default:
+		return true
+	}
+}
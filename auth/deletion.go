@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErasureMode controls how an account is erased once its deletion grace period ends
+type ErasureMode string
+
+const (
+	// ErasureAnonymize keeps the user row but replaces all personal data
+	ErasureAnonymize ErasureMode = "anonymize"
+	// ErasureHardDelete removes the user row and every related record
+	ErasureHardDelete ErasureMode = "delete"
+)
+
+// AccountDeletion tracks a user's request to delete their account. It has no
+// foreign key to users so the record survives a hard delete as proof of erasure.
+type AccountDeletion struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	UserID       uint       `gorm:"index" json:"user_id"`
+	RequestedAt  time.Time  `json:"requested_at"`
+	ScheduledFor time.Time  `gorm:"index" json:"scheduled_for"`
+	CancelledAt  *time.Time `json:"cancelled_at"`
+	CompletedAt  *time.Time `json:"completed_at"`
+}
+
+// RequestAccountDeletion calls RequestAccountDeletionContext with the service's context
+func (s *Service) RequestAccountDeletion(userID uint, password string) (*AccountDeletion, error) {
+	return s.RequestAccountDeletionContext(s.context(), userID, password)
+}
+
+// RequestAccountDeletionContext schedules the user's account for erasure after the
+// configured grace period. The current password is required to confirm intent.
+func (s *Service) RequestAccountDeletionContext(ctx context.Context, userID uint, password string) (*AccountDeletion, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.VerifyPassword(user.Password, password) {
+		return nil, ErrInvalidPassword
+	}
+
+	// Locking the user makes concurrent requests find each other's deletion
+	// instead of scheduling two
+	var deletion *AccountDeletion
+	created := false
+	err = s.atomically(func(s *Service) error {
+		created = false
+		if _, err := s.lockUser(s.store(), userID); err != nil {
+			return err
+		}
+
+		pending, err := s.GetPendingAccountDeletion(userID)
+		if err == nil {
+			deletion = pending
+			return nil
+		}
+		if !errors.Is(err, ErrNoPendingDeletion) {
+			return err
+		}
+
+		now := time.Now()
+		deletion = &AccountDeletion{
+			UserID:       userID,
+			RequestedAt:  now,
+			ScheduledFor: now.Add(s.config.DeletionGracePeriod),
+		}
+		created = true
+		return s.store().AccountDeletions().Create(s.context(), deletion)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return deletion, nil
+	}
+
+	s.audit(AuditAccountDeletionRequested, userID, userID, AuditMetadata{"scheduled_for": deletion.ScheduledFor})
+
+	s.notify(Notification{
+		To:      user.Email,
+		Subject: "Your account is scheduled for deletion",
+		Body: fmt.Sprintf("Your account and personal data will be permanently erased on %s. You can cancel the deletion until then.",
+			deletion.ScheduledFor.Format(time.RFC1123)),
+	})
+
+	return deletion, nil
+}
+
+// GetPendingAccountDeletion calls GetPendingAccountDeletionContext with the service's context
+func (s *Service) GetPendingAccountDeletion(userID uint) (*AccountDeletion, error) {
+	return s.GetPendingAccountDeletionContext(s.context(), userID)
+}
+
+// GetPendingAccountDeletionContext returns the user's outstanding deletion request
+func (s *Service) GetPendingAccountDeletionContext(ctx context.Context, userID uint) (*AccountDeletion, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	deletion, err := s.store().AccountDeletions().GetPending(s.context(), userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrNoPendingDeletion
+		}
+		return nil, err
+	}
+
+	return deletion, nil
+}
+
+// CancelAccountDeletion calls CancelAccountDeletionContext with the service's context
+func (s *Service) CancelAccountDeletion(userID uint) error {
+	return s.CancelAccountDeletionContext(s.context(), userID)
+}
+
+// CancelAccountDeletionContext withdraws a pending deletion request during its grace period
+func (s *Service) CancelAccountDeletionContext(ctx context.Context, userID uint) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	// A single conditional update cannot cancel a deletion the erasure worker
+	// has already claimed
+	cancelled, err := s.store().AccountDeletions().Cancel(s.context(), userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if !cancelled {
+		return ErrNoPendingDeletion
+	}
+
+	s.audit(AuditAccountDeletionCancelled, userID, userID, nil)
+
+	if user, err := s.GetUserByID(userID); err == nil {
+		s.notify(Notification{
+			To:      user.Email,
+			Subject: "Account deletion cancelled",
+			Body:    "The scheduled deletion of your account has been cancelled.",
+		})
+	}
+
+	return nil
+}
+
+// ProcessAccountDeletions calls ProcessAccountDeletionsContext with the service's context
+func (s *Service) ProcessAccountDeletions() (int, error) {
+	return s.ProcessAccountDeletionsContext(s.context())
+}
+
+// ProcessAccountDeletionsContext erases every account whose grace period has ended and
+// returns how many were erased
+func (s *Service) ProcessAccountDeletionsContext(ctx context.Context) (int, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	due, err := s.store().AccountDeletions().ListDue(s.context(), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	erased := 0
+	for _, deletion := range due {
+		err := s.transaction(func(tx Store) error {
+			// Claim the deletion first: it may have been cancelled since the
+			// query above, or claimed by another worker
+			claimed, err := tx.AccountDeletions().Complete(s.context(), deletion.ID, time.Now())
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				return ErrNoPendingDeletion
+			}
+
+			return s.eraseUser(tx, deletion.UserID)
+		})
+		if errors.Is(err, ErrNoPendingDeletion) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to erase account %d: %v", deletion.UserID, err)
+			continue
+		}
+		s.audit(AuditAccountErased, 0, deletion.UserID, AuditMetadata{"mode": string(s.config.ErasureMode)})
+		erased++
+	}
+
+	return erased, nil
+}
+
+// StartErasureWorker runs ProcessAccountDeletions on the given interval until
+// the returned stop function is called
+func (s *Service) StartErasureWorker(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if n, err := s.ProcessAccountDeletions(); err != nil {
+					log.Printf("Account erasure run failed: %v", err)
+				} else if n > 0 {
+					log.Printf("Erased %d account(s)", n)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// eraseUser removes a user's personal data across all auth tables according to the
+// erasure mode. Soft-deleted users are erased too; a user already purged only has
+// the rest of their data removed.
+//
+// Outbox events about the user have their username, email, IP and user agent
+// blanked. Webhook deliveries hold no payload of their own: they send the outbox
+// event, so pending ones carry the blanked payload. Audit events keep their
+// action, time and user IDs, which is the record of what happened; their IP and
+// user agent, and the login name of failed logins, are cleared. Events in the
+// hash chain are the exception and are kept whole: the chain covers those
+// fields and rewriting them would make the log fail verification, which is what
+// AuditHashChain is enabled for.
+func (s *Service) eraseUser(tx Store, userID uint) error {
+	ctx := s.context()
+	user, err := tx.Users().LockUnscoped(ctx, userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	purged := err != nil
+
+	if err := tx.OTPs().DeleteForUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := tx.EmailChanges().DeleteForUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := tx.Sessions().DeleteForUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := tx.KnownDevices().DeleteForUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := tx.LoginChallenges().DeleteForUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := tx.LoginAttempts().DeleteForUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.scrubOutbox(tx, userID); err != nil {
+		return err
+	}
+	var logins []string
+	if !purged {
+		logins = []string{user.Username, user.Email}
+	}
+	if err := s.scrubAuditEvents(tx, userID, logins); err != nil {
+		return err
+	}
+
+	if purged {
+		return nil
+	}
+	if s.config.ErasureMode == ErasureHardDelete {
+		return tx.Users().Delete(ctx, userID)
+	}
+
+	placeholder := fmt.Sprintf("deleted_%d", userID)
+	return tx.Users().UpdateUnscoped(ctx, userID, map[string]interface{}{
+		"username":         placeholder,
+		"email":            placeholder + "@deleted.invalid",
+		"password":         "",
+		"first_name":       "",
+		"last_name":        "",
+		"is_active":        false,
+		"is_superuser":     false,
+		"last_login":       nil,
+		"password_changed": nil,
+	})
+}
+
+// scrubOutbox blanks the personal fields of the outbox events about the user
+func (s *Service) scrubOutbox(tx Store, userID uint) error {
+	messages, err := tx.Outbox().ListForUser(s.context(), userID)
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(message.Payload), &payload); err != nil {
+			return err
+		}
+		changed := false
+		for _, field := range personalEventFields {
+			if value, ok := payload[field]; ok && value != "" {
+				payload[field] = ""
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+
+		// Keep user_id first, where ListForUser looks for it; the rest always
+		// holds at least the blanked field
+		delete(payload, "user_id")
+		rest, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if err := tx.Outbox().SetPayload(s.context(), message.ID, outboxUserPrefix(userID)+string(rest[1:])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scrubAuditEvents clears the client details of the audit events naming the user,
+// and the login name of failed logins made with one of logins, leaving hashed
+// events alone
+func (s *Service) scrubAuditEvents(tx Store, userID uint, logins []string) error {
+	events, err := tx.AuditEvents().ListForUser(s.context(), userID)
+	if err != nil {
+		return err
+	}
+	for _, login := range logins {
+		failed, err := tx.AuditEvents().ListFailedLogins(s.context(), login)
+		if err != nil {
+			return err
+		}
+		events = append(events, failed...)
+	}
+
+	for _, event := range events {
+		if event.Hash != "" {
+			continue
+		}
+		metadata := AuditMetadata{}
+		for key, value := range event.Metadata {
+			if key != "username" {
+				metadata[key] = value
+			}
+		}
+		if event.IP == "" && event.UserAgent == "" && len(metadata) == len(event.Metadata) {
+			continue
+		}
+		if err := tx.AuditEvents().Scrub(s.context(), event.ID, "", "", metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// EventType identifies a user lifecycle event
+type EventType string
+
+// User lifecycle events published by the Service
+const (
+	EventUserRegistered  EventType = "user.registered"
+	EventUserLoggedIn    EventType = "user.logged_in"
+	EventPasswordChanged EventType = "user.password_changed"
+	EventPasswordReset   EventType = "user.password_reset"
+	EventUserUpdated     EventType = "user.updated"
+	EventUserDeactivated EventType = "user.deactivated"
+)
+
+// EventTypes lists every event type the Service emits
+var EventTypes = []EventType{
+	EventUserRegistered,
+	EventUserLoggedIn,
+	EventPasswordChanged,
+	EventPasswordReset,
+	EventUserUpdated,
+	EventUserDeactivated,
+}
+
+// Event is a domain event emitted by the Service
+type Event interface {
+	Type() EventType
+}
+
+// UserRegistered is emitted after a new account is created
+type UserRegistered struct {
+	UserID     uint      `json:"user_id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Type implements Event
+func (UserRegistered) Type() EventType { return EventUserRegistered }
+
+// UserLoggedIn is emitted after a successful login
+type UserLoggedIn struct {
+	UserID     uint      `json:"user_id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Type implements Event
+func (UserLoggedIn) Type() EventType { return EventUserLoggedIn }
+
+// PasswordChanged is emitted after a user changes their own password
+type PasswordChanged struct {
+	UserID     uint      `json:"user_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Type implements Event
+func (PasswordChanged) Type() EventType { return EventPasswordChanged }
+
+// PasswordReset is emitted after a password is reset
+type PasswordReset struct {
+	UserID     uint      `json:"user_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Type implements Event
+func (PasswordReset) Type() EventType { return EventPasswordReset }
+
+// UserUpdated is emitted after an administrator updates a user
+type UserUpdated struct {
+	UserID      uint      `json:"user_id"`
+	Username    string    `json:"username"`
+	Email       string    `json:"email"`
+	IsActive    bool      `json:"is_active"`
+	IsSuperuser bool      `json:"is_superuser"`
+	OccurredAt  time.Time `json:"occurred_at"`
+}
+
+// Type implements Event
+func (UserUpdated) Type() EventType { return EventUserUpdated }
+
+// UserDeactivated is emitted when an account is made inactive
+type UserDeactivated struct {
+	UserID     uint      `json:"user_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Type implements Event
+func (UserDeactivated) Type() EventType { return EventUserDeactivated }
+
+// EventHandler reacts to a published event
+type EventHandler func(Event)
+
+// EventBus is a synchronous in-process publish/subscribe hub for domain events
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers map[EventType][]EventHandler
+	all      []EventHandler
+}
+
+// NewEventBus creates an empty event bus
+func NewEventBus() *EventBus {
+	return &EventBus{handlers: make(map[EventType][]EventHandler)}
+}
+
+// Subscribe registers a handler for one event type
+func (b *EventBus) Subscribe(eventType EventType, handler EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// SubscribeAll registers a handler for every event type
+func (b *EventBus) SubscribeAll(handler EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.all = append(b.all, handler)
+}
+
+// Publish calls every handler subscribed to the event. A panicking handler is
+// logged and does not prevent the remaining handlers from running.
+func (b *EventBus) Publish(event Event) {
+	b.mu.RLock()
+	handlers := make([]EventHandler, 0, len(b.handlers[event.Type()])+len(b.all))
+	handlers = append(handlers, b.handlers[event.Type()]...)
+	handlers = append(handlers, b.all...)
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Printf("Event handler for %s panicked: %v", event.Type(), err)
+				}
+			}()
+			handler(event)
+		}()
+	}
+}
+
+// OutboxMessage is an event persisted in the same transaction as the change
+// that caused it, guaranteeing at-least-once delivery to webhooks
+type OutboxMessage struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	EventType   EventType  `gorm:"size:64;index" json:"event_type"`
+	Payload     string     `gorm:"type:text" json:"payload"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ProcessedAt *time.Time `gorm:"index" json:"processed_at"`
+}
+
+// personalEventFields are the event payload fields holding personal data,
+// blanked when the user is erased
+var personalEventFields = []string{"username", "email", "ip", "user_agent"}
+
+// outboxUserPrefix is how the payload of an event about the user starts: every
+// event has user_id as its first field
+func outboxUserPrefix(userID uint) string {
+	return fmt.Sprintf(`{"user_id":%d,`, userID)
+}
+
+// Events returns the bus on which the Service publishes domain events
+func (s *Service) Events() *EventBus {
+	return s.config.EventBus
+}
+
+// enqueueEvent writes the event to the outbox using tx so it commits with the change
+func (s *Service) enqueueEvent(tx Store, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return tx.Outbox().Create(s.context(), &OutboxMessage{
+		EventType: event.Type(),
+		Payload:   string(payload),
+	})
+}
+
+// publish notifies in-process subscribers; call it only after the change has
+// committed. Inside atomically it waits for the unit of work to commit.
+func (s *Service) publish(event Event) {
+	s.afterCommit(func() { s.config.EventBus.Publish(event) })
+}
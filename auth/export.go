@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// UserDataExport is everything the auth package stores about a user
+type UserDataExport struct {
+	ExportedAt       time.Time           `json:"exported_at"`
+	User             User                `json:"user"`
+	OTPs             []OTPExport         `json:"otps"`
+	EmailChanges     []EmailChangeExport `json:"email_changes"`
+	Sessions         []Session           `json:"sessions"`
+	KnownDevices     []KnownDevice       `json:"known_devices"`
+	LoginAttempts    []LoginAttempt      `json:"login_attempts"`
+	AccountDeletions []AccountDeletion   `json:"account_deletions"`
+	AuditEvents      []AuditEvent        `json:"audit_events"`
+}
+
+// OTPExport is an OTP record without its secret value
+type OTPExport struct {
+	ID        uint      `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Verified  bool      `json:"verified"`
+}
+
+// EmailChangeExport is an email change request without its token
+type EmailChangeExport struct {
+	NewEmail    string     `json:"new_email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+}
+
+// ExportUserData calls ExportUserDataContext with the service's context
+func (s *Service) ExportUserData(userID uint) (*UserDataExport, error) {
+	return s.ExportUserDataContext(s.context(), userID)
+}
+
+// ExportUserDataContext collects the user's data for a self-service data export
+func (s *Service) ExportUserDataContext(ctx context.Context, userID uint) (*UserDataExport, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &UserDataExport{
+		ExportedAt:       time.Now(),
+		User:             *user,
+		OTPs:             []OTPExport{},
+		EmailChanges:     []EmailChangeExport{},
+		Sessions:         []Session{},
+		KnownDevices:     []KnownDevice{},
+		LoginAttempts:    []LoginAttempt{},
+		AccountDeletions: []AccountDeletion{},
+		AuditEvents:      []AuditEvent{},
+	}
+
+	otps, err := s.store().OTPs().ListForUser(s.context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, otp := range otps {
+		export.OTPs = append(export.OTPs, OTPExport{ID: otp.ID, ExpiresAt: otp.ExpiresAt, Verified: otp.Verified})
+	}
+
+	changes, err := s.store().EmailChanges().ListForUser(s.context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		export.EmailChanges = append(export.EmailChanges, EmailChangeExport{
+			NewEmail:    change.NewEmail,
+			CreatedAt:   change.CreatedAt,
+			ExpiresAt:   change.ExpiresAt,
+			ConfirmedAt: change.ConfirmedAt,
+		})
+	}
+
+	// Appending keeps the empty sections as [] rather than null in the JSON
+	sessions, err := s.store().Sessions().ListForUser(s.context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	export.Sessions = append(export.Sessions, sessions...)
+
+	devices, err := s.store().KnownDevices().ListForUser(s.context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	export.KnownDevices = append(export.KnownDevices, devices...)
+
+	attempts, err := s.store().LoginAttempts().ListForUser(s.context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	export.LoginAttempts = append(export.LoginAttempts, attempts...)
+
+	deletions, err := s.store().AccountDeletions().ListForUser(s.context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	export.AccountDeletions = append(export.AccountDeletions, deletions...)
+
+	events, err := s.store().AuditEvents().ListForUser(s.context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	export.AuditEvents = append(export.AuditEvents, events...)
+
+	s.audit(AuditDataExported, userID, userID, nil)
+
+	return export, nil
+}
+
+// WriteZip writes the export as a ZIP archive with one JSON file per section
+func (e *UserDataExport) WriteZip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"export.json", map[string]interface{}{"exported_at": e.ExportedAt}},
+		{"user.json", e.User},
+		{"otps.json", e.OTPs},
+		{"email_changes.json", e.EmailChanges},
+		{"sessions.json", e.Sessions},
+		{"known_devices.json", e.KnownDevices},
+		{"login_attempts.json", e.LoginAttempts},
+		{"account_deletions.json", e.AccountDeletions},
+		{"audit_events.json", e.AuditEvents},
+	}
+
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GeoLocation is the approximate location of an IP address
+type GeoLocation struct {
+	Country   string  `json:"country"`
+	Region    string  `json:"region"`
+	City      string  `json:"city"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// String formats the location as "City, Region, Country", skipping empty parts
+func (l GeoLocation) String() string {
+	parts := make([]string, 0, 3)
+	for _, part := range []string{l.City, l.Region, l.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// geoIPRange maps an inclusive range of addresses to a location
+type geoIPRange struct {
+	start    netip.Addr
+	end      netip.Addr
+	location GeoLocation
+}
+
+// GeoIPDatabase resolves IP addresses against a local range file
+type GeoIPDatabase struct {
+	ranges []geoIPRange
+}
+
+// LoadGeoIPDatabase reads a CSV file of IP ranges, one per line:
+//
+//	start_ip,end_ip,country,region,city,latitude,longitude
+//
+// IPv4 and IPv6 ranges may be mixed. Lines starting with # are ignored, as is
+// a header line whose first field is not an IP address.
+func LoadGeoIPDatabase(path string) (*GeoIPDatabase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	db := &GeoIPDatabase{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, err := netip.ParseAddr(record[0])
+		if err != nil {
+			if line == 1 {
+				continue // Header
+			}
+			return nil, fmt.Errorf("geoip line %d: %w", line, err)
+		}
+		if len(record) < 5 {
+			return nil, fmt.Errorf("geoip line %d: expected at least 5 fields", line)
+		}
+		end, err := netip.ParseAddr(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("geoip line %d: %w", line, err)
+		}
+		if start.Is4() != end.Is4() || end.Less(start) {
+			return nil, fmt.Errorf("geoip line %d: invalid range %s-%s", line, start, end)
+		}
+
+		location := GeoLocation{Country: record[2], Region: record[3], City: record[4]}
+		if len(record) >= 7 {
+			if location.Latitude, err = strconv.ParseFloat(record[5], 64); err != nil {
+				return nil, fmt.Errorf("geoip line %d: %w", line, err)
+			}
+			if location.Longitude, err = strconv.ParseFloat(record[6], 64); err != nil {
+				return nil, fmt.Errorf("geoip line %d: %w", line, err)
+			}
+		}
+
+		db.ranges = append(db.ranges, geoIPRange{start: start.Unmap(), end: end.Unmap(), location: location})
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+
+	return db, nil
+}
+
+// Lookup returns the location of ip, or false when it is not covered by any range
+func (db *GeoIPDatabase) Lookup(ip string) (GeoLocation, bool) {
+	if db == nil {
+		return GeoLocation{}, false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return GeoLocation{}, false
+	}
+	addr = addr.Unmap()
+
+	// Find the last range starting at or before addr
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].start)
+	}) - 1
+	if i < 0 {
+		return GeoLocation{}, false
+	}
+
+	r := db.ranges[i]
+	if r.start.Is4() != addr.Is4() || r.end.Less(addr) {
+		return GeoLocation{}, false
+	}
+	return r.location, true
+}
@@ -0,0 +1,876 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// GormStore keeps the auth records in a database through GORM. Create its
+// tables with InitDB.
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore returns a Store backed by database
+func NewGormStore(database *gorm.DB) *GormStore {
+	return &GormStore{db: database}
+}
+
+func (g *GormStore) Users() UserRepository                       { return gormUsers{g.db} }
+func (g *GormStore) OTPs() OTPRepository                         { return gormOTPs{g.db} }
+func (g *GormStore) EmailChanges() EmailChangeRepository         { return gormEmailChanges{g.db} }
+func (g *GormStore) Sessions() SessionRepository                 { return gormSessions{g.db} }
+func (g *GormStore) AccountDeletions() AccountDeletionRepository { return gormAccountDeletions{g.db} }
+func (g *GormStore) Impersonations() ImpersonationRepository     { return gormImpersonations{g.db} }
+func (g *GormStore) KnownDevices() KnownDeviceRepository         { return gormKnownDevices{g.db} }
+func (g *GormStore) LoginAttempts() LoginAttemptRepository       { return gormLoginAttempts{g.db} }
+func (g *GormStore) LoginChallenges() LoginChallengeRepository   { return gormLoginChallenges{g.db} }
+func (g *GormStore) AuditEvents() AuditRepository                { return gormAuditEvents{g.db} }
+func (g *GormStore) Outbox() OutboxRepository                    { return gormOutbox{g.db} }
+func (g *GormStore) Webhooks() WebhookRepository                 { return gormWebhooks{g.db} }
+func (g *GormStore) RateLimits() RateLimitRepository             { return gormRateLimits{g.db} }
+
+// Transaction runs fn with db.WithTx, so transactions that collide with
+// concurrent ones are retried and nested ones become savepoints
+func (g *GormStore) Transaction(ctx context.Context, fn func(tx Store) error) error {
+	return db.WithTx(ctx, g.db, func(tx *gorm.DB) error {
+		return fn(&GormStore{db: tx})
+	})
+}
+
+// first loads the single record matching query into dest, returning
+// ErrNotFound when there is none
+func first(query *gorm.DB, dest interface{}, conds ...interface{}) error {
+	err := query.First(dest, conds...).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+type gormUsers struct{ db *gorm.DB }
+
+func (r gormUsers) Create(ctx context.Context, model Authenticatable) error {
+	return translateUserWriteError(r.db.WithContext(ctx).Create(model).Error)
+}
+
+func (r gormUsers) Get(ctx context.Context, id uint, dest Authenticatable) error {
+	return first(r.db.WithContext(ctx), dest, id)
+}
+
+func (r gormUsers) GetByLogin(ctx context.Context, login string, dest Authenticatable) error {
+	return first(r.db.WithContext(ctx).Where("username = ? OR email = ?", login, login), dest)
+}
+
+func (r gormUsers) GetActiveByEmail(ctx context.Context, email string, dest Authenticatable) error {
+	return first(r.db.WithContext(ctx).Where("LOWER(email) = ? AND is_active = ?", strings.ToLower(email), true), dest)
+}
+
+func (r gormUsers) Lock(ctx context.Context, id uint) (*User, error) {
+	var user User
+	if err := first(db.ForUpdate(r.db.WithContext(ctx)), &user, id); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r gormUsers) LockUnscoped(ctx context.Context, id uint) (*User, error) {
+	var user User
+	if err := first(db.ForUpdate(r.db.WithContext(ctx).Unscoped()), &user, id); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r gormUsers) List(ctx context.Context, q UserQuery) ([]User, error) {
+	query := r.db.WithContext(ctx).Model(&User{})
+	if q.Deleted {
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+		if q.DeletedBefore != nil {
+			query = query.Where("deleted_at < ?", *q.DeletedBefore)
+		}
+	}
+
+	if q.Search != "" {
+		pattern := "%" + strings.ToLower(q.Search) + "%"
+		query = query.Where("(LOWER(username) LIKE ? OR LOWER(email) LIKE ?)", pattern, pattern)
+	}
+	if q.IsActive != nil {
+		query = query.Where("is_active = ?", *q.IsActive)
+	}
+	if q.IsSuperuser != nil {
+		query = query.Where("is_superuser = ?", *q.IsSuperuser)
+	}
+	if q.DateJoinedFrom != nil {
+		query = query.Where("date_joined >= ?", *q.DateJoinedFrom)
+	}
+	if q.DateJoinedTo != nil {
+		query = query.Where("date_joined <= ?", *q.DateJoinedTo)
+	}
+
+	column := sortableUserColumns[q.SortBy]
+	if q.AfterID > 0 {
+		op := ">"
+		if q.SortDesc {
+			op = "<"
+		}
+		if column == "id" {
+			query = query.Where(fmt.Sprintf("id %s ?", op), q.AfterID)
+		} else {
+			query = query.Where(
+				fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, op, column, op),
+				q.AfterValue, q.AfterValue, q.AfterID,
+			)
+		}
+	}
+
+	direction := "ASC"
+	if q.SortDesc {
+		direction = "DESC"
+	}
+	order := fmt.Sprintf("%s %s", column, direction)
+	if column != "id" {
+		order = fmt.Sprintf("%s, id %s", order, direction)
+	}
+
+	var users []User
+	if err := query.Order(order).Limit(q.Limit).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r gormUsers) Exists(ctx context.Context, id uint, username string) (bool, error) {
+	query := r.db.WithContext(ctx).Model(&User{})
+	if id > 0 {
+		query = query.Where("id = ?", id)
+	}
+	if username != "" {
+		query = query.Where("username = ?", username)
+	}
+	var count int64
+	err := query.Count(&count).Error
+	return count > 0, err
+}
+
+func (r gormUsers) Taken(ctx context.Context, column, value string, exceptID uint) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&User{}).
+		Where(fmt.Sprintf("LOWER(%s) = LOWER(?) AND id <> ?", column), value, exceptID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r gormUsers) Update(ctx context.Context, id uint, fields map[string]interface{}) error {
+	return translateUserWriteError(r.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).Updates(fields).Error)
+}
+
+func (r gormUsers) UpdateUnscoped(ctx context.Context, id uint, fields map[string]interface{}) error {
+	return translateUserWriteError(r.db.WithContext(ctx).Unscoped().Model(&User{}).Where("id = ?", id).Updates(fields).Error)
+}
+
+func (r gormUsers) UpdateExtra(ctx context.Context, model Authenticatable) error {
+	columns, err := extraUserColumns(model, &userSchemas, r.db.NamingStrategy)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	return translateUserWriteError(r.db.WithContext(ctx).Model(model).Select(columns).Updates(model).Error)
+}
+
+func (r gormUsers) ReplacePassword(ctx context.Context, id uint, currentHash, newHash string, changedAt time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND password = ?", id, currentHash).
+		Updates(map[string]interface{}{"password": newHash, "password_changed": changedAt})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r gormUsers) SoftDelete(ctx context.Context, id uint, at time.Time) error {
+	result := r.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).Update("deleted_at", at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r gormUsers) Restore(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Unscoped().Model(&User{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return translateUserWriteError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r gormUsers) Delete(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Unscoped().Delete(&User{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r gormUsers) Purge(ctx context.Context, id uint, deletedBefore time.Time) error {
+	result := r.db.WithContext(ctx).Unscoped().
+		Where("id = ? AND deleted_at IS NOT NULL AND deleted_at < ?", id, deletedBefore).
+		Delete(&User{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// translateUserWriteError maps unique violations on the users table to typed errors
+func translateUserWriteError(err error) error {
+	if err == nil {
+		return nil
+	}
+	err = db.Translate(err)
+
+	var unique *db.ErrUniqueViolation
+	if errors.As(err, &unique) {
+		switch {
+		case unique.Column == "email", unique.Constraint == "idx_users_email":
+			return ErrEmailExists
+		case unique.Column == "username", unique.Constraint == "idx_users_username":
+			return ErrUsernameExists
+		}
+	}
+	return err
+}
+
+// userSchemas caches the user model schemas parsed by gormUsers
+var userSchemas sync.Map
+
+// extraUserColumns returns the columns a user model adds on top of User
+func extraUserColumns(model Authenticatable, cache *sync.Map, naming schema.Namer) ([]string, error) {
+	if _, ok := model.(*User); ok {
+		return nil, nil
+	}
+
+	base, err := schema.Parse(&User{}, cache, naming)
+	if err != nil {
+		return nil, err
+	}
+	full, err := schema.Parse(model, cache, naming)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	for _, field := range full.Fields {
+		if field.DBName == "" || !field.Updatable {
+			continue
+		}
+		if _, ok := base.FieldsByDBName[field.DBName]; ok {
+			continue
+		}
+		columns = append(columns, field.DBName)
+	}
+	return columns, nil
+}
+
+type gormOTPs struct{ db *gorm.DB }
+
+func (r gormOTPs) Create(ctx context.Context, otp *OTP) error {
+	return r.db.WithContext(ctx).Create(otp).Error
+}
+
+func (r gormOTPs) AddAttempt(ctx context.Context, userID uint, purpose string, now time.Time, maxAttempts int) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&OTP{}).
+		Where("user_id = ? AND purpose = ? AND expires_at > ? AND verified = ? AND attempts < ?", userID, purpose, now, false, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r gormOTPs) Consume(ctx context.Context, userID uint, purpose, value string, now time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&OTP{}).
+		Where("user_id = ? AND purpose = ? AND otp_value = ? AND expires_at > ? AND verified = ?", userID, purpose, value, now, false).
+		Update("verified", true)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r gormOTPs) ListForUser(ctx context.Context, userID uint) ([]OTP, error) {
+	var otps []OTP
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&otps).Error
+	return otps, err
+}
+
+func (r gormOTPs) DeleteForUser(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&OTP{}).Error
+}
+
+func (r gormOTPs) DeleteForPurpose(ctx context.Context, userID uint, purpose string) error {
+	return r.db.WithContext(ctx).Where("user_id = ? AND purpose = ?", userID, purpose).Delete(&OTP{}).Error
+}
+
+type gormEmailChanges struct{ db *gorm.DB }
+
+func (r gormEmailChanges) Create(ctx context.Context, change *EmailChange) error {
+	return r.db.WithContext(ctx).Create(change).Error
+}
+
+func (r gormEmailChanges) GetPending(ctx context.Context, userID uint, tokenHash string, now time.Time) (*EmailChange, error) {
+	var change EmailChange
+	err := first(r.db.WithContext(ctx).Where(
+		"user_id = ? AND token_hash = ? AND expires_at > ? AND confirmed_at IS NULL", userID, tokenHash, now,
+	), &change)
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+func (r gormEmailChanges) Confirm(ctx context.Context, id uint, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&EmailChange{}).
+		Where("id = ? AND confirmed_at IS NULL", id).
+		Update("confirmed_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r gormEmailChanges) ListForUser(ctx context.Context, userID uint) ([]EmailChange, error) {
+	var changes []EmailChange
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&changes).Error
+	return changes, err
+}
+
+func (r gormEmailChanges) DeletePending(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Where("user_id = ? AND confirmed_at IS NULL", userID).Delete(&EmailChange{}).Error
+}
+
+func (r gormEmailChanges) DeleteForUser(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&EmailChange{}).Error
+}
+
+type gormSessions struct{ db *gorm.DB }
+
+func (r gormSessions) Create(ctx context.Context, session *Session) error {
+	return r.db.WithContext(ctx).Create(session).Error
+}
+
+func (r gormSessions) GetActive(ctx context.Context, id, userID uint, now time.Time) (*Session, error) {
+	var session Session
+	err := first(r.db.WithContext(ctx).Where(
+		"id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", id, userID, now,
+	), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r gormSessions) GetByRefreshHash(ctx context.Context, refreshHash string, now time.Time) (*Session, error) {
+	var session Session
+	err := first(r.db.WithContext(ctx).Where(
+		"refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?", refreshHash, now,
+	), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r gormSessions) ListActive(ctx context.Context, userID uint, now time.Time) ([]Session, error) {
+	sessions := []Session{}
+	err := r.db.WithContext(ctx).Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (r gormSessions) ListForUser(ctx context.Context, userID uint) ([]Session, error) {
+	sessions := []Session{}
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&sessions).Error
+	return sessions, err
+}
+
+func (r gormSessions) Touch(ctx context.Context, id uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&Session{}).Where("id = ?", id).Update("last_seen_at", at).Error
+}
+
+func (r gormSessions) Extend(ctx context.Context, id uint, expiresAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&Session{}).Where("id = ?", id).Update("expires_at", expiresAt).Error
+}
+
+func (r gormSessions) RotateRefresh(ctx context.Context, id uint, currentHash, newHash string, expiresAt time.Time) (bool, error) {
+	query := r.db.WithContext(ctx).Model(&Session{}).Where("id = ? AND revoked_at IS NULL", id)
+	if currentHash != "" {
+		query = query.Where("refresh_token_hash = ?", currentHash)
+	}
+	result := query.Updates(map[string]interface{}{"refresh_token_hash": newHash, "expires_at": expiresAt})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r gormSessions) Revoke(ctx context.Context, userID, id uint, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r gormSessions) RevokeOthers(ctx context.Context, userID, exceptID uint, now time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Model(&Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL AND expires_at > ?", userID, exceptID, now).
+		Update("revoked_at", now)
+	return result.RowsAffected, result.Error
+}
+
+func (r gormSessions) End(ctx context.Context, userID, id uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Updates(map[string]interface{}{"revoked_at": at, "refresh_token_hash": ""}).Error
+}
+
+func (r gormSessions) DeleteForUser(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&Session{}).Error
+}
+
+type gormAccountDeletions struct{ db *gorm.DB }
+
+func (r gormAccountDeletions) Create(ctx context.Context, deletion *AccountDeletion) error {
+	return r.db.WithContext(ctx).Create(deletion).Error
+}
+
+func (r gormAccountDeletions) GetPending(ctx context.Context, userID uint) (*AccountDeletion, error) {
+	var deletion AccountDeletion
+	err := first(r.db.WithContext(ctx).Where(
+		"user_id = ? AND cancelled_at IS NULL AND completed_at IS NULL", userID,
+	), &deletion)
+	if err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+func (r gormAccountDeletions) ListDue(ctx context.Context, now time.Time) ([]AccountDeletion, error) {
+	var due []AccountDeletion
+	err := r.db.WithContext(ctx).Where(
+		"scheduled_for <= ? AND cancelled_at IS NULL AND completed_at IS NULL", now,
+	).Find(&due).Error
+	return due, err
+}
+
+func (r gormAccountDeletions) ListForUser(ctx context.Context, userID uint) ([]AccountDeletion, error) {
+	deletions := []AccountDeletion{}
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&deletions).Error
+	return deletions, err
+}
+
+func (r gormAccountDeletions) Cancel(ctx context.Context, userID uint, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&AccountDeletion{}).
+		Where("user_id = ? AND cancelled_at IS NULL AND completed_at IS NULL", userID).
+		Update("cancelled_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r gormAccountDeletions) Complete(ctx context.Context, id uint, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&AccountDeletion{}).
+		Where("id = ? AND cancelled_at IS NULL AND completed_at IS NULL", id).
+		Update("completed_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+type gormImpersonations struct{ db *gorm.DB }
+
+func (r gormImpersonations) Create(ctx context.Context, session *ImpersonationSession) error {
+	return r.db.WithContext(ctx).Create(session).Error
+}
+
+func (r gormImpersonations) GetActive(ctx context.Context, id uint, now time.Time) (*ImpersonationSession, error) {
+	var session ImpersonationSession
+	err := first(r.db.WithContext(ctx).Where("id = ? AND ended_at IS NULL AND expires_at > ?", id, now), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r gormImpersonations) List(ctx context.Context, impersonatorID, targetID uint, limit int) ([]ImpersonationSession, error) {
+	query := r.db.WithContext(ctx).Model(&ImpersonationSession{})
+	if impersonatorID > 0 {
+		query = query.Where("impersonator_id = ?", impersonatorID)
+	}
+	if targetID > 0 {
+		query = query.Where("target_id = ?", targetID)
+	}
+
+	var sessions []ImpersonationSession
+	err := query.Order("id DESC").Limit(limit).Find(&sessions).Error
+	return sessions, err
+}
+
+func (r gormImpersonations) End(ctx context.Context, id uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&ImpersonationSession{}).
+		Where("id = ? AND ended_at IS NULL", id).
+		Update("ended_at", at).Error
+}
+
+type gormKnownDevices struct{ db *gorm.DB }
+
+func (r gormKnownDevices) Known(ctx context.Context, userID uint, fingerprint string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&KnownDevice{}).
+		Where("user_id = ? AND fingerprint = ?", userID, fingerprint).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r gormKnownDevices) Remember(ctx context.Context, device *KnownDevice) error {
+	result := r.db.WithContext(ctx).Model(&KnownDevice{}).
+		Where("user_id = ? AND fingerprint = ?", device.UserID, device.Fingerprint).
+		Updates(map[string]interface{}{"last_ip": device.LastIP, "last_location": device.LastLocation, "last_seen_at": device.LastSeenAt})
+	if result.Error == nil && result.RowsAffected > 0 {
+		return nil
+	}
+
+	// A concurrent login from the same device may have inserted it first
+	if err := r.db.WithContext(ctx).Create(device).Error; err != nil && !db.IsUniqueViolation(err) {
+		return err
+	}
+	return nil
+}
+
+func (r gormKnownDevices) ListForUser(ctx context.Context, userID uint) ([]KnownDevice, error) {
+	devices := []KnownDevice{}
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&devices).Error
+	return devices, err
+}
+
+func (r gormKnownDevices) DeleteForUser(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&KnownDevice{}).Error
+}
+
+type gormLoginAttempts struct{ db *gorm.DB }
+
+func (r gormLoginAttempts) Create(ctx context.Context, attempt *LoginAttempt) error {
+	return r.db.WithContext(ctx).Create(attempt).Error
+}
+
+func (r gormLoginAttempts) LastLocatedSuccess(ctx context.Context, userID uint) (*LoginAttempt, error) {
+	var attempt LoginAttempt
+	err := first(r.db.WithContext(ctx).
+		Where("user_id = ? AND succeeded = ? AND latitude IS NOT NULL", userID, true).
+		Order("created_at DESC"), &attempt)
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (r gormLoginAttempts) CountUserFailures(ctx context.Context, userID uint, reasons []string, since time.Time) (int64, error) {
+	return r.countFailures(ctx, reasons, since, "user_id = ?", userID)
+}
+
+func (r gormLoginAttempts) CountIPFailures(ctx context.Context, ip string, reasons []string, since time.Time) (int64, error) {
+	return r.countFailures(ctx, reasons, since, "ip = ?", ip)
+}
+
+func (r gormLoginAttempts) countFailures(ctx context.Context, reasons []string, since time.Time, query string, args ...interface{}) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&LoginAttempt{}).
+		Where("succeeded = ? AND reason IN ? AND created_at > ?", false, reasons, since).
+		Where(query, args...).Count(&count).Error
+	return count, err
+}
+
+func (r gormLoginAttempts) ListForUser(ctx context.Context, userID uint) ([]LoginAttempt, error) {
+	attempts := []LoginAttempt{}
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&attempts).Error
+	return attempts, err
+}
+
+func (r gormLoginAttempts) DeleteForUser(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&LoginAttempt{}).Error
+}
+
+type gormLoginChallenges struct{ db *gorm.DB }
+
+func (r gormLoginChallenges) Create(ctx context.Context, challenge *LoginChallenge) error {
+	return r.db.WithContext(ctx).Create(challenge).Error
+}
+
+func (r gormLoginChallenges) GetOpen(ctx context.Context, tokenHash string, now time.Time, maxAttempts int) (*LoginChallenge, error) {
+	var challenge LoginChallenge
+	err := first(r.db.WithContext(ctx).Where(
+		"token_hash = ? AND completed_at IS NULL AND expires_at > ? AND attempts < ?", tokenHash, now, maxAttempts,
+	), &challenge)
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+func (r gormLoginChallenges) AddAttempt(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Model(&LoginChallenge{}).Where("id = ?", id).
+		Update("attempts", gorm.Expr("attempts + 1")).Error
+}
+
+func (r gormLoginChallenges) Complete(ctx context.Context, id uint, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&LoginChallenge{}).
+		Where("id = ? AND completed_at IS NULL", id).
+		Update("completed_at", at)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r gormLoginChallenges) DeleteForUser(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&LoginChallenge{}).Error
+}
+
+type gormAuditEvents struct{ db *gorm.DB }
+
+func (r gormAuditEvents) Create(ctx context.Context, event *AuditEvent) error {
+	return r.db.WithContext(ctx).Create(event).Error
+}
+
+func (r gormAuditEvents) LockChainHead(ctx context.Context) (string, error) {
+	// The row is created by the audit_chain_head migration
+	var head AuditChainHead
+	if err := first(db.ForUpdate(r.db.WithContext(ctx)), &head, auditChainHeadID); err != nil {
+		return "", err
+	}
+	return head.Hash, nil
+}
+
+func (r gormAuditEvents) ChainHead(ctx context.Context) (string, error) {
+	var head AuditChainHead
+	if err := first(r.db.WithContext(ctx), &head, auditChainHeadID); err != nil {
+		return "", err
+	}
+	return head.Hash, nil
+}
+
+func (r gormAuditEvents) SetChainHead(ctx context.Context, hash string) error {
+	return r.db.WithContext(ctx).Model(&AuditChainHead{}).Where("id = ?", auditChainHeadID).Update("hash", hash).Error
+}
+
+func (r gormAuditEvents) List(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	query := r.db.WithContext(ctx).Model(&AuditEvent{})
+	if filter.ActorID > 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.TargetID > 0 {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at <= ?", *filter.To)
+	}
+	if filter.BeforeID > 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+
+	var events []AuditEvent
+	err := query.Order("id DESC").Limit(filter.Limit).Find(&events).Error
+	return events, err
+}
+
+func (r gormAuditEvents) ListForUser(ctx context.Context, userID uint) ([]AuditEvent, error) {
+	events := []AuditEvent{}
+	err := r.db.WithContext(ctx).Where("actor_id = ? OR target_id = ?", userID, userID).Order("id").Find(&events).Error
+	return events, err
+}
+
+func (r gormAuditEvents) ListFailedLogins(ctx context.Context, login string) ([]AuditEvent, error) {
+	// A prefilter; LIKE wildcards in login can match more, so check the metadata
+	quoted, err := json.Marshal(login)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []AuditEvent
+	err = r.db.WithContext(ctx).Where("action = ? AND metadata LIKE ?", AuditLoginFailed, `%"username":`+string(quoted)+"%").
+		Order("id").Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	events := []AuditEvent{}
+	for _, event := range candidates {
+		if event.Metadata["username"] == login {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (r gormAuditEvents) Scrub(ctx context.Context, id uint, ip, userAgent string, metadata AuditMetadata) error {
+	return r.db.WithContext(ctx).Model(&AuditEvent{}).Where("id = ? AND hash = ''", id).
+		Updates(map[string]interface{}{"ip": ip, "user_agent": userAgent, "metadata": metadata}).Error
+}
+
+func (r gormAuditEvents) EachHashed(ctx context.Context, batchSize int, fn func(events []AuditEvent) error) error {
+	var batch []AuditEvent
+	return r.db.WithContext(ctx).Where("hash <> ''").Order("id").FindInBatches(&batch, batchSize, func(*gorm.DB, int) error {
+		return fn(batch)
+	}).Error
+}
+
+type gormOutbox struct{ db *gorm.DB }
+
+func (r gormOutbox) Create(ctx context.Context, message *OutboxMessage) error {
+	return r.db.WithContext(ctx).Create(message).Error
+}
+
+func (r gormOutbox) Get(ctx context.Context, id uint) (*OutboxMessage, error) {
+	var message OutboxMessage
+	if err := first(r.db.WithContext(ctx), &message, id); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (r gormOutbox) ClaimUnprocessed(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	var messages []OutboxMessage
+	err := r.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("processed_at IS NULL").Order("id").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+func (r gormOutbox) MarkProcessed(ctx context.Context, id uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&OutboxMessage{}).Where("id = ?", id).Update("processed_at", at).Error
+}
+
+func (r gormOutbox) ListForUser(ctx context.Context, userID uint) ([]OutboxMessage, error) {
+	messages := []OutboxMessage{}
+	err := r.db.WithContext(ctx).Where("payload LIKE ?", outboxUserPrefix(userID)+"%").Order("id").Find(&messages).Error
+	return messages, err
+}
+
+func (r gormOutbox) SetPayload(ctx context.Context, id uint, payload string) error {
+	return r.db.WithContext(ctx).Model(&OutboxMessage{}).Where("id = ?", id).Update("payload", payload).Error
+}
+
+type gormWebhooks struct{ db *gorm.DB }
+
+func (r gormWebhooks) CreateSubscription(ctx context.Context, subscription *WebhookSubscription) error {
+	return r.db.WithContext(ctx).Create(subscription).Error
+}
+
+func (r gormWebhooks) GetSubscription(ctx context.Context, id uint) (*WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	if err := first(r.db.WithContext(ctx), &subscription, id); err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func (r gormWebhooks) ListSubscriptions(ctx context.Context, activeOnly bool) ([]WebhookSubscription, error) {
+	query := r.db.WithContext(ctx)
+	if activeOnly {
+		query = query.Where("active = ?", true)
+	}
+	var subscriptions []WebhookSubscription
+	err := query.Order("id").Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+func (r gormWebhooks) DeleteSubscription(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Delete(&WebhookSubscription{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r gormWebhooks) CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	return r.db.WithContext(ctx).Create(delivery).Error
+}
+
+func (r gormWebhooks) ListDeliveries(ctx context.Context, subscriptionID uint, status string, limit int) ([]WebhookDelivery, error) {
+	query := r.db.WithContext(ctx).Model(&WebhookDelivery{})
+	if subscriptionID > 0 {
+		query = query.Where("subscription_id = ?", subscriptionID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var deliveries []WebhookDelivery
+	err := query.Order("id DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r gormWebhooks) LeaseDue(ctx context.Context, now time.Time, limit int, until time.Time) ([]WebhookDelivery, error) {
+	var due []WebhookDelivery
+	err := db.WithTx(ctx, r.db, func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", DeliveryPending, now).
+			Order("next_attempt_at").Limit(limit).Find(&due).Error
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(due))
+		for i := range due {
+			ids[i] = due[i].ID
+			due[i].NextAttemptAt = until
+		}
+		return tx.Model(&WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", until).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+func (r gormWebhooks) UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	return r.db.WithContext(ctx).Model(delivery).
+		Select("status", "attempts", "next_attempt_at", "delivered_at").
+		Updates(delivery).Error
+}
+
+func (r gormWebhooks) CreateAttempt(ctx context.Context, attempt *WebhookAttempt) error {
+	return r.db.WithContext(ctx).Create(attempt).Error
+}
+
+func (r gormWebhooks) ListAttempts(ctx context.Context, deliveryID uint) ([]WebhookAttempt, error) {
+	var attempts []WebhookAttempt
+	err := r.db.WithContext(ctx).Where("delivery_id = ?", deliveryID).Order("id").Find(&attempts).Error
+	return attempts, err
+}
+
+type gormRateLimits struct{ db *gorm.DB }
+
+func (r gormRateLimits) Create(ctx context.Context, hit *RateLimitHit) error {
+	return r.db.WithContext(ctx).Create(hit).Error
+}
+
+func (r gormRateLimits) Count(ctx context.Context, bucket string, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&RateLimitHit{}).
+		Where("bucket = ? AND created_at > ?", bucket, since).Count(&count).Error
+	return count, err
+}
+
+func (r gormRateLimits) DeleteBefore(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).Where("created_at < ?", before).Delete(&RateLimitHit{}).Error
+}
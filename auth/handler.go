@@ -1,21 +1,51 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"time"
-	"strings"
-	"gorm.io/gorm"
 	"log"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/db"
 )
 
+// Register calls RegisterContext with the service's context
 func (s *Service) Register(user User, password string) (uint, error) {
+	return s.RegisterContext(s.context(), user, password)
+}
+
+// RegisterContext creates a user with the base User model
+func (s *Service) RegisterContext(ctx context.Context, user User, password string) (uint, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	return s.RegisterModel(&user, password)
+}
+
+// RegisterModel calls RegisterModelContext with the service's context
+func (s *Service) RegisterModel(model Authenticatable, password string) (uint, error) {
+	return s.RegisterModelContext(s.context(), model, password)
+}
+
+// RegisterModelContext creates a user from an application-defined model embedding User,
+// persisting its extra columns alongside the base fields
+func (s *Service) RegisterModelContext(ctx context.Context, model Authenticatable, password string) (uint, error) {
+    s, cancel := s.scope(ctx)
+    defer cancel()
+
+    user := model.AuthUser()
+
     // Use email as username if needed
     if user.Username == "" {
         user.Username = user.Email
     }
 
+    if err := s.config.Hooks.runBeforeRegister(model); err != nil {
+        return 0, err
+    }
+
     // Validate user input
-    if err := s.validateData(user); err != nil {
+    if err := s.validateData(model); err != nil {
         return 0, err
     }
 
@@ -30,74 +60,212 @@ func (s *Service) Register(user User, password string) (uint, error) {
     now := time.Now()
     user.PasswordChanged = &now
 
-    // Create the user
-    result := s.config.DB.Create(&user)
-    if result.Error != nil {
-        // Check for unique constraint violations
-        if strings.Contains(result.Error.Error(), "duplicate key value violates unique constraint") {
-            if strings.Contains(result.Error.Error(), "idx_users_email") {
-                return 0, ErrEmailExists
-            }
-            if strings.Contains(result.Error.Error(), "idx_users_username") {
-                return 0, ErrUsernameExists
-            }
+    // Create the user and its registration event atomically
+    var event UserRegistered
+    err = s.transaction(func(tx Store) error {
+        if err := tx.Users().Create(s.context(), model); err != nil {
+            return err
         }
-        return 0, result.Error
+        event = UserRegistered{UserID: user.ID, Username: user.Username, Email: user.Email, OccurredAt: now}
+        return s.enqueueEvent(tx, event)
+    })
+    if err != nil {
+        return 0, err
     }
 
+    s.publish(event)
+    s.audit(AuditUserRegistered, user.ID, user.ID, nil)
+    s.afterCommit(func() { s.config.Hooks.runAfterRegister(model) })
+
     return user.ID, nil
 }
-// Authenticate verifies a user's credentials
+
+// Authenticate calls AuthenticateContext with the service's context
 func (s *Service) Authenticate(username, password string) (*User, error) {
-    var user User
+	return s.AuthenticateContext(s.context(), username, password)
+}
+
+// AuthenticateContext verifies a user's credentials
+func (s *Service) AuthenticateContext(ctx context.Context, username, password string) (*User, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	model, err := s.authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+	return model.AuthUser(), nil
+}
+
+// authenticate verifies credentials and returns the configured user model
+func (s *Service) authenticate(username, password string) (Authenticatable, error) {
+    model := s.newUser()
     
-    // First check if any user exists with this username/email (active or inactive)
-    result := s.config.DB.Where("username = ? OR email = ?", username, username).First(&user)
-    if result.Error != nil {
-        if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+    // First check if any user exists with this username/email (active or inactive).
+    // Credentials are read from the primary, so a changed password or a
+    // deactivation takes effect at once rather than after replication lag
+    if err := s.store().Users().GetByLogin(db.Primary(s.context()), username, model); err != nil {
+        if errors.Is(err, ErrNotFound) {
+            s.audit(AuditLoginFailed, 0, 0, AuditMetadata{"username": username, "reason": "user_not_found"})
+            s.recordLoginAttempt(0, false, "user_not_found", nil)
             return nil, ErrUserNotFound // New error type for this case
         }
-        return nil, result.Error
+        return nil, err
     }
+    user := model.AuthUser()
 
-    // // Then check if the user is active
-    // if !user.IsActive {
-    //     return nil, ErrUserInactive // New error type for inactive users
-    // }
+    // Then check if the user is active
+    if !user.IsActive {
+        s.audit(AuditLoginFailed, user.ID, user.ID, AuditMetadata{"reason": "inactive"})
+        return nil, ErrUserInactive
+    }
 
     // Finally verify the password
     if !s.VerifyPassword(user.Password, password) {
+        s.audit(AuditLoginFailed, user.ID, user.ID, AuditMetadata{"reason": "invalid_password"})
+        s.recordLoginAttempt(user.ID, false, "invalid_password", nil)
         return nil, ErrInvalidPassword // More specific than ErrInvalidCredentials
     }
 
+    // Assess the login for signs of account takeover
+    assessment, err := s.assessLogin(user)
+    if err != nil {
+        return nil, err
+    }
+    switch assessment.Action {
+    case RiskBlock:
+        s.audit(AuditLoginFailed, user.ID, user.ID, AuditMetadata{"reason": "risk_blocked", "signals": joinSignals(assessment.Signals)})
+        s.recordLoginAttempt(user.ID, false, "risk_blocked", assessment.Location)
+        s.notifyRisk(user, assessment, true)
+        return nil, ErrLoginBlocked
+    case RiskStepUp:
+        return nil, s.startStepUp(user, assessment)
+    }
+
+    if err := s.completeAuthentication(model, username, assessment); err != nil {
+        return nil, err
+    }
+    return model, nil
+}
+
+// completeAuthentication finishes a login whose credentials and risk checks have passed
+func (s *Service) completeAuthentication(model Authenticatable, username string, assessment *RiskAssessment) error {
+    user := model.AuthUser()
+
+    // Give application hooks the chance to deny an otherwise valid login
+    if err := s.config.Hooks.runBeforeLogin(model, s.loginInfo(username)); err != nil {
+        s.audit(AuditLoginFailed, user.ID, user.ID, AuditMetadata{"reason": "vetoed"})
+        return err
+    }
+
+    if s.config.LoginRisk.Enabled {
+        s.recordLoginAttempt(user.ID, true, "", assessment.Location)
+        s.rememberDevice(user.ID, assessment)
+        if assessment.Action == RiskNotify {
+            s.notifyRisk(user, assessment, false)
+        }
+    }
+
     // Update last login time
     now := time.Now()
     user.LastLogin = &now
-    if err := s.config.DB.Model(&user).Update("last_login", now).Error; err != nil {
+    if err := s.store().Users().Update(s.context(), user.ID, map[string]interface{}{"last_login": now}); err != nil {
         // Log this error but don't fail authentication because of it
         log.Printf("Failed to update last login time: %v", err)
     }
 
-    return &user, nil
+    var metadata AuditMetadata
+    if len(assessment.Signals) > 0 {
+        metadata = AuditMetadata{"signals": joinSignals(assessment.Signals)}
+    }
+    s.audit(AuditLoginSucceeded, user.ID, user.ID, metadata)
+
+    return nil
 }
 
-// Login combines authentication and JWT generation
+// Login calls LoginContext with the service's context
 func (s *Service) Login(username, password string) (*User, string, error) {
-	user, err := s.Authenticate(username, password)
+	return s.LoginContext(s.context(), username, password)
+}
+
+// LoginContext combines authentication and JWT generation. When the login looks risky
+// it may fail with ErrLoginBlocked or a *StepUpRequiredError.
+func (s *Service) LoginContext(ctx context.Context, username, password string) (*User, string, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	model, err := s.authenticate(username, password)
 	if err != nil {
 		return nil, "", err
 	}
+	return s.issueLogin(model, username, []string{AMRPassword})
+}
+
+// issueLogin starts a session for an authenticated user and issues its token.
+// amr lists the authentication methods the user just completed.
+func (s *Service) issueLogin(model Authenticatable, username string, amr []string) (*User, string, error) {
+	user := model.AuthUser()
+
+	event := UserLoggedIn{
+		UserID:     user.ID,
+		IP:         s.request.IP,
+		UserAgent:  s.request.UserAgent,
+		OccurredAt: time.Now(),
+	}
 
-	token, err := s.GenerateJWT(user)
+	// The session and its login event are stored together
+	var session *Session
+	err := s.atomically(func(s *Service) error {
+		var err error
+		if session, err = s.createSession(user.ID, amr); err != nil {
+			return err
+		}
+		return s.enqueueEvent(s.store(), event)
+	})
+	if err != nil {
+		return user, "", err
+	}
+
+	token, _, err := s.generateToken(model, tokenOptions{sessionID: session.ID, authTime: time.Now(), amr: amr})
 	if err != nil {
 		return user, "", err
 	}
 
+	s.publish(event)
+	s.config.Hooks.runAfterLogin(model, s.loginInfo(username))
+
 	return user, token, nil
 }
 
-// GenerateOTP creates a one-time password for a user
+// OTP purposes. A code only completes the flow it was sent for.
+const (
+	OTPPurposeGeneral          = "general"
+	OTPPurposePasswordReset    = "password_reset"
+	OTPPurposeLoginStepUp      = "login_step_up"
+	OTPPurposeReauthentication = "reauthentication"
+)
+
+// maxOTPAttempts is how many checks a one-time password allows
+const maxOTPAttempts = 5
+
+// GenerateOTP calls GenerateOTPContext with the service's context
 func (s *Service) GenerateOTP(userID uint, length int, validityMinutes int) (string, error) {
+	return s.GenerateOTPContext(s.context(), userID, length, validityMinutes)
+}
+
+// GenerateOTPContext creates a one-time password for a user. The code is
+// for OTPPurposeGeneral: codes sent by the password reset, step-up and
+// reauthentication flows cannot be checked with VerifyOTP, nor these with them.
+func (s *Service) GenerateOTPContext(ctx context.Context, userID uint, length int, validityMinutes int) (string, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	return s.generateOTP(userID, OTPPurposeGeneral, length, validityMinutes)
+}
+
+// generateOTP creates a one-time password for one purpose, replacing the
+// user's earlier code for it
+func (s *Service) generateOTP(userID uint, purpose string, length int, validityMinutes int) (string, error) {
 	if length <= 0 {
 		length = 6 // Default OTP length
 	}
@@ -105,107 +273,175 @@ func (s *Service) GenerateOTP(userID uint, length int, validityMinutes int) (str
 		validityMinutes = 15 // Default validity: 15 minutes
 	}
 
-	// Check if user exists
-	var user User
-	result := s.config.DB.First(&user, userID)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return "", ErrUserNotFound
-		}
-		return "", result.Error
-	}
-
 	// Generate random OTP
 	otpValue, err := s.generateRandomOTP(length)
 	if err != nil {
 		return "", err
 	}
 
-	// Delete any existing OTPs for this user
-	s.config.DB.Where("user_id = ?", userID).Delete(&OTP{})
-
-	// Create new OTP
 	otp := OTP{
 		UserID:    userID,
 		OTPValue:  otpValue,
+		Purpose:   purpose,
 		ExpiresAt: time.Now().Add(time.Duration(validityMinutes) * time.Minute),
 		Verified:  false,
 	}
 	
-	result = s.config.DB.Create(&otp)
-	if result.Error != nil {
-		return "", result.Error
+	// Replace the user's existing code for this purpose; locking the user row
+	// makes concurrent requests take turns, so exactly one code stays valid
+	err = s.transaction(func(tx Store) error {
+		if _, err := s.lockUser(tx, userID); err != nil {
+			return err
+		}
+		if err := tx.OTPs().DeleteForPurpose(s.context(), userID, purpose); err != nil {
+			return err
+		}
+		return tx.OTPs().Create(s.context(), &otp)
+	})
+	if err != nil {
+		return "", err
 	}
 
+	s.audit(AuditOTPGenerated, 0, userID, AuditMetadata{"purpose": purpose, "expires_at": otp.ExpiresAt})
+
 	return otpValue, nil
 }
 
-// VerifyOTP checks if an OTP is valid for a user
+// VerifyOTP calls VerifyOTPContext with the service's context
 func (s *Service) VerifyOTP(userID uint, otpValue string) (bool, error) {
-	var otp OTP
-	
-	result := s.config.DB.Where(
-		"user_id = ? AND otp_value = ? AND expires_at > ? AND verified = ?", 
-		userID, otpValue, time.Now(), false,
-	).First(&otp)
+	return s.VerifyOTPContext(s.context(), userID, otpValue)
+}
 	
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return false, nil
-		}
-		return false, result.Error
+// VerifyOTPContext checks a code from GenerateOTP. Each check counts
+// against the code, which stops working after maxOTPAttempts.
+func (s *Service) VerifyOTPContext(ctx context.Context, userID uint, otpValue string) (bool, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	return s.verifyOTP(userID, OTPPurposeGeneral, otpValue)
+}
+
+// verifyOTP checks and uses up a code for purpose
+func (s *Service) verifyOTP(userID uint, purpose, otpValue string) (bool, error) {
+	counted, err := s.countOTPAttempt(userID, purpose)
+	if err != nil || !counted {
+		return false, err
 	}
+	return s.consumeOTP(userID, purpose, otpValue)
+}
 
-	// Mark OTP as verified
-	otp.Verified = true
-	s.config.DB.Save(&otp)
+// countOTPAttempt counts a check against the user's code for purpose. It
+// reports false, and the check must fail, when there is no live code or it
+// has been checked maxOTPAttempts times, so codes cannot be guessed at length.
+func (s *Service) countOTPAttempt(userID uint, purpose string) (bool, error) {
+	counted, err := s.store().OTPs().AddAttempt(s.context(), userID, purpose, time.Now(), maxOTPAttempts)
+	if err != nil {
+		return false, err
+	}
+	if !counted {
+		s.audit(AuditOTPFailed, 0, userID, AuditMetadata{"purpose": purpose})
+	}
+	return counted, nil
+}
+
+// consumeOTP uses up the user's code for purpose if it has the given value
+func (s *Service) consumeOTP(userID uint, purpose, otpValue string) (bool, error) {
+	// Claim the OTP in one conditional update so a code can only be used once,
+	// however many requests present it at the same time
+	consumed, err := s.store().OTPs().Consume(s.context(), userID, purpose, otpValue, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if !consumed {
+		s.audit(AuditOTPFailed, 0, userID, AuditMetadata{"purpose": purpose})
+		return false, nil
+	}
+
+	s.audit(AuditOTPVerified, 0, userID, AuditMetadata{"purpose": purpose})
 
 	return true, nil
 }
 
-// ChangePassword updates a user's password
+// ChangePassword calls ChangePasswordContext with the service's context
 func (s *Service) ChangePassword(userID uint, currentPassword, newPassword string) error {
+	return s.ChangePasswordContext(s.context(), userID, currentPassword, newPassword)
+}
+
+// ChangePasswordContext updates a user's password
+func (s *Service) ChangePasswordContext(ctx context.Context, userID uint, currentPassword, newPassword string) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
 	// Get current user details
-	var user User
-	result := s.config.DB.First(&user, userID)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return ErrUserNotFound
-		}
-		return result.Error
+	model := s.newUser()
+	if err := s.LoadUser(userID, model); err != nil {
+		return err
 	}
+	user := model.AuthUser()
 
 	// Verify current password
 	if !s.VerifyPassword(user.Password, currentPassword) {
+		s.audit(AuditPasswordChangeFailed, userID, userID, AuditMetadata{"reason": "invalid_password"})
 		return ErrInvalidPassword
 	}
 
+	if err := s.config.Hooks.runBeforePasswordChange(model, newPassword); err != nil {
+		s.audit(AuditPasswordChangeFailed, userID, userID, AuditMetadata{"reason": "vetoed"})
+		return err
+	}
+
 	// Hash new password
 	hashedPassword, err := s.HashPassword(newPassword)
 	if err != nil {
 		return err
 	}
 
-	// Update password
+	// Update password, replacing only the hash that was verified, so of two
+	// concurrent changes presenting the same current password only the first succeeds
 	now := time.Now()
-	updates := map[string]interface{}{
-		"password":         hashedPassword,
-		"password_changed": now,
+	event := PasswordChanged{UserID: userID, OccurredAt: now}
+	err = s.transaction(func(tx Store) error {
+		replaced, err := tx.Users().ReplacePassword(s.context(), userID, user.Password, hashedPassword, now)
+		if err != nil {
+			return err
+		}
+		if !replaced {
+			return ErrInvalidPassword
+		}
+		return s.enqueueEvent(tx, event)
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidPassword) {
+			s.audit(AuditPasswordChangeFailed, userID, userID, AuditMetadata{"reason": "concurrent_change"})
+		}
+		return err
 	}
-	
-	return s.config.DB.Model(&user).Updates(updates).Error
+
+	s.publish(event)
+	s.audit(AuditPasswordChanged, userID, userID, nil)
+	s.afterCommit(func() { s.config.Hooks.runAfterPasswordChange(model) })
+	return nil
 }
 
-// ResetPassword resets a user's password (admin function or after verification)
+// ResetPassword calls ResetPasswordContext with the service's context
 func (s *Service) ResetPassword(userID uint, newPassword string) error {
+	return s.ResetPasswordContext(s.context(), userID, newPassword)
+}
+
+// ResetPasswordContext resets a user's password (admin function or after verification)
+func (s *Service) ResetPasswordContext(ctx context.Context, userID uint, newPassword string) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
 	// Check if user exists
-	var user User
-	result := s.config.DB.First(&user, userID)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return ErrUserNotFound
-		}
-		return result.Error
+	model := s.newUser()
+	if err := s.LoadUser(userID, model); err != nil {
+		return err
+	}
+	user := model.AuthUser()
+
+	if err := s.config.Hooks.runBeforePasswordChange(model, newPassword); err != nil {
+		return err
 	}
 
 	// Hash new password
@@ -221,22 +457,36 @@ func (s *Service) ResetPassword(userID uint, newPassword string) error {
 		"password_changed": now,
 	}
 	
-	return s.config.DB.Model(&user).Updates(updates).Error
-}
+	event := PasswordReset{UserID: userID, OccurredAt: now}
+	err = s.transaction(func(tx Store) error {
+		if err := tx.Users().Update(s.context(), user.ID, updates); err != nil {
+			return err
+		}
+		return s.enqueueEvent(tx, event)
+	})
+	if err != nil {
+		return err
+	}
 
-// UserExists checks if a user exists by ID and/or username
+	s.publish(event)
+	s.audit(AuditPasswordReset, 0, userID, nil)
+	s.afterCommit(func() { s.config.Hooks.runAfterPasswordChange(model) })
+	return nil
+}
+	
+// UserExists calls UserExistsContext with the service's context
 func (s *Service) UserExists(userID uint, username string) (bool, error) {
-	var count int64
+	return s.UserExistsContext(s.context(), userID, username)
+}
+
+// UserExistsContext checks if a user exists by ID and/or username
+func (s *Service) UserExistsContext(ctx context.Context, userID uint, username string) (bool, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
 	
-	if userID > 0 && username != "" {
-		s.config.DB.Model(&User{}).Where("id = ? AND username = ?", userID, username).Count(&count)
-	} else if userID > 0 {
-		s.config.DB.Model(&User{}).Where("id = ?", userID).Count(&count)
-	} else if username != "" {
-		s.config.DB.Model(&User{}).Where("username = ?", username).Count(&count)
-	} else {
+	if userID == 0 && username == "" {
 		return false, errors.New("at least one of userID or username must be provided")
 	}
 	
-	return count > 0, nil
+	return s.store().Users().Exists(s.context(), userID, username)
 }
\ No newline at end of file
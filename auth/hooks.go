@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrHookVetoed is wrapped by every HookError so callers can match any veto
+var ErrHookVetoed = errors.New("operation vetoed by hook")
+
+// HookError is returned by a before-hook to veto an operation. Code and Status
+// let the application control the error surfaced to API clients.
+type HookError struct {
+	Code    string // Machine-readable reason, e.g. "disposable_email"
+	Message string // Human-readable message safe to show to the client
+	Status  int    // HTTP status for the response, defaults to 403
+}
+
+// Veto creates a HookError with a 403 status
+func Veto(code, message string) *HookError {
+	return &HookError{Code: code, Message: message, Status: http.StatusForbidden}
+}
+
+// Error implements error
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap lets errors.Is(err, ErrHookVetoed) match any HookError
+func (e *HookError) Unwrap() error {
+	return ErrHookVetoed
+}
+
+// LoginInfo describes a login attempt for login hooks
+type LoginInfo struct {
+	Username  string
+	IP        string
+	UserAgent string
+	Time      time.Time
+}
+
+// Hook function signatures
+type (
+	BeforeRegisterHook       func(user Authenticatable) error
+	AfterRegisterHook        func(user Authenticatable)
+	BeforeLoginHook          func(user Authenticatable, info LoginInfo) error
+	AfterLoginHook           func(user Authenticatable, info LoginInfo)
+	ClaimsEnricher           func(user Authenticatable, claims map[string]interface{}) error
+	BeforePasswordChangeHook func(user Authenticatable, newPassword string) error
+	AfterPasswordChangeHook  func(user Authenticatable)
+)
+
+// Hooks is a registry of functions run around Service operations. Before-hooks
+// run in registration order and the first error vetoes the operation;
+// after-hooks run once the change has been committed. Hooks receive the
+// configured user model, so they can read application-defined fields.
+type Hooks struct {
+	mu                   sync.RWMutex
+	beforeRegister       []BeforeRegisterHook
+	afterRegister        []AfterRegisterHook
+	beforeLogin          []BeforeLoginHook
+	afterLogin           []AfterLoginHook
+	claimsEnrichers      []ClaimsEnricher
+	beforePasswordChange []BeforePasswordChangeHook
+	afterPasswordChange  []AfterPasswordChangeHook
+}
+
+// NewHooks creates an empty hook registry
+func NewHooks() *Hooks {
+	return &Hooks{}
+}
+
+// BeforeRegister adds a hook run before a user is created; it may modify the user
+func (h *Hooks) BeforeRegister(fn BeforeRegisterHook) *Hooks {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.beforeRegister = append(h.beforeRegister, fn)
+	return h
+}
+
+// AfterRegister adds a hook run after a user is created
+func (h *Hooks) AfterRegister(fn AfterRegisterHook) *Hooks {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.afterRegister = append(h.afterRegister, fn)
+	return h
+}
+
+// BeforeLogin adds a hook run once credentials are verified but before the login completes
+func (h *Hooks) BeforeLogin(fn BeforeLoginHook) *Hooks {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.beforeLogin = append(h.beforeLogin, fn)
+	return h
+}
+
+// AfterLogin adds a hook run after a successful login
+func (h *Hooks) AfterLogin(fn AfterLoginHook) *Hooks {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.afterLogin = append(h.afterLogin, fn)
+	return h
+}
+
+// EnrichClaims adds a function that puts custom claims into every issued token
+func (h *Hooks) EnrichClaims(fn ClaimsEnricher) *Hooks {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.claimsEnrichers = append(h.claimsEnrichers, fn)
+	return h
+}
+
+// BeforePasswordChange adds a hook run before a password is changed or reset
+func (h *Hooks) BeforePasswordChange(fn BeforePasswordChangeHook) *Hooks {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.beforePasswordChange = append(h.beforePasswordChange, fn)
+	return h
+}
+
+// AfterPasswordChange adds a hook run after a password is changed or reset
+func (h *Hooks) AfterPasswordChange(fn AfterPasswordChangeHook) *Hooks {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.afterPasswordChange = append(h.afterPasswordChange, fn)
+	return h
+}
+
+func (h *Hooks) runBeforeRegister(user Authenticatable) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, fn := range h.beforeRegister {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hooks) runAfterRegister(user Authenticatable) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, fn := range h.afterRegister {
+		fn(user)
+	}
+}
+
+func (h *Hooks) runBeforeLogin(user Authenticatable, info LoginInfo) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, fn := range h.beforeLogin {
+		if err := fn(user, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hooks) runAfterLogin(user Authenticatable, info LoginInfo) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, fn := range h.afterLogin {
+		fn(user, info)
+	}
+}
+
+// enrichClaims collects custom claims from every enricher, returning nil when none are set
+func (h *Hooks) enrichClaims(user Authenticatable) (map[string]interface{}, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.claimsEnrichers) == 0 {
+		return nil, nil
+	}
+
+	claims := map[string]interface{}{}
+	for _, fn := range h.claimsEnrichers {
+		if err := fn(user, claims); err != nil {
+			return nil, err
+		}
+	}
+	if len(claims) == 0 {
+		return nil, nil
+	}
+	return claims, nil
+}
+
+func (h *Hooks) runBeforePasswordChange(user Authenticatable, newPassword string) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, fn := range h.beforePasswordChange {
+		if err := fn(user, newPassword); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hooks) runAfterPasswordChange(user Authenticatable) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, fn := range h.afterPasswordChange {
+		fn(user)
+	}
+}
+
+// loginInfo describes the current request for login hooks
+func (s *Service) loginInfo(username string) LoginInfo {
+	return LoginInfo{
+		Username:  username,
+		IP:        s.request.IP,
+		UserAgent: s.request.UserAgent,
+		Time:      time.Now(),
+	}
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rb4807/Golang-Utlis-Postgresql/db"
+)
+
+// ImpersonationSession records a support user acting as another user. Rows are
+// never deleted, so the table doubles as the audit trail of impersonations.
+type ImpersonationSession struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	ImpersonatorID uint       `gorm:"index" json:"impersonator_id"`
+	TargetID       uint       `gorm:"index" json:"target_id"`
+	StartedAt      time.Time  `json:"started_at"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	EndedAt        *time.Time `json:"ended_at"`
+}
+
+// ActorClaim identifies the user really behind an impersonation token
+type ActorClaim struct {
+	UserID          uint   `json:"user_id"`
+	Username        string `json:"username"`
+	ImpersonationID uint   `json:"impersonation_id"`
+}
+
+// IsImpersonated reports whether the token was issued through Impersonate
+func (c *TokenClaims) IsImpersonated() bool {
+	return c.Actor != nil
+}
+
+// Impersonate calls ImpersonateContext with the service's context
+func (s *Service) Impersonate(adminID, targetID uint) (string, error) {
+	return s.ImpersonateContext(s.context(), adminID, targetID)
+}
+
+// ImpersonateContext mints a short-lived token that lets an administrator act as the
+// target user. Superusers can never be impersonated.
+func (s *Service) ImpersonateContext(ctx context.Context, adminID, targetID uint) (string, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if adminID == targetID {
+		return "", ErrCannotImpersonate
+	}
+
+	admin, err := s.GetUserByID(adminID)
+	if err != nil {
+		return "", err
+	}
+	if !admin.IsSuperuser || !admin.IsActive {
+		return "", ErrNotAuthorized
+	}
+
+	targetModel, err := s.GetUserModel(targetID)
+	if err != nil {
+		return "", err
+	}
+	target := targetModel.AuthUser()
+	if target.IsSuperuser {
+		return "", ErrCannotImpersonate
+	}
+
+	// The impersonated token carries the same application claims as the target's own
+	custom, err := s.config.Hooks.enrichClaims(targetModel)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	session := ImpersonationSession{
+		ImpersonatorID: admin.ID,
+		TargetID:       target.ID,
+		StartedAt:      now,
+		ExpiresAt:      now.Add(s.config.ImpersonationDuration),
+	}
+	if err := s.store().Impersonations().Create(s.context(), &session); err != nil {
+		return "", err
+	}
+
+	claims := TokenClaims{
+		UserID:      target.ID,
+		Username:    target.Username,
+		IsSuperuser: false,
+		Custom:      custom,
+		Actor: &ActorClaim{
+			UserID:          admin.ID,
+			Username:        admin.Username,
+			ImpersonationID: session.ID,
+		},
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: session.ExpiresAt.Unix(),
+			IssuedAt:  now.Unix(),
+		},
+	}
+
+	token, err := s.signClaims(&claims)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Impersonation %d started: user %d (%s) acting as user %d (%s)",
+		session.ID, admin.ID, admin.Username, target.ID, target.Username)
+	s.audit(AuditImpersonationStarted, admin.ID, target.ID, AuditMetadata{
+		"impersonation_id": session.ID,
+		"expires_at":       session.ExpiresAt,
+	})
+
+	return token, nil
+}
+
+// StopImpersonation calls StopImpersonationContext with the service's context
+func (s *Service) StopImpersonation(claims *TokenClaims) error {
+	return s.StopImpersonationContext(s.context(), claims)
+}
+
+// StopImpersonationContext ends the impersonation session behind the given claims so
+// its token is rejected from then on
+func (s *Service) StopImpersonationContext(ctx context.Context, claims *TokenClaims) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if !claims.IsImpersonated() {
+		return ErrNotImpersonating
+	}
+
+	if err := s.store().Impersonations().End(s.context(), claims.Actor.ImpersonationID, time.Now()); err != nil {
+		return err
+	}
+
+	log.Printf("Impersonation %d stopped: user %d no longer acting as user %d",
+		claims.Actor.ImpersonationID, claims.Actor.UserID, claims.UserID)
+	s.audit(AuditImpersonationStopped, claims.Actor.UserID, claims.UserID, AuditMetadata{
+		"impersonation_id": claims.Actor.ImpersonationID,
+	})
+
+	return nil
+}
+
+// ListImpersonations calls ListImpersonationsContext with the service's context
+func (s *Service) ListImpersonations(impersonatorID, targetID uint, limit int) ([]ImpersonationSession, error) {
+	return s.ListImpersonationsContext(s.context(), impersonatorID, targetID, limit)
+}
+
+// ListImpersonationsContext returns impersonation sessions, newest first, optionally
+// limited to one impersonator or target
+func (s *Service) ListImpersonationsContext(ctx context.Context, impersonatorID, targetID uint, limit int) ([]ImpersonationSession, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if limit <= 0 || limit > maxUserPageSize {
+		limit = defaultUserPageSize
+	}
+
+	return s.store().Impersonations().List(s.context(), impersonatorID, targetID, limit)
+}
+
+// impersonationActive reports whether an impersonation session is still running
+func (s *Service) impersonationActive(id uint) (bool, error) {
+	// Read from the primary so an ended impersonation stops working at once
+	if _, err := s.store().Impersonations().GetActive(db.Primary(s.context()), id, time.Now()); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -1,33 +1,90 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 	
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/rb4807/Golang-Utlis-Postgresql/db"
 )
 
 // TokenClaims represents the JWT token claims
 type TokenClaims struct {
-	UserID      uint   `json:"user_id"`
-	Username    string `json:"username"`
-	IsSuperuser bool   `json:"is_superuser"`
+	UserID      uint                   `json:"user_id"`
+	Username    string                 `json:"username"`
+	IsSuperuser bool                   `json:"is_superuser"`
+	Actor       *ActorClaim            `json:"act,omitempty"`       // Set when an administrator is impersonating the user
+	Custom      map[string]interface{} `json:"ext,omitempty"`       // Application claims added by Hooks.EnrichClaims
+	SessionID   uint                   `json:"sid,omitempty"`       // Session created by Login, revocable by the user
+	AuthTime    int64                  `json:"auth_time,omitempty"` // When the user last proved their identity, Unix seconds
+	AMR         []string               `json:"amr,omitempty"`       // Methods used at AuthTime, e.g. AMRPassword
 	jwt.StandardClaims
 }
 
-// GenerateJWT creates a new JWT token for the user
-func (s *Service) GenerateJWT(user *User) (string, error) {
+// Authentication method references for the amr claim (RFC 8176)
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+)
+
+// tokenOptions describes the authentication behind a token being issued
+type tokenOptions struct {
+	sessionID uint
+	authTime  time.Time
+	amr       []string
+	duration  time.Duration // Defaults to Config.TokenDuration
+}
+
+// GenerateJWT creates a new JWT token for the user. Claims enrichers receive the
+// model as given, so pass the application's user type to expose its fields.
+// The token is not tied to a session and carries no auth_time, so routes behind
+// RequireRecentAuth will ask for reauthentication; use Login for such tokens.
+func (s *Service) GenerateJWT(model Authenticatable) (string, error) {
+	token, _, err := s.generateToken(model, tokenOptions{})
+	return token, err
+}
+
+// generateToken creates a token for the user and returns it with its expiry
+func (s *Service) generateToken(model Authenticatable, opts tokenOptions) (string, time.Time, error) {
+	custom, err := s.config.Hooks.enrichClaims(model)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	user := model.AuthUser()
+
+	if opts.duration <= 0 {
+		opts.duration = s.config.TokenDuration
+	}
+	now := time.Now()
+	expiresAt := now.Add(opts.duration).Truncate(time.Second) // JWT expiry has second precision
+
 	claims := TokenClaims{
 		UserID:      user.ID,
 		Username:    user.Username,
 		IsSuperuser: user.IsSuperuser,
+		Custom:      custom,
+		SessionID:   opts.sessionID,
+		AMR:         opts.amr,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(s.config.TokenDuration).Unix(),
-			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  now.Unix(),
 		},
 	}
+	if !opts.authTime.IsZero() {
+		claims.AuthTime = opts.authTime.Unix()
+	}
 	
+	token, err := s.signClaims(&claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// signClaims signs the claims with the configured secret
+func (s *Service) signClaims(claims *TokenClaims) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	signedToken, err := token.SignedString([]byte(s.config.JWTSecret))
 	if err != nil {
@@ -58,25 +115,73 @@ func (s *Service) VerifyJWT(tokenString string) (*TokenClaims, error) {
 	return nil, errors.New("invalid token")
 }
 
-// RefreshJWT creates a new token with extended expiration time
+// RefreshJWT calls RefreshJWTContext with the service's context
 func (s *Service) RefreshJWT(tokenString string) (string, error) {
+	return s.RefreshJWTContext(s.context(), tokenString)
+}
+
+// RefreshJWTContext creates a new token with extended expiration time
+func (s *Service) RefreshJWTContext(ctx context.Context, tokenString string) (string, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
 	// First verify the existing token
 	claims, err := s.VerifyJWT(tokenString)
 	if err != nil {
 		return "", err
 	}
 	
-	// Create new token with same claims but new expiration
-	claims.StandardClaims.ExpiresAt = time.Now().Add(s.config.TokenDuration).Unix()
-	claims.StandardClaims.IssuedAt = time.Now().Unix()
-	
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(s.config.JWTSecret))
+	// Impersonation tokens are deliberately short-lived and cannot be extended
+	if claims.IsImpersonated() {
+		return "", ErrImpersonationForbidden
+	}
+
+	// A revoked session cannot be refreshed
+	if claims.SessionID != 0 {
+		active, err := s.sessionActive(claims)
+		if err != nil {
+			return "", err
+		}
+		if !active {
+			return "", ErrSessionNotFound
+		}
+	}
+
+	// Reload the user from the primary, so the new token reflects their current
+	// username, privileges and application claims instead of the old token's
+	model := s.newUser()
+	if err := s.store().Users().Get(db.Primary(s.context()), claims.UserID, model); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+	user := model.AuthUser()
+	if !user.IsActive {
+		return "", ErrUserInactive
+	}
+	custom, err := s.config.Hooks.enrichClaims(model)
 	if err != nil {
 		return "", err
 	}
+
+	// Create new token with the user's claims and a new expiration. auth_time
+	// and amr are kept: refreshing is not reauthenticating.
+	expiresAt := time.Now().Add(s.config.TokenDuration)
+	claims.Username = user.Username
+	claims.IsSuperuser = user.IsSuperuser
+	claims.Custom = custom
+	claims.StandardClaims.ExpiresAt = expiresAt.Unix()
+	claims.StandardClaims.IssuedAt = time.Now().Unix()
 	
-	return signedToken, nil
+	// An active session lives as long as its token
+	if claims.SessionID != 0 {
+		if err := s.store().Sessions().Extend(s.context(), claims.SessionID, expiresAt); err != nil {
+			return "", err
+		}
+	}
+	
+	return s.signClaims(claims)
 }
 
 // GetUserIDFromToken extracts the user ID from a token string
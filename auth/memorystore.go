@@ -0,0 +1,1560 @@
+package auth
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// memorySchemas caches the model schemas the memory store fills in on insert
+var memorySchemas sync.Map
+
+// MemoryStore keeps the auth records in memory. It is meant for tests: it is
+// safe for concurrent use, but a transaction holds the whole store until it
+// ends, so code inside one must use the Store it was given.
+type MemoryStore struct {
+	mu   *sync.Mutex
+	data *memoryData
+	tx   bool // Inside Transaction, where mu is already held
+}
+
+// memoryData holds one slice of rows per table
+type memoryData struct {
+	users           userTable
+	otps            memoryTable[OTP]
+	emailChanges    memoryTable[EmailChange]
+	sessions        memoryTable[Session]
+	deletions       memoryTable[AccountDeletion]
+	impersonations  memoryTable[ImpersonationSession]
+	devices         memoryTable[KnownDevice]
+	attempts        memoryTable[LoginAttempt]
+	challenges      memoryTable[LoginChallenge]
+	auditEvents     memoryTable[AuditEvent]
+	auditChainHead  string
+	outbox          memoryTable[OutboxMessage]
+	subscriptions   memoryTable[WebhookSubscription]
+	deliveries      memoryTable[WebhookDelivery]
+	webhookAttempts memoryTable[WebhookAttempt]
+	rateLimitHits   memoryTable[RateLimitHit]
+}
+
+// NewMemoryStore returns an empty Store held in memory
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{mu: &sync.Mutex{}, data: &memoryData{}}
+}
+
+func (m *MemoryStore) Users() UserRepository                       { return memoryUsers{m} }
+func (m *MemoryStore) OTPs() OTPRepository                         { return memoryOTPs{m} }
+func (m *MemoryStore) EmailChanges() EmailChangeRepository         { return memoryEmailChanges{m} }
+func (m *MemoryStore) Sessions() SessionRepository                 { return memorySessions{m} }
+func (m *MemoryStore) AccountDeletions() AccountDeletionRepository { return memoryAccountDeletions{m} }
+func (m *MemoryStore) Impersonations() ImpersonationRepository     { return memoryImpersonations{m} }
+func (m *MemoryStore) KnownDevices() KnownDeviceRepository         { return memoryKnownDevices{m} }
+func (m *MemoryStore) LoginAttempts() LoginAttemptRepository       { return memoryLoginAttempts{m} }
+func (m *MemoryStore) LoginChallenges() LoginChallengeRepository   { return memoryLoginChallenges{m} }
+func (m *MemoryStore) AuditEvents() AuditRepository                { return memoryAuditEvents{m} }
+func (m *MemoryStore) Outbox() OutboxRepository                    { return memoryOutbox{m} }
+func (m *MemoryStore) Webhooks() WebhookRepository                 { return memoryWebhooks{m} }
+func (m *MemoryStore) RateLimits() RateLimitRepository             { return memoryRateLimits{m} }
+
+// Transaction runs fn while holding the store, restoring its earlier state
+// when fn fails
+func (m *MemoryStore) Transaction(ctx context.Context, fn func(tx Store) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !m.tx {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+	}
+
+	saved := m.data.clone()
+	if err := fn(&MemoryStore{mu: m.mu, data: m.data, tx: true}); err != nil {
+		*m.data = saved
+		return err
+	}
+	return nil
+}
+
+// open gives a repository method the store's data, locked until unlock is called
+func (m *MemoryStore) open(ctx context.Context) (data *memoryData, unlock func(), err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if m.tx {
+		return m.data, func() {}, nil
+	}
+	m.mu.Lock()
+	return m.data, m.mu.Unlock, nil
+}
+
+// clone copies the row slices so a failed transaction can be undone. Rows are
+// never changed in place, so they can be shared.
+func (d *memoryData) clone() memoryData {
+	return memoryData{
+		users:           d.users.clone(),
+		otps:            d.otps.clone(),
+		emailChanges:    d.emailChanges.clone(),
+		sessions:        d.sessions.clone(),
+		deletions:       d.deletions.clone(),
+		impersonations:  d.impersonations.clone(),
+		devices:         d.devices.clone(),
+		attempts:        d.attempts.clone(),
+		challenges:      d.challenges.clone(),
+		auditEvents:     d.auditEvents.clone(),
+		auditChainHead:  d.auditChainHead,
+		outbox:          d.outbox.clone(),
+		subscriptions:   d.subscriptions.clone(),
+		deliveries:      d.deliveries.clone(),
+		webhookAttempts: d.webhookAttempts.clone(),
+		rateLimitHits:   d.rateLimitHits.clone(),
+	}
+}
+
+// memoryTable holds the rows of one model in ID order
+type memoryTable[T any] struct {
+	rows   []*T
+	nextID uint
+}
+
+func (t *memoryTable[T]) clone() memoryTable[T] {
+	return memoryTable[T]{rows: append([]*T(nil), t.rows...), nextID: t.nextID}
+}
+
+// insert stores a copy of row after filling in its ID, defaults and timestamps
+func (t *memoryTable[T]) insert(ctx context.Context, row *T) error {
+	id, err := prepareInsert(ctx, row, t.nextID+1)
+	if err != nil {
+		return err
+	}
+	if id > t.nextID {
+		t.nextID = id
+	}
+	stored := *row
+	t.rows = append(t.rows, &stored)
+	return nil
+}
+
+// find returns copies of the rows matching match
+func (t *memoryTable[T]) find(match func(row *T) bool) []T {
+	found := []T{}
+	for _, row := range t.rows {
+		if match(row) {
+			found = append(found, *row)
+		}
+	}
+	return found
+}
+
+// first returns a copy of the first row matching match
+func (t *memoryTable[T]) first(match func(row *T) bool) (*T, error) {
+	for _, row := range t.rows {
+		if match(row) {
+			found := *row
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// update applies change to copies of the rows matching match, replacing them,
+// and returns how many there were
+func (t *memoryTable[T]) update(match func(row *T) bool, change func(row *T)) int64 {
+	var n int64
+	for i, row := range t.rows {
+		if match(row) {
+			updated := *row
+			change(&updated)
+			t.rows[i] = &updated
+			n++
+		}
+	}
+	return n
+}
+
+// delete removes the rows matching match and returns how many there were
+func (t *memoryTable[T]) delete(match func(row *T) bool) int64 {
+	kept := make([]*T, 0, len(t.rows))
+	for _, row := range t.rows {
+		if !match(row) {
+			kept = append(kept, row)
+		}
+	}
+	n := int64(len(t.rows) - len(kept))
+	t.rows = kept
+	return n
+}
+
+// prepareInsert fills in what a database would on insert: the primary key,
+// when it is zero, column defaults for zero fields and automatic timestamps.
+// It returns the row's ID.
+func prepareInsert(ctx context.Context, model interface{}, nextID uint) (uint, error) {
+	s, err := schema.Parse(model, &memorySchemas, schema.NamingStrategy{})
+	if err != nil {
+		return 0, err
+	}
+	value := reflect.ValueOf(model).Elem()
+	now := time.Now()
+
+	for _, field := range s.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		if _, zero := field.ValueOf(ctx, value); !zero {
+			continue
+		}
+		switch {
+		case field == s.PrioritizedPrimaryField:
+			err = field.Set(ctx, value, nextID)
+		case field.AutoCreateTime > 0 || field.AutoUpdateTime > 0:
+			err = field.Set(ctx, value, now)
+		case field.DefaultValueInterface != nil:
+			err = field.Set(ctx, value, field.DefaultValueInterface)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	id, _ := s.PrioritizedPrimaryField.ValueOf(ctx, value)
+	return id.(uint), nil
+}
+
+// userTable holds users as copies of the models they were created from
+type userTable struct {
+	rows   []Authenticatable
+	nextID uint
+}
+
+func (t *userTable) clone() userTable {
+	return userTable{rows: append([]Authenticatable(nil), t.rows...), nextID: t.nextID}
+}
+
+// index returns the position of the user with the given ID, soft-deleted
+// or not, or -1
+func (t *userTable) index(id uint) int {
+	for i, row := range t.rows {
+		if row.AuthUser().ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// conflict returns the error for another undeleted user already holding the
+// username or email, as the unique indexes of the users table would
+func (t *userTable) conflict(user *User) error {
+	for _, row := range t.rows {
+		other := row.AuthUser()
+		if other.ID == user.ID || other.DeletedAt.Valid {
+			continue
+		}
+		if other.Username == user.Username {
+			return ErrUsernameExists
+		}
+		if other.Email == user.Email {
+			return ErrEmailExists
+		}
+	}
+	return nil
+}
+
+// cloneUser returns a copy of a user model
+func cloneUser(model Authenticatable) Authenticatable {
+	clone := newModelOf(model)
+	reflect.ValueOf(clone).Elem().Set(reflect.ValueOf(model).Elem())
+	return clone
+}
+
+// copyUser fills dest from a stored user, taking only the User fields when
+// dest is a different model
+func copyUser(dest, stored Authenticatable) {
+	if reflect.TypeOf(dest) == reflect.TypeOf(stored) {
+		reflect.ValueOf(dest).Elem().Set(reflect.ValueOf(stored).Elem())
+		return
+	}
+	*dest.AuthUser() = *stored.AuthUser()
+}
+
+// setUserColumns writes fields, keyed by column name, into a user model
+func setUserColumns(ctx context.Context, model Authenticatable, fields map[string]interface{}) error {
+	s, err := schema.Parse(model, &memorySchemas, schema.NamingStrategy{})
+	if err != nil {
+		return err
+	}
+	value := reflect.ValueOf(model).Elem()
+	for column, v := range fields {
+		field := s.LookUpField(column)
+		if field == nil {
+			return &unknownColumnError{column}
+		}
+		if err := field.Set(ctx, value, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unknownColumnError reports an update of a column the user model lacks
+type unknownColumnError struct {
+	column string
+}
+
+func (e *unknownColumnError) Error() string {
+	return "unknown user column " + e.column
+}
+
+type memoryUsers struct{ m *MemoryStore }
+
+func (r memoryUsers) Create(ctx context.Context, model Authenticatable) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	stored := cloneUser(model)
+	id, err := prepareInsert(ctx, stored, d.users.nextID+1)
+	if err != nil {
+		return err
+	}
+	if err := d.users.conflict(stored.AuthUser()); err != nil {
+		return err
+	}
+	if id > d.users.nextID {
+		d.users.nextID = id
+	}
+	d.users.rows = append(d.users.rows, stored)
+	copyUser(model, stored)
+	return nil
+}
+
+func (r memoryUsers) get(ctx context.Context, dest Authenticatable, match func(user *User) bool) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, row := range d.users.rows {
+		if user := row.AuthUser(); !user.DeletedAt.Valid && match(user) {
+			copyUser(dest, row)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r memoryUsers) Get(ctx context.Context, id uint, dest Authenticatable) error {
+	return r.get(ctx, dest, func(user *User) bool { return user.ID == id })
+}
+
+func (r memoryUsers) GetByLogin(ctx context.Context, login string, dest Authenticatable) error {
+	return r.get(ctx, dest, func(user *User) bool { return user.Username == login || user.Email == login })
+}
+
+func (r memoryUsers) GetActiveByEmail(ctx context.Context, email string, dest Authenticatable) error {
+	return r.get(ctx, dest, func(user *User) bool { return strings.EqualFold(user.Email, email) && user.IsActive })
+}
+
+func (r memoryUsers) Lock(ctx context.Context, id uint) (*User, error) {
+	var user User
+	if err := r.Get(ctx, id, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r memoryUsers) LockUnscoped(ctx context.Context, id uint) (*User, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	i := d.users.index(id)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	user := *d.users.rows[i].AuthUser()
+	return &user, nil
+}
+
+func (r memoryUsers) List(ctx context.Context, q UserQuery) ([]User, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	search := strings.ToLower(q.Search)
+	users := []User{}
+	for _, row := range d.users.rows {
+		user := row.AuthUser()
+		switch {
+		case user.DeletedAt.Valid != q.Deleted:
+		case q.DeletedBefore != nil && !user.DeletedAt.Time.Before(*q.DeletedBefore):
+		case search != "" && !strings.Contains(strings.ToLower(user.Username), search) &&
+			!strings.Contains(strings.ToLower(user.Email), search):
+		case q.IsActive != nil && user.IsActive != *q.IsActive:
+		case q.IsSuperuser != nil && user.IsSuperuser != *q.IsSuperuser:
+		case q.DateJoinedFrom != nil && user.DateJoined.Before(*q.DateJoinedFrom):
+		case q.DateJoinedTo != nil && user.DateJoined.After(*q.DateJoinedTo):
+		case q.AfterID > 0 && compareUserPosition(user, q.SortBy, q.AfterValue, q.AfterID, q.SortDesc) <= 0:
+		default:
+			users = append(users, *user)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return compareUserPosition(&users[i], q.SortBy, userSortKey(&users[j], q.SortBy), users[j].ID, q.SortDesc) < 0
+	})
+	if len(users) > q.Limit {
+		users = users[:q.Limit]
+	}
+	return users, nil
+}
+
+// userSortKey returns the value of the sort column for a user
+func userSortKey(user *User, column string) interface{} {
+	switch column {
+	case "username":
+		return user.Username
+	case "email":
+		return user.Email
+	case "date_joined":
+		return user.DateJoined
+	default:
+		return user.ID
+	}
+}
+
+// compareUserPosition orders a user against the position given by a sort
+// value and ID, returning a negative number when the user comes first
+func compareUserPosition(user *User, column string, value interface{}, id uint, desc bool) int {
+	c := 0
+	switch key := userSortKey(user, column).(type) {
+	case string:
+		c = strings.Compare(key, value.(string))
+	case time.Time:
+		c = key.Compare(value.(time.Time))
+	}
+	if c == 0 {
+		switch {
+		case user.ID < id:
+			c = -1
+		case user.ID > id:
+			c = 1
+		}
+	}
+	if desc {
+		return -c
+	}
+	return c
+}
+
+func (r memoryUsers) Exists(ctx context.Context, id uint, username string) (bool, error) {
+	var user User
+	err := r.get(ctx, &user, func(user *User) bool {
+		return (id == 0 || user.ID == id) && (username == "" || user.Username == username)
+	})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r memoryUsers) Taken(ctx context.Context, column, value string, exceptID uint) (bool, error) {
+	var user User
+	err := r.get(ctx, &user, func(user *User) bool {
+		current := user.Username
+		if column == "email" {
+			current = user.Email
+		}
+		return user.ID != exceptID && strings.EqualFold(current, value)
+	})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// change replaces a stored user with a copy altered by fn, when the user exists
+// and is not soft-deleted unless unscoped is set
+func (r memoryUsers) change(ctx context.Context, id uint, unscoped bool, fn func(model Authenticatable) (bool, error)) (bool, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	i := d.users.index(id)
+	if i < 0 || (!unscoped && d.users.rows[i].AuthUser().DeletedAt.Valid) {
+		return false, nil
+	}
+	updated := cloneUser(d.users.rows[i])
+	ok, err := fn(updated)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := d.users.conflict(updated.AuthUser()); err != nil {
+		return false, err
+	}
+	d.users.rows[i] = updated
+	return true, nil
+}
+
+func (r memoryUsers) Update(ctx context.Context, id uint, fields map[string]interface{}) error {
+	_, err := r.change(ctx, id, false, func(model Authenticatable) (bool, error) {
+		return true, setUserColumns(ctx, model, fields)
+	})
+	return err
+}
+
+func (r memoryUsers) UpdateUnscoped(ctx context.Context, id uint, fields map[string]interface{}) error {
+	_, err := r.change(ctx, id, true, func(model Authenticatable) (bool, error) {
+		return true, setUserColumns(ctx, model, fields)
+	})
+	return err
+}
+
+func (r memoryUsers) UpdateExtra(ctx context.Context, model Authenticatable) error {
+	columns, err := extraUserColumns(model, &memorySchemas, schema.NamingStrategy{})
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+
+	s, err := schema.Parse(model, &memorySchemas, schema.NamingStrategy{})
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		fields[column], _ = s.LookUpField(column).ValueOf(ctx, reflect.ValueOf(model).Elem())
+	}
+
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	i := d.users.index(model.AuthUser().ID)
+	if i < 0 || d.users.rows[i].AuthUser().DeletedAt.Valid {
+		return nil
+	}
+	// The row gains the model's columns, as it would in a table holding them
+	updated := newModelOf(model)
+	*updated.AuthUser() = *d.users.rows[i].AuthUser()
+	if reflect.TypeOf(updated) == reflect.TypeOf(d.users.rows[i]) {
+		updated = cloneUser(d.users.rows[i])
+	}
+	if err := setUserColumns(ctx, updated, fields); err != nil {
+		return err
+	}
+	d.users.rows[i] = updated
+	return nil
+}
+
+func (r memoryUsers) ReplacePassword(ctx context.Context, id uint, currentHash, newHash string, changedAt time.Time) (bool, error) {
+	return r.change(ctx, id, false, func(model Authenticatable) (bool, error) {
+		user := model.AuthUser()
+		if user.Password != currentHash {
+			return false, nil
+		}
+		user.Password = newHash
+		user.PasswordChanged = &changedAt
+		return true, nil
+	})
+}
+
+func (r memoryUsers) SoftDelete(ctx context.Context, id uint, at time.Time) error {
+	deleted, err := r.change(ctx, id, false, func(model Authenticatable) (bool, error) {
+		model.AuthUser().DeletedAt = gorm.DeletedAt{Time: at, Valid: true}
+		return true, nil
+	})
+	if err == nil && !deleted {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r memoryUsers) Restore(ctx context.Context, id uint) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	i := d.users.index(id)
+	if i < 0 || !d.users.rows[i].AuthUser().DeletedAt.Valid {
+		return ErrNotFound
+	}
+	restored := cloneUser(d.users.rows[i])
+	restored.AuthUser().DeletedAt = gorm.DeletedAt{}
+	if err := d.users.conflict(restored.AuthUser()); err != nil {
+		return err
+	}
+	d.users.rows[i] = restored
+	return nil
+}
+
+func (r memoryUsers) Delete(ctx context.Context, id uint) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	i := d.users.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	d.removeUser(i)
+	return nil
+}
+
+func (r memoryUsers) Purge(ctx context.Context, id uint, deletedBefore time.Time) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	i := d.users.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	if deletedAt := d.users.rows[i].AuthUser().DeletedAt; !deletedAt.Valid || !deletedAt.Time.Before(deletedBefore) {
+		return ErrNotFound
+	}
+	d.removeUser(i)
+	return nil
+}
+
+// removeUser deletes the user at index i of the users table
+func (d *memoryData) removeUser(i int) {
+	id := d.users.rows[i].AuthUser().ID
+	d.users.rows = append(append([]Authenticatable(nil), d.users.rows[:i]...), d.users.rows[i+1:]...)
+
+	// The foreign keys of these tables cascade
+	d.otps.delete(func(o *OTP) bool { return o.UserID == id })
+	d.emailChanges.delete(func(c *EmailChange) bool { return c.UserID == id })
+	d.sessions.delete(func(s *Session) bool { return s.UserID == id })
+	d.devices.delete(func(k *KnownDevice) bool { return k.UserID == id })
+	d.challenges.delete(func(c *LoginChallenge) bool { return c.UserID == id })
+}
+
+type memoryOTPs struct{ m *MemoryStore }
+
+func (r memoryOTPs) Create(ctx context.Context, otp *OTP) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.otps.insert(ctx, otp)
+}
+
+func (r memoryOTPs) AddAttempt(ctx context.Context, userID uint, purpose string, now time.Time, maxAttempts int) (bool, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	n := d.otps.update(func(o *OTP) bool {
+		return o.UserID == userID && o.Purpose == purpose && o.ExpiresAt.After(now) && !o.Verified && o.Attempts < maxAttempts
+	}, func(o *OTP) { o.Attempts++ })
+	return n > 0, nil
+}
+
+func (r memoryOTPs) Consume(ctx context.Context, userID uint, purpose, value string, now time.Time) (bool, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	n := d.otps.update(func(o *OTP) bool {
+		return o.UserID == userID && o.Purpose == purpose && o.OTPValue == value && o.ExpiresAt.After(now) && !o.Verified
+	}, func(o *OTP) { o.Verified = true })
+	return n > 0, nil
+}
+
+func (r memoryOTPs) ListForUser(ctx context.Context, userID uint) ([]OTP, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.otps.find(func(o *OTP) bool { return o.UserID == userID }), nil
+}
+
+func (r memoryOTPs) DeleteForUser(ctx context.Context, userID uint) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.otps.delete(func(o *OTP) bool { return o.UserID == userID })
+	return nil
+}
+
+func (r memoryOTPs) DeleteForPurpose(ctx context.Context, userID uint, purpose string) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.otps.delete(func(o *OTP) bool { return o.UserID == userID && o.Purpose == purpose })
+	return nil
+}
+
+type memoryEmailChanges struct{ m *MemoryStore }
+
+func (r memoryEmailChanges) Create(ctx context.Context, change *EmailChange) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.emailChanges.insert(ctx, change)
+}
+
+func (r memoryEmailChanges) GetPending(ctx context.Context, userID uint, tokenHash string, now time.Time) (*EmailChange, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.emailChanges.first(func(c *EmailChange) bool {
+		return c.UserID == userID && c.TokenHash == tokenHash && c.ExpiresAt.After(now) && c.ConfirmedAt == nil
+	})
+}
+
+func (r memoryEmailChanges) Confirm(ctx context.Context, id uint, at time.Time) (bool, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	n := d.emailChanges.update(func(c *EmailChange) bool {
+		return c.ID == id && c.ConfirmedAt == nil
+	}, func(c *EmailChange) { c.ConfirmedAt = &at })
+	return n > 0, nil
+}
+
+func (r memoryEmailChanges) ListForUser(ctx context.Context, userID uint) ([]EmailChange, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.emailChanges.find(func(c *EmailChange) bool { return c.UserID == userID }), nil
+}
+
+func (r memoryEmailChanges) DeletePending(ctx context.Context, userID uint) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.emailChanges.delete(func(c *EmailChange) bool { return c.UserID == userID && c.ConfirmedAt == nil })
+	return nil
+}
+
+func (r memoryEmailChanges) DeleteForUser(ctx context.Context, userID uint) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.emailChanges.delete(func(c *EmailChange) bool { return c.UserID == userID })
+	return nil
+}
+
+type memorySessions struct{ m *MemoryStore }
+
+// active reports whether a session is neither revoked nor expired
+func (s *Session) active(now time.Time) bool {
+	return s.RevokedAt == nil && s.ExpiresAt.After(now)
+}
+
+func (r memorySessions) Create(ctx context.Context, session *Session) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.sessions.insert(ctx, session)
+}
+
+func (r memorySessions) GetActive(ctx context.Context, id, userID uint, now time.Time) (*Session, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.sessions.first(func(s *Session) bool { return s.ID == id && s.UserID == userID && s.active(now) })
+}
+
+func (r memorySessions) GetByRefreshHash(ctx context.Context, refreshHash string, now time.Time) (*Session, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.sessions.first(func(s *Session) bool { return s.RefreshTokenHash == refreshHash && s.active(now) })
+}
+
+func (r memorySessions) ListActive(ctx context.Context, userID uint, now time.Time) ([]Session, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	sessions := d.sessions.find(func(s *Session) bool { return s.UserID == userID && s.active(now) })
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+func (r memorySessions) ListForUser(ctx context.Context, userID uint) ([]Session, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.sessions.find(func(s *Session) bool { return s.UserID == userID }), nil
+}
+
+func (r memorySessions) Touch(ctx context.Context, id uint, at time.Time) error {
+	return r.set(ctx, id, func(s *Session) { s.LastSeenAt = at })
+}
+
+func (r memorySessions) Extend(ctx context.Context, id uint, expiresAt time.Time) error {
+	return r.set(ctx, id, func(s *Session) { s.ExpiresAt = expiresAt })
+}
+
+// set applies change to the session with the given ID
+func (r memorySessions) set(ctx context.Context, id uint, change func(s *Session)) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.sessions.update(func(s *Session) bool { return s.ID == id }, change)
+	return nil
+}
+
+func (r memorySessions) RotateRefresh(ctx context.Context, id uint, currentHash, newHash string, expiresAt time.Time) (bool, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	n := d.sessions.update(func(s *Session) bool {
+		return s.ID == id && s.RevokedAt == nil && (currentHash == "" || s.RefreshTokenHash == currentHash)
+	}, func(s *Session) {
+		s.RefreshTokenHash = newHash
+		s.ExpiresAt = expiresAt
+	})
+	return n > 0, nil
+}
+
+func (r memorySessions) Revoke(ctx context.Context, userID, id uint, at time.Time) (bool, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	n := d.sessions.update(func(s *Session) bool {
+		return s.ID == id && s.UserID == userID && s.RevokedAt == nil
+	}, func(s *Session) { s.RevokedAt = &at })
+	return n > 0, nil
+}
+
+func (r memorySessions) RevokeOthers(ctx context.Context, userID, exceptID uint, now time.Time) (int64, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return d.sessions.update(func(s *Session) bool {
+		return s.UserID == userID && s.ID != exceptID && s.active(now)
+	}, func(s *Session) { s.RevokedAt = &now }), nil
+}
+
+func (r memorySessions) End(ctx context.Context, userID, id uint, at time.Time) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	d.sessions.update(func(s *Session) bool {
+		return s.ID == id && s.UserID == userID && s.RevokedAt == nil
+	}, func(s *Session) {
+		s.RevokedAt = &at
+		s.RefreshTokenHash = ""
+	})
+	return nil
+}
+
+func (r memorySessions) DeleteForUser(ctx context.Context, userID uint) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.sessions.delete(func(s *Session) bool { return s.UserID == userID })
+	return nil
+}
+
+type memoryAccountDeletions struct{ m *MemoryStore }
+
+// pending reports whether a deletion is neither cancelled nor completed
+func (a *AccountDeletion) pending() bool {
+	return a.CancelledAt == nil && a.CompletedAt == nil
+}
+
+func (r memoryAccountDeletions) Create(ctx context.Context, deletion *AccountDeletion) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.deletions.insert(ctx, deletion)
+}
+
+func (r memoryAccountDeletions) GetPending(ctx context.Context, userID uint) (*AccountDeletion, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.deletions.first(func(a *AccountDeletion) bool { return a.UserID == userID && a.pending() })
+}
+
+func (r memoryAccountDeletions) ListDue(ctx context.Context, now time.Time) ([]AccountDeletion, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.deletions.find(func(a *AccountDeletion) bool { return !a.ScheduledFor.After(now) && a.pending() }), nil
+}
+
+func (r memoryAccountDeletions) ListForUser(ctx context.Context, userID uint) ([]AccountDeletion, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.deletions.find(func(a *AccountDeletion) bool { return a.UserID == userID }), nil
+}
+
+func (r memoryAccountDeletions) Cancel(ctx context.Context, userID uint, at time.Time) (bool, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	n := d.deletions.update(func(a *AccountDeletion) bool {
+		return a.UserID == userID && a.pending()
+	}, func(a *AccountDeletion) { a.CancelledAt = &at })
+	return n > 0, nil
+}
+
+func (r memoryAccountDeletions) Complete(ctx context.Context, id uint, at time.Time) (bool, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	n := d.deletions.update(func(a *AccountDeletion) bool {
+		return a.ID == id && a.pending()
+	}, func(a *AccountDeletion) { a.CompletedAt = &at })
+	return n > 0, nil
+}
+
+type memoryImpersonations struct{ m *MemoryStore }
+
+func (r memoryImpersonations) Create(ctx context.Context, session *ImpersonationSession) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.impersonations.insert(ctx, session)
+}
+
+func (r memoryImpersonations) GetActive(ctx context.Context, id uint, now time.Time) (*ImpersonationSession, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.impersonations.first(func(s *ImpersonationSession) bool {
+		return s.ID == id && s.EndedAt == nil && s.ExpiresAt.After(now)
+	})
+}
+
+func (r memoryImpersonations) List(ctx context.Context, impersonatorID, targetID uint, limit int) ([]ImpersonationSession, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	sessions := d.impersonations.find(func(s *ImpersonationSession) bool {
+		return (impersonatorID == 0 || s.ImpersonatorID == impersonatorID) && (targetID == 0 || s.TargetID == targetID)
+	})
+	return newestFirst(sessions, limit), nil
+}
+
+func (r memoryImpersonations) End(ctx context.Context, id uint, at time.Time) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.impersonations.update(func(s *ImpersonationSession) bool {
+		return s.ID == id && s.EndedAt == nil
+	}, func(s *ImpersonationSession) { s.EndedAt = &at })
+	return nil
+}
+
+// newestFirst reverses rows found in ID order and keeps at most limit of them
+func newestFirst[T any](rows []T, limit int) []T {
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+type memoryKnownDevices struct{ m *MemoryStore }
+
+func (r memoryKnownDevices) Known(ctx context.Context, userID uint, fingerprint string) (bool, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	_, err = d.devices.first(func(k *KnownDevice) bool { return k.UserID == userID && k.Fingerprint == fingerprint })
+	return err == nil, nil
+}
+
+func (r memoryKnownDevices) Remember(ctx context.Context, device *KnownDevice) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	n := d.devices.update(func(k *KnownDevice) bool {
+		return k.UserID == device.UserID && k.Fingerprint == device.Fingerprint
+	}, func(k *KnownDevice) {
+		k.LastIP = device.LastIP
+		k.LastLocation = device.LastLocation
+		k.LastSeenAt = device.LastSeenAt
+	})
+	if n > 0 {
+		return nil
+	}
+	return d.devices.insert(ctx, device)
+}
+
+func (r memoryKnownDevices) ListForUser(ctx context.Context, userID uint) ([]KnownDevice, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.devices.find(func(k *KnownDevice) bool { return k.UserID == userID }), nil
+}
+
+func (r memoryKnownDevices) DeleteForUser(ctx context.Context, userID uint) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.devices.delete(func(k *KnownDevice) bool { return k.UserID == userID })
+	return nil
+}
+
+type memoryLoginAttempts struct{ m *MemoryStore }
+
+func (r memoryLoginAttempts) Create(ctx context.Context, attempt *LoginAttempt) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.attempts.insert(ctx, attempt)
+}
+
+func (r memoryLoginAttempts) LastLocatedSuccess(ctx context.Context, userID uint) (*LoginAttempt, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var last *LoginAttempt
+	for _, a := range d.attempts.rows {
+		if a.UserID == userID && a.Succeeded && a.Latitude != nil && (last == nil || !a.CreatedAt.Before(last.CreatedAt)) {
+			last = a
+		}
+	}
+	if last == nil {
+		return nil, ErrNotFound
+	}
+	found := *last
+	return &found, nil
+}
+
+func (r memoryLoginAttempts) CountUserFailures(ctx context.Context, userID uint, reasons []string, since time.Time) (int64, error) {
+	return r.countFailures(ctx, reasons, since, func(a *LoginAttempt) bool { return a.UserID == userID })
+}
+
+func (r memoryLoginAttempts) CountIPFailures(ctx context.Context, ip string, reasons []string, since time.Time) (int64, error) {
+	return r.countFailures(ctx, reasons, since, func(a *LoginAttempt) bool { return a.IP == ip })
+}
+
+func (r memoryLoginAttempts) countFailures(ctx context.Context, reasons []string, since time.Time, match func(a *LoginAttempt) bool) (int64, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var count int64
+	for _, a := range d.attempts.rows {
+		if !a.Succeeded && a.CreatedAt.After(since) && containsString(reasons, a.Reason) && match(a) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r memoryLoginAttempts) ListForUser(ctx context.Context, userID uint) ([]LoginAttempt, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.attempts.find(func(a *LoginAttempt) bool { return a.UserID == userID }), nil
+}
+
+func (r memoryLoginAttempts) DeleteForUser(ctx context.Context, userID uint) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.attempts.delete(func(a *LoginAttempt) bool { return a.UserID == userID })
+	return nil
+}
+
+type memoryLoginChallenges struct{ m *MemoryStore }
+
+func (r memoryLoginChallenges) Create(ctx context.Context, challenge *LoginChallenge) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.challenges.insert(ctx, challenge)
+}
+
+func (r memoryLoginChallenges) GetOpen(ctx context.Context, tokenHash string, now time.Time, maxAttempts int) (*LoginChallenge, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.challenges.first(func(c *LoginChallenge) bool {
+		return c.TokenHash == tokenHash && c.CompletedAt == nil && c.ExpiresAt.After(now) && c.Attempts < maxAttempts
+	})
+}
+
+func (r memoryLoginChallenges) AddAttempt(ctx context.Context, id uint) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.challenges.update(func(c *LoginChallenge) bool { return c.ID == id }, func(c *LoginChallenge) { c.Attempts++ })
+	return nil
+}
+
+func (r memoryLoginChallenges) Complete(ctx context.Context, id uint, at time.Time) (bool, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	n := d.challenges.update(func(c *LoginChallenge) bool {
+		return c.ID == id && c.CompletedAt == nil
+	}, func(c *LoginChallenge) { c.CompletedAt = &at })
+	return n > 0, nil
+}
+
+func (r memoryLoginChallenges) DeleteForUser(ctx context.Context, userID uint) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.challenges.delete(func(c *LoginChallenge) bool { return c.UserID == userID })
+	return nil
+}
+
+type memoryAuditEvents struct{ m *MemoryStore }
+
+func (r memoryAuditEvents) Create(ctx context.Context, event *AuditEvent) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.auditEvents.insert(ctx, event)
+}
+
+func (r memoryAuditEvents) LockChainHead(ctx context.Context) (string, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	return d.auditChainHead, nil
+}
+
+func (r memoryAuditEvents) ChainHead(ctx context.Context) (string, error) {
+	return r.LockChainHead(ctx)
+}
+
+func (r memoryAuditEvents) SetChainHead(ctx context.Context, hash string) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.auditChainHead = hash
+	return nil
+}
+
+func (r memoryAuditEvents) List(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	events := d.auditEvents.find(func(e *AuditEvent) bool {
+		switch {
+		case filter.ActorID > 0 && (e.ActorID == nil || *e.ActorID != filter.ActorID):
+		case filter.TargetID > 0 && (e.TargetID == nil || *e.TargetID != filter.TargetID):
+		case filter.Action != "" && e.Action != filter.Action:
+		case filter.From != nil && e.CreatedAt.Before(*filter.From):
+		case filter.To != nil && e.CreatedAt.After(*filter.To):
+		case filter.BeforeID > 0 && e.ID >= filter.BeforeID:
+		default:
+			return true
+		}
+		return false
+	})
+	return newestFirst(events, filter.Limit), nil
+}
+
+func (r memoryAuditEvents) ListForUser(ctx context.Context, userID uint) ([]AuditEvent, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.auditEvents.find(func(e *AuditEvent) bool {
+		return (e.ActorID != nil && *e.ActorID == userID) || (e.TargetID != nil && *e.TargetID == userID)
+	}), nil
+}
+
+func (r memoryAuditEvents) ListFailedLogins(ctx context.Context, login string) ([]AuditEvent, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.auditEvents.find(func(e *AuditEvent) bool {
+		return e.Action == AuditLoginFailed && e.Metadata["username"] == login
+	}), nil
+}
+
+func (r memoryAuditEvents) Scrub(ctx context.Context, id uint, ip, userAgent string, metadata AuditMetadata) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.auditEvents.update(func(e *AuditEvent) bool { return e.ID == id && e.Hash == "" }, func(e *AuditEvent) {
+		e.IP, e.UserAgent, e.Metadata = ip, userAgent, metadata
+	})
+	return nil
+}
+
+func (r memoryAuditEvents) EachHashed(ctx context.Context, batchSize int, fn func(events []AuditEvent) error) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	events := d.auditEvents.find(func(e *AuditEvent) bool { return e.Hash != "" })
+	unlock()
+
+	for start := 0; start < len(events); start += batchSize {
+		end := start + batchSize
+		if end > len(events) {
+			end = len(events)
+		}
+		if err := fn(events[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type memoryOutbox struct{ m *MemoryStore }
+
+func (r memoryOutbox) Create(ctx context.Context, message *OutboxMessage) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.outbox.insert(ctx, message)
+}
+
+func (r memoryOutbox) Get(ctx context.Context, id uint) (*OutboxMessage, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.outbox.first(func(o *OutboxMessage) bool { return o.ID == id })
+}
+
+func (r memoryOutbox) ClaimUnprocessed(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	messages := d.outbox.find(func(o *OutboxMessage) bool { return o.ProcessedAt == nil })
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+func (r memoryOutbox) MarkProcessed(ctx context.Context, id uint, at time.Time) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.outbox.update(func(o *OutboxMessage) bool { return o.ID == id }, func(o *OutboxMessage) { o.ProcessedAt = &at })
+	return nil
+}
+
+func (r memoryOutbox) ListForUser(ctx context.Context, userID uint) ([]OutboxMessage, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	prefix := outboxUserPrefix(userID)
+	return d.outbox.find(func(o *OutboxMessage) bool { return strings.HasPrefix(o.Payload, prefix) }), nil
+}
+
+func (r memoryOutbox) SetPayload(ctx context.Context, id uint, payload string) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.outbox.update(func(o *OutboxMessage) bool { return o.ID == id }, func(o *OutboxMessage) { o.Payload = payload })
+	return nil
+}
+
+type memoryWebhooks struct{ m *MemoryStore }
+
+func (r memoryWebhooks) CreateSubscription(ctx context.Context, subscription *WebhookSubscription) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.subscriptions.insert(ctx, subscription)
+}
+
+func (r memoryWebhooks) GetSubscription(ctx context.Context, id uint) (*WebhookSubscription, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.subscriptions.first(func(s *WebhookSubscription) bool { return s.ID == id })
+}
+
+func (r memoryWebhooks) ListSubscriptions(ctx context.Context, activeOnly bool) ([]WebhookSubscription, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.subscriptions.find(func(s *WebhookSubscription) bool { return s.Active || !activeOnly }), nil
+}
+
+func (r memoryWebhooks) DeleteSubscription(ctx context.Context, id uint) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if d.subscriptions.delete(func(s *WebhookSubscription) bool { return s.ID == id }) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r memoryWebhooks) CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.deliveries.insert(ctx, delivery)
+}
+
+func (r memoryWebhooks) ListDeliveries(ctx context.Context, subscriptionID uint, status string, limit int) ([]WebhookDelivery, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	deliveries := d.deliveries.find(func(w *WebhookDelivery) bool {
+		return (subscriptionID == 0 || w.SubscriptionID == subscriptionID) && (status == "" || w.Status == status)
+	})
+	return newestFirst(deliveries, limit), nil
+}
+
+func (r memoryWebhooks) LeaseDue(ctx context.Context, now time.Time, limit int, until time.Time) ([]WebhookDelivery, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	due := d.deliveries.find(func(w *WebhookDelivery) bool {
+		return w.Status == DeliveryPending && !w.NextAttemptAt.After(now)
+	})
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	leased := make(map[uint]bool, len(due))
+	for i := range due {
+		due[i].NextAttemptAt = until
+		leased[due[i].ID] = true
+	}
+	d.deliveries.update(func(w *WebhookDelivery) bool { return leased[w.ID] }, func(w *WebhookDelivery) { w.NextAttemptAt = until })
+	return due, nil
+}
+
+func (r memoryWebhooks) UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	d.deliveries.update(func(w *WebhookDelivery) bool { return w.ID == delivery.ID }, func(w *WebhookDelivery) {
+		w.Status = delivery.Status
+		w.Attempts = delivery.Attempts
+		w.NextAttemptAt = delivery.NextAttemptAt
+		w.DeliveredAt = delivery.DeliveredAt
+		w.UpdatedAt = time.Now()
+	})
+	return nil
+}
+
+func (r memoryWebhooks) CreateAttempt(ctx context.Context, attempt *WebhookAttempt) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.webhookAttempts.insert(ctx, attempt)
+}
+
+func (r memoryWebhooks) ListAttempts(ctx context.Context, deliveryID uint) ([]WebhookAttempt, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return d.webhookAttempts.find(func(a *WebhookAttempt) bool { return a.DeliveryID == deliveryID }), nil
+}
+
+type memoryRateLimits struct{ m *MemoryStore }
+
+func (r memoryRateLimits) Create(ctx context.Context, hit *RateLimitHit) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.rateLimitHits.insert(ctx, hit)
+}
+
+func (r memoryRateLimits) Count(ctx context.Context, bucket string, since time.Time) (int64, error) {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	hits := d.rateLimitHits.find(func(h *RateLimitHit) bool {
+		return h.Bucket == bucket && h.CreatedAt.After(since)
+	})
+	return int64(len(hits)), nil
+}
+
+func (r memoryRateLimits) DeleteBefore(ctx context.Context, before time.Time) error {
+	d, unlock, err := r.m.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	d.rateLimitHits.delete(func(h *RateLimitHit) bool { return h.CreatedAt.Before(before) })
+	return nil
+}
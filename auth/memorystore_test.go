@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// strictStore wraps a MemoryStore and fails the test when the outer store is
+// used while one of its transactions runs. MemoryStore holds its lock for the
+// whole transaction, so such a call would otherwise hang the test instead of
+// pointing at the query that ignored the Store it was given.
+type strictStore struct {
+	*MemoryStore
+	t    testing.TB
+	open *atomic.Int32 // Transactions running on the outer store
+}
+
+func newStrictStore(t testing.TB) strictStore {
+	return strictStore{MemoryStore: NewMemoryStore(), t: t, open: &atomic.Int32{}}
+}
+
+// check fails the test when a transaction is running
+func (s strictStore) check() {
+	if s.open.Load() > 0 {
+		s.t.Helper()
+		s.t.Fatal("the outer store was used inside a transaction; code inside one must use the Store it was given")
+	}
+}
+
+func (s strictStore) Users() UserRepository {
+	s.check()
+	return s.MemoryStore.Users()
+}
+
+func (s strictStore) OTPs() OTPRepository {
+	s.check()
+	return s.MemoryStore.OTPs()
+}
+
+func (s strictStore) EmailChanges() EmailChangeRepository {
+	s.check()
+	return s.MemoryStore.EmailChanges()
+}
+
+func (s strictStore) Sessions() SessionRepository {
+	s.check()
+	return s.MemoryStore.Sessions()
+}
+
+func (s strictStore) AccountDeletions() AccountDeletionRepository {
+	s.check()
+	return s.MemoryStore.AccountDeletions()
+}
+
+func (s strictStore) Impersonations() ImpersonationRepository {
+	s.check()
+	return s.MemoryStore.Impersonations()
+}
+
+func (s strictStore) KnownDevices() KnownDeviceRepository {
+	s.check()
+	return s.MemoryStore.KnownDevices()
+}
+
+func (s strictStore) LoginAttempts() LoginAttemptRepository {
+	s.check()
+	return s.MemoryStore.LoginAttempts()
+}
+
+func (s strictStore) LoginChallenges() LoginChallengeRepository {
+	s.check()
+	return s.MemoryStore.LoginChallenges()
+}
+
+func (s strictStore) AuditEvents() AuditRepository {
+	s.check()
+	return s.MemoryStore.AuditEvents()
+}
+
+func (s strictStore) Outbox() OutboxRepository {
+	s.check()
+	return s.MemoryStore.Outbox()
+}
+
+func (s strictStore) Webhooks() WebhookRepository {
+	s.check()
+	return s.MemoryStore.Webhooks()
+}
+
+func (s strictStore) RateLimits() RateLimitRepository {
+	s.check()
+	return s.MemoryStore.RateLimits()
+}
+
+func (s strictStore) Transaction(ctx context.Context, fn func(tx Store) error) error {
+	s.check()
+	s.open.Add(1)
+	defer s.open.Add(-1)
+	return s.MemoryStore.Transaction(ctx, fn)
+}
+
+func TestMemoryStoreTransactionHoldsStore(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- store.Transaction(ctx, func(tx Store) error {
+			close(started)
+			<-release
+			// The Store given to fn is usable while the transaction holds the store
+			return tx.Users().Create(ctx, &User{Username: "alice", Email: "alice@example.com"})
+		})
+	}()
+	<-started
+
+	// Everyone else waits for the transaction, which is why code inside one
+	// calling the outer store deadlocks
+	looked := make(chan bool, 1)
+	go func() {
+		exists, _ := store.Users().Exists(ctx, 0, "alice")
+		looked <- exists
+	}()
+	select {
+	case <-looked:
+		t.Fatal("the store was used while a transaction held it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if exists := <-looked; !exists {
+		t.Error("the waiting call did not see the committed user")
+	}
+}
+
+func TestMemoryStoreCancelledContext(t *testing.T) {
+	store := NewMemoryStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := store.Users().Create(ctx, &User{Username: "alice", Email: "alice@example.com"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Create: got %v, want context.Canceled", err)
+	}
+	called := false
+	err := store.Transaction(ctx, func(tx Store) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || called {
+		t.Errorf("Transaction: got %v, ran fn %v", err, called)
+	}
+}
@@ -2,38 +2,138 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/rb4807/Golang-Utlis-Postgresql/utils"
 )
 
-// AuthMiddleware is a middleware function to protect routes
+// AuthMiddleware is a middleware function to protect routes. It reads a Bearer
+// token from the Authorization header or, in cookie mode, the access token cookie.
 func (s *Service) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			utils.SendJSONError(w, "Authorization header is required", http.StatusUnauthorized)
+		token, err := s.tokenFromRequest(r)
+		if err != nil {
+			utils.SendJSONError(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			utils.SendJSONError(w, "Authorization header format must be Bearer <token>", http.StatusUnauthorized)
+		claims, err := s.ValidateAccessTokenContext(r.Context(), token)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrImpersonationEnded):
+				utils.SendJSONError(w, "Impersonation session has ended", http.StatusUnauthorized)
+			case errors.Is(err, ErrSessionNotFound):
+				utils.SendJSONError(w, "Session has been revoked or has expired", http.StatusUnauthorized)
+			case errors.Is(err, ErrInvalidToken):
+				utils.SendJSONError(w, "Invalid or expired token", http.StatusUnauthorized)
+			default:
+				utils.SendJSONError(w, "Failed to verify token", http.StatusInternalServerError)
+			}
 			return
 		}
 
-		claims, err := s.VerifyJWT(parts[1])
+		ctx := context.WithValue(r.Context(), UserContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ValidateAccessToken calls ValidateAccessTokenContext with the service's context
+func (s *Service) ValidateAccessToken(token string) (*TokenClaims, error) {
+	return s.ValidateAccessTokenContext(s.context(), token)
+}
+
+// ValidateAccessTokenContext verifies a token and checks that its impersonation or login
+// session is still active. It is the check AuthMiddleware applies, for callers
+// such as server-rendered pages that read the token themselves.
+func (s *Service) ValidateAccessTokenContext(ctx context.Context, token string) (*TokenClaims, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	claims, err := s.VerifyJWT(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.IsImpersonated() {
+		active, err := s.impersonationActive(claims.Actor.ImpersonationID)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return nil, ErrImpersonationEnded
+		}
+	}
+
+	if claims.SessionID != 0 {
+		active, err := s.sessionActive(claims)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return nil, ErrSessionNotFound
+		}
+	}
+
+	return claims, nil
+}
+
+// BlockImpersonation rejects requests made with an impersonation token. It must
+// run after AuthMiddleware and guards sensitive actions such as password changes.
+func (s *Service) BlockImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := GetUserFromContext(r.Context())
 		if err != nil {
-			utils.SendJSONError(w, "Invalid or expired token", http.StatusUnauthorized)
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), UserContextKey, claims)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		if claims.IsImpersonated() {
+			utils.SendJSONError(w, "This action is not allowed while impersonating a user", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
 	})
 }
 
+// RequireRecentAuth is a middleware generator for sensitive routes. It must run
+// after AuthMiddleware and rejects tokens whose authentication is older than
+// maxAge or did not use every one of factors, telling the client to call
+// /api/auth/reauthenticate.
+func (s *Service) RequireRecentAuth(maxAge time.Duration, factors ...string) func(http.Handler) http.Handler {
+	if factors == nil {
+		factors = []string{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := GetUserFromContext(r.Context())
+			if err != nil {
+				utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if err := CheckRecentAuth(claims, maxAge, factors...); err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"message":            "Please confirm your identity to continue",
+					"error":              "reauthentication_required",
+					"max_age_seconds":    int64(maxAge.Seconds()),
+					"factors":            factors,
+					"reauthenticate_url": "/api/auth/reauthenticate",
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // AdminMiddleware is a middleware function to protect admin routes
 func (s *Service) AdminMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
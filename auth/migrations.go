@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"embed"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/db"
+)
+
+// migrationFiles holds the SQL migrations of the auth tables, one file per
+// driver where the statements differ
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Models returns every model stored by the auth package, parents before the
+// tables that reference them. Pass the application's user model when it embeds
+// User with extra columns.
+func Models(userModel ...Authenticatable) []interface{} {
+	var user interface{} = &User{}
+	if len(userModel) > 0 && userModel[0] != nil {
+		user = userModel[0]
+	}
+
+	return []interface{}{
+		user, &OTP{}, &EmailChange{}, &AccountDeletion{}, &Session{}, &KnownDevice{}, &LoginAttempt{}, &LoginChallenge{},
+		&ImpersonationSession{}, &AuditEvent{}, &AuditChainHead{}, &OutboxMessage{}, &WebhookSubscription{}, &WebhookDelivery{}, &WebhookAttempt{},
+		&RateLimitHit{},
+	}
+}
+
+// Migrations returns the versioned schema changes of the auth tables for a
+// driver, to run with db.NewMigrator alongside the application's own
+// migrations.
+//
+// Schema changes are plain SQL in the migrations directory so they are
+// reviewed as written and checksummed once applied; they never depend on how
+// the models look when they run. The first one creates the tables as they
+// stood when versioned migrations were introduced, adopting databases that
+// AutoMigrate set up before then. Go migrations are kept for data backfills.
+//
+// Columns an application adds to its user model belong in the application's
+// own migrations, which cmd/migrate diff can draft.
+func Migrations(driver string) ([]db.Migration, error) {
+	return db.LoadSQLMigrations(migrationFiles, "migrations", driver)
+}
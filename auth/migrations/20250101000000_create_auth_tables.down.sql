@@ -0,0 +1,14 @@
+DROP TABLE webhook_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_subscriptions;
+DROP TABLE outbox_messages;
+DROP TABLE audit_events;
+DROP TABLE impersonation_sessions;
+DROP TABLE login_challenges;
+DROP TABLE login_attempts;
+DROP TABLE known_devices;
+DROP TABLE sessions;
+DROP TABLE account_deletions;
+DROP TABLE email_changes;
+DROP TABLE otps;
+DROP TABLE users;
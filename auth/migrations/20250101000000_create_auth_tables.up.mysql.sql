@@ -0,0 +1,205 @@
+-- The auth tables as they stood when versioned migrations were introduced.
+-- This file is frozen: change the schema in a new migration instead.
+-- IF NOT EXISTS adopts databases that AutoMigrate created beforehand.
+
+CREATE TABLE IF NOT EXISTS `users` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `username` varchar(50),
+    `email` varchar(100),
+    `password` varchar(255),
+    `first_name` varchar(50),
+    `last_name` varchar(50),
+    `is_active` boolean DEFAULT true,
+    `is_superuser` boolean DEFAULT false,
+    `date_joined` datetime(3) NULL,
+    `last_login` datetime(3) NULL,
+    `password_changed` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_users_username` (`username`),
+    UNIQUE INDEX `idx_users_email` (`email`)
+);
+
+CREATE TABLE IF NOT EXISTS `otps` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `otp_value` varchar(10),
+    `expires_at` datetime(3) NULL,
+    `verified` boolean DEFAULT false,
+    PRIMARY KEY (`id`),
+    INDEX `idx_otps_user_id` (`user_id`),
+    CONSTRAINT `fk_otps_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `email_changes` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `new_email` varchar(100),
+    `token_hash` varchar(64),
+    `expires_at` datetime(3) NULL,
+    `created_at` datetime(3) NULL,
+    `confirmed_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_email_changes_user_id` (`user_id`),
+    INDEX `idx_email_changes_token_hash` (`token_hash`),
+    CONSTRAINT `fk_email_changes_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `account_deletions` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `requested_at` datetime(3) NULL,
+    `scheduled_for` datetime(3) NULL,
+    `cancelled_at` datetime(3) NULL,
+    `completed_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_account_deletions_user_id` (`user_id`),
+    INDEX `idx_account_deletions_scheduled_for` (`scheduled_for`)
+);
+
+CREATE TABLE IF NOT EXISTS `sessions` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `device_name` varchar(100),
+    `user_agent` varchar(255),
+    `ip` varchar(45),
+    `location` varchar(255),
+    `amr` varchar(64),
+    `created_at` datetime(3) NULL,
+    `last_seen_at` datetime(3) NULL,
+    `expires_at` datetime(3) NULL,
+    `revoked_at` datetime(3) NULL,
+    `refresh_token_hash` varchar(64),
+    PRIMARY KEY (`id`),
+    INDEX `idx_sessions_user_id` (`user_id`),
+    INDEX `idx_sessions_expires_at` (`expires_at`),
+    INDEX `idx_sessions_refresh_token_hash` (`refresh_token_hash`),
+    CONSTRAINT `fk_sessions_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `known_devices` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `fingerprint` varchar(64),
+    `device_name` varchar(100),
+    `last_ip` varchar(45),
+    `last_location` varchar(255),
+    `first_seen_at` datetime(3) NULL,
+    `last_seen_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_known_devices_user_fingerprint` (`user_id`,`fingerprint`),
+    CONSTRAINT `fk_known_devices_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `login_attempts` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `ip` varchar(45),
+    `fingerprint` varchar(64),
+    `succeeded` boolean,
+    `reason` varchar(32),
+    `location` varchar(255),
+    `latitude` double,
+    `longitude` double,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_login_attempts_user_id` (`user_id`),
+    INDEX `idx_login_attempts_ip` (`ip`),
+    INDEX `idx_login_attempts_created_at` (`created_at`)
+);
+
+CREATE TABLE IF NOT EXISTS `login_challenges` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `token_hash` varchar(64),
+    `signals` varchar(255),
+    `attempts` bigint NOT NULL DEFAULT 0,
+    `expires_at` datetime(3) NULL,
+    `created_at` datetime(3) NULL,
+    `completed_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_login_challenges_user_id` (`user_id`),
+    UNIQUE INDEX `idx_login_challenges_token_hash` (`token_hash`),
+    INDEX `idx_login_challenges_expires_at` (`expires_at`),
+    CONSTRAINT `fk_login_challenges_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `impersonation_sessions` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `impersonator_id` bigint unsigned,
+    `target_id` bigint unsigned,
+    `started_at` datetime(3) NULL,
+    `expires_at` datetime(3) NULL,
+    `ended_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_impersonation_sessions_impersonator_id` (`impersonator_id`),
+    INDEX `idx_impersonation_sessions_target_id` (`target_id`)
+);
+
+CREATE TABLE IF NOT EXISTS `audit_events` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `actor_id` bigint unsigned,
+    `target_id` bigint unsigned,
+    `action` varchar(64),
+    `ip` varchar(64),
+    `user_agent` varchar(255),
+    `metadata` text,
+    `created_at` datetime(3) NULL,
+    `prev_hash` varchar(64),
+    `hash` varchar(64),
+    PRIMARY KEY (`id`),
+    INDEX `idx_audit_events_actor_id` (`actor_id`),
+    INDEX `idx_audit_events_target_id` (`target_id`),
+    INDEX `idx_audit_events_action` (`action`),
+    INDEX `idx_audit_events_created_at` (`created_at`)
+);
+
+CREATE TABLE IF NOT EXISTS `outbox_messages` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `event_type` varchar(64),
+    `payload` text,
+    `created_at` datetime(3) NULL,
+    `processed_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_outbox_messages_event_type` (`event_type`),
+    INDEX `idx_outbox_messages_processed_at` (`processed_at`)
+);
+
+CREATE TABLE IF NOT EXISTS `webhook_subscriptions` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `url` varchar(2048),
+    `secret` varchar(128),
+    `event_types` varchar(512),
+    `active` boolean DEFAULT true,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `webhook_deliveries` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `subscription_id` bigint unsigned,
+    `outbox_id` bigint unsigned,
+    `event_type` varchar(64),
+    `status` varchar(16),
+    `attempts` bigint,
+    `next_attempt_at` datetime(3) NULL,
+    `delivered_at` datetime(3) NULL,
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_webhook_deliveries_subscription_id` (`subscription_id`),
+    INDEX `idx_webhook_deliveries_outbox_id` (`outbox_id`),
+    INDEX `idx_webhook_deliveries_status` (`status`),
+    INDEX `idx_webhook_deliveries_next_attempt_at` (`next_attempt_at`)
+);
+
+CREATE TABLE IF NOT EXISTS `webhook_attempts` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `delivery_id` bigint unsigned,
+    `attempt` bigint,
+    `status_code` bigint,
+    `error` varchar(1024),
+    `duration_ms` bigint,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_webhook_attempts_delivery_id` (`delivery_id`)
+);
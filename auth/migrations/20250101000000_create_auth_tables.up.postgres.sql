@@ -0,0 +1,205 @@
+-- The auth tables as they stood when versioned migrations were introduced.
+-- This file is frozen: change the schema in a new migration instead.
+-- IF NOT EXISTS adopts databases that AutoMigrate created beforehand.
+
+CREATE TABLE IF NOT EXISTS "users" (
+    "id" bigserial,
+    "username" varchar(50),
+    "email" varchar(100),
+    "password" varchar(255),
+    "first_name" varchar(50),
+    "last_name" varchar(50),
+    "is_active" boolean DEFAULT true,
+    "is_superuser" boolean DEFAULT false,
+    "date_joined" timestamptz,
+    "last_login" timestamptz,
+    "password_changed" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_email" ON "users" ("email");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_username" ON "users" ("username");
+
+CREATE TABLE IF NOT EXISTS "otps" (
+    "id" bigserial,
+    "user_id" bigint,
+    "otp_value" varchar(10),
+    "expires_at" timestamptz,
+    "verified" boolean DEFAULT false,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_otps_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_otps_user_id" ON "otps" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "email_changes" (
+    "id" bigserial,
+    "user_id" bigint,
+    "new_email" varchar(100),
+    "token_hash" varchar(64),
+    "expires_at" timestamptz,
+    "created_at" timestamptz,
+    "confirmed_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_email_changes_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_email_changes_token_hash" ON "email_changes" ("token_hash");
+CREATE INDEX IF NOT EXISTS "idx_email_changes_user_id" ON "email_changes" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "account_deletions" (
+    "id" bigserial,
+    "user_id" bigint,
+    "requested_at" timestamptz,
+    "scheduled_for" timestamptz,
+    "cancelled_at" timestamptz,
+    "completed_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_account_deletions_scheduled_for" ON "account_deletions" ("scheduled_for");
+CREATE INDEX IF NOT EXISTS "idx_account_deletions_user_id" ON "account_deletions" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "sessions" (
+    "id" bigserial,
+    "user_id" bigint,
+    "device_name" varchar(100),
+    "user_agent" varchar(255),
+    "ip" varchar(45),
+    "location" varchar(255),
+    "amr" varchar(64),
+    "created_at" timestamptz,
+    "last_seen_at" timestamptz,
+    "expires_at" timestamptz,
+    "revoked_at" timestamptz,
+    "refresh_token_hash" varchar(64),
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_sessions_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_sessions_expires_at" ON "sessions" ("expires_at");
+CREATE INDEX IF NOT EXISTS "idx_sessions_refresh_token_hash" ON "sessions" ("refresh_token_hash");
+CREATE INDEX IF NOT EXISTS "idx_sessions_user_id" ON "sessions" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "known_devices" (
+    "id" bigserial,
+    "user_id" bigint,
+    "fingerprint" varchar(64),
+    "device_name" varchar(100),
+    "last_ip" varchar(45),
+    "last_location" varchar(255),
+    "first_seen_at" timestamptz,
+    "last_seen_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_known_devices_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_known_devices_user_fingerprint" ON "known_devices" ("user_id","fingerprint");
+
+CREATE TABLE IF NOT EXISTS "login_attempts" (
+    "id" bigserial,
+    "user_id" bigint,
+    "ip" varchar(45),
+    "fingerprint" varchar(64),
+    "succeeded" boolean,
+    "reason" varchar(32),
+    "location" varchar(255),
+    "latitude" decimal,
+    "longitude" decimal,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_login_attempts_created_at" ON "login_attempts" ("created_at");
+CREATE INDEX IF NOT EXISTS "idx_login_attempts_ip" ON "login_attempts" ("ip");
+CREATE INDEX IF NOT EXISTS "idx_login_attempts_user_id" ON "login_attempts" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "login_challenges" (
+    "id" bigserial,
+    "user_id" bigint,
+    "token_hash" varchar(64),
+    "signals" varchar(255),
+    "attempts" bigint NOT NULL DEFAULT 0,
+    "expires_at" timestamptz,
+    "created_at" timestamptz,
+    "completed_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_login_challenges_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_login_challenges_expires_at" ON "login_challenges" ("expires_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_login_challenges_token_hash" ON "login_challenges" ("token_hash");
+CREATE INDEX IF NOT EXISTS "idx_login_challenges_user_id" ON "login_challenges" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "impersonation_sessions" (
+    "id" bigserial,
+    "impersonator_id" bigint,
+    "target_id" bigint,
+    "started_at" timestamptz,
+    "expires_at" timestamptz,
+    "ended_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_impersonation_sessions_impersonator_id" ON "impersonation_sessions" ("impersonator_id");
+CREATE INDEX IF NOT EXISTS "idx_impersonation_sessions_target_id" ON "impersonation_sessions" ("target_id");
+
+CREATE TABLE IF NOT EXISTS "audit_events" (
+    "id" bigserial,
+    "actor_id" bigint,
+    "target_id" bigint,
+    "action" varchar(64),
+    "ip" varchar(64),
+    "user_agent" varchar(255),
+    "metadata" text,
+    "created_at" timestamptz,
+    "prev_hash" varchar(64),
+    "hash" varchar(64),
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_audit_events_action" ON "audit_events" ("action");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_actor_id" ON "audit_events" ("actor_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_created_at" ON "audit_events" ("created_at");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_target_id" ON "audit_events" ("target_id");
+
+CREATE TABLE IF NOT EXISTS "outbox_messages" (
+    "id" bigserial,
+    "event_type" varchar(64),
+    "payload" text,
+    "created_at" timestamptz,
+    "processed_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_outbox_messages_event_type" ON "outbox_messages" ("event_type");
+CREATE INDEX IF NOT EXISTS "idx_outbox_messages_processed_at" ON "outbox_messages" ("processed_at");
+
+CREATE TABLE IF NOT EXISTS "webhook_subscriptions" (
+    "id" bigserial,
+    "url" varchar(2048),
+    "secret" varchar(128),
+    "event_types" varchar(512),
+    "active" boolean DEFAULT true,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "webhook_deliveries" (
+    "id" bigserial,
+    "subscription_id" bigint,
+    "outbox_id" bigint,
+    "event_type" varchar(64),
+    "status" varchar(16),
+    "attempts" bigint,
+    "next_attempt_at" timestamptz,
+    "delivered_at" timestamptz,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_next_attempt_at" ON "webhook_deliveries" ("next_attempt_at");
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_outbox_id" ON "webhook_deliveries" ("outbox_id");
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_status" ON "webhook_deliveries" ("status");
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_subscription_id" ON "webhook_deliveries" ("subscription_id");
+
+CREATE TABLE IF NOT EXISTS "webhook_attempts" (
+    "id" bigserial,
+    "delivery_id" bigint,
+    "attempt" bigint,
+    "status_code" bigint,
+    "error" varchar(1024),
+    "duration_ms" bigint,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_webhook_attempts_delivery_id" ON "webhook_attempts" ("delivery_id");
@@ -0,0 +1,191 @@
+-- The auth tables as they stood when versioned migrations were introduced.
+-- This file is frozen: change the schema in a new migration instead.
+-- IF NOT EXISTS adopts databases that AutoMigrate created beforehand.
+
+CREATE TABLE IF NOT EXISTS `users` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `username` text,
+    `email` text,
+    `password` text,
+    `first_name` text,
+    `last_name` text,
+    `is_active` numeric DEFAULT true,
+    `is_superuser` numeric DEFAULT false,
+    `date_joined` datetime,
+    `last_login` datetime,
+    `password_changed` datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_users_email` ON `users` (`email`);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_users_username` ON `users` (`username`);
+
+CREATE TABLE IF NOT EXISTS `otps` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `otp_value` text,
+    `expires_at` datetime,
+    `verified` numeric DEFAULT false,
+    CONSTRAINT `fk_otps_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS `idx_otps_user_id` ON `otps` (`user_id`);
+
+CREATE TABLE IF NOT EXISTS `email_changes` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `new_email` text,
+    `token_hash` text,
+    `expires_at` datetime,
+    `created_at` datetime,
+    `confirmed_at` datetime,
+    CONSTRAINT `fk_email_changes_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS `idx_email_changes_token_hash` ON `email_changes` (`token_hash`);
+CREATE INDEX IF NOT EXISTS `idx_email_changes_user_id` ON `email_changes` (`user_id`);
+
+CREATE TABLE IF NOT EXISTS `account_deletions` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `requested_at` datetime,
+    `scheduled_for` datetime,
+    `cancelled_at` datetime,
+    `completed_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_account_deletions_scheduled_for` ON `account_deletions` (`scheduled_for`);
+CREATE INDEX IF NOT EXISTS `idx_account_deletions_user_id` ON `account_deletions` (`user_id`);
+
+CREATE TABLE IF NOT EXISTS `sessions` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `device_name` text,
+    `user_agent` text,
+    `ip` text,
+    `location` text,
+    `amr` text,
+    `created_at` datetime,
+    `last_seen_at` datetime,
+    `expires_at` datetime,
+    `revoked_at` datetime,
+    `refresh_token_hash` text,
+    CONSTRAINT `fk_sessions_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS `idx_sessions_expires_at` ON `sessions` (`expires_at`);
+CREATE INDEX IF NOT EXISTS `idx_sessions_refresh_token_hash` ON `sessions` (`refresh_token_hash`);
+CREATE INDEX IF NOT EXISTS `idx_sessions_user_id` ON `sessions` (`user_id`);
+
+CREATE TABLE IF NOT EXISTS `known_devices` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `fingerprint` text,
+    `device_name` text,
+    `last_ip` text,
+    `last_location` text,
+    `first_seen_at` datetime,
+    `last_seen_at` datetime,
+    CONSTRAINT `fk_known_devices_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_known_devices_user_fingerprint` ON `known_devices` (`user_id`,`fingerprint`);
+
+CREATE TABLE IF NOT EXISTS `login_attempts` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `ip` text,
+    `fingerprint` text,
+    `succeeded` numeric,
+    `reason` text,
+    `location` text,
+    `latitude` real,
+    `longitude` real,
+    `created_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_login_attempts_created_at` ON `login_attempts` (`created_at`);
+CREATE INDEX IF NOT EXISTS `idx_login_attempts_ip` ON `login_attempts` (`ip`);
+CREATE INDEX IF NOT EXISTS `idx_login_attempts_user_id` ON `login_attempts` (`user_id`);
+
+CREATE TABLE IF NOT EXISTS `login_challenges` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `token_hash` text,
+    `signals` text,
+    `attempts` integer NOT NULL DEFAULT 0,
+    `expires_at` datetime,
+    `created_at` datetime,
+    `completed_at` datetime,
+    CONSTRAINT `fk_login_challenges_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS `idx_login_challenges_expires_at` ON `login_challenges` (`expires_at`);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_login_challenges_token_hash` ON `login_challenges` (`token_hash`);
+CREATE INDEX IF NOT EXISTS `idx_login_challenges_user_id` ON `login_challenges` (`user_id`);
+
+CREATE TABLE IF NOT EXISTS `impersonation_sessions` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `impersonator_id` integer,
+    `target_id` integer,
+    `started_at` datetime,
+    `expires_at` datetime,
+    `ended_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_impersonation_sessions_impersonator_id` ON `impersonation_sessions` (`impersonator_id`);
+CREATE INDEX IF NOT EXISTS `idx_impersonation_sessions_target_id` ON `impersonation_sessions` (`target_id`);
+
+CREATE TABLE IF NOT EXISTS `audit_events` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `actor_id` integer,
+    `target_id` integer,
+    `action` text,
+    `ip` text,
+    `user_agent` text,
+    `metadata` text,
+    `created_at` datetime,
+    `prev_hash` text,
+    `hash` text
+);
+CREATE INDEX IF NOT EXISTS `idx_audit_events_action` ON `audit_events` (`action`);
+CREATE INDEX IF NOT EXISTS `idx_audit_events_actor_id` ON `audit_events` (`actor_id`);
+CREATE INDEX IF NOT EXISTS `idx_audit_events_created_at` ON `audit_events` (`created_at`);
+CREATE INDEX IF NOT EXISTS `idx_audit_events_target_id` ON `audit_events` (`target_id`);
+
+CREATE TABLE IF NOT EXISTS `outbox_messages` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `event_type` text,
+    `payload` text,
+    `created_at` datetime,
+    `processed_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_outbox_messages_event_type` ON `outbox_messages` (`event_type`);
+CREATE INDEX IF NOT EXISTS `idx_outbox_messages_processed_at` ON `outbox_messages` (`processed_at`);
+
+CREATE TABLE IF NOT EXISTS `webhook_subscriptions` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `url` text,
+    `secret` text,
+    `event_types` text,
+    `active` numeric DEFAULT true,
+    `created_at` datetime
+);
+
+CREATE TABLE IF NOT EXISTS `webhook_deliveries` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `subscription_id` integer,
+    `outbox_id` integer,
+    `event_type` text,
+    `status` text,
+    `attempts` integer,
+    `next_attempt_at` datetime,
+    `delivered_at` datetime,
+    `created_at` datetime,
+    `updated_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_webhook_deliveries_next_attempt_at` ON `webhook_deliveries` (`next_attempt_at`);
+CREATE INDEX IF NOT EXISTS `idx_webhook_deliveries_outbox_id` ON `webhook_deliveries` (`outbox_id`);
+CREATE INDEX IF NOT EXISTS `idx_webhook_deliveries_status` ON `webhook_deliveries` (`status`);
+CREATE INDEX IF NOT EXISTS `idx_webhook_deliveries_subscription_id` ON `webhook_deliveries` (`subscription_id`);
+
+CREATE TABLE IF NOT EXISTS `webhook_attempts` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `delivery_id` integer,
+    `attempt` integer,
+    `status_code` integer,
+    `error` text,
+    `duration_ms` integer,
+    `created_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_webhook_attempts_delivery_id` ON `webhook_attempts` (`delivery_id`);
@@ -0,0 +1,8 @@
+-- Fails while a deleted user shares a username or email with another user
+DROP INDEX `idx_users_username` ON `users`;
+CREATE UNIQUE INDEX `idx_users_username` ON `users` (`username`);
+DROP INDEX `idx_users_email` ON `users`;
+CREATE UNIQUE INDEX `idx_users_email` ON `users` (`email`);
+
+DROP INDEX `idx_users_deleted_at` ON `users`;
+ALTER TABLE `users` DROP COLUMN `deleted_at`;
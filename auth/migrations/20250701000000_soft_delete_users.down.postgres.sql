@@ -0,0 +1,7 @@
+-- Fails while a deleted user shares a username or email with another user
+DROP INDEX IF EXISTS "idx_users_username";
+CREATE UNIQUE INDEX "idx_users_username" ON "users" ("username");
+DROP INDEX IF EXISTS "idx_users_email";
+CREATE UNIQUE INDEX "idx_users_email" ON "users" ("email");
+
+ALTER TABLE "users" DROP COLUMN "deleted_at";
@@ -0,0 +1,11 @@
+-- Deleted users keep their row until purged, so usernames and emails only
+-- need to be unique among the users that have not been deleted. MySQL has no
+-- partial indexes: the indexes cover an expression that is NULL for deleted
+-- users, which unique indexes ignore. This needs MySQL 8.0.13 or later.
+ALTER TABLE `users` ADD `deleted_at` datetime(3) NULL;
+CREATE INDEX `idx_users_deleted_at` ON `users` (`deleted_at`);
+
+DROP INDEX `idx_users_username` ON `users`;
+CREATE UNIQUE INDEX `idx_users_username` ON `users` (`username`, (IF(`deleted_at` IS NULL, 1, NULL)));
+DROP INDEX `idx_users_email` ON `users`;
+CREATE UNIQUE INDEX `idx_users_email` ON `users` (`email`, (IF(`deleted_at` IS NULL, 1, NULL)));
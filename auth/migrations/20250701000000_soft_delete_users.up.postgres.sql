@@ -0,0 +1,9 @@
+-- Deleted users keep their row until purged, so usernames and emails only
+-- need to be unique among the users that have not been deleted
+ALTER TABLE "users" ADD COLUMN "deleted_at" timestamptz;
+CREATE INDEX IF NOT EXISTS "idx_users_deleted_at" ON "users" ("deleted_at");
+
+DROP INDEX IF EXISTS "idx_users_username";
+CREATE UNIQUE INDEX "idx_users_username" ON "users" ("username") WHERE "deleted_at" IS NULL;
+DROP INDEX IF EXISTS "idx_users_email";
+CREATE UNIQUE INDEX "idx_users_email" ON "users" ("email") WHERE "deleted_at" IS NULL;
@@ -0,0 +1 @@
+DROP TABLE audit_chain_heads;
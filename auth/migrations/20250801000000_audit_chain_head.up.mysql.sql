@@ -0,0 +1,11 @@
+-- The single row holding the hash of the newest chained audit event. Writers
+-- lock it while appending, so the events of every server instance link into
+-- one chain; it starts from the newest event already chained.
+CREATE TABLE `audit_chain_heads` (
+    `id` bigint unsigned,
+    `hash` varchar(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`)
+);
+
+INSERT INTO `audit_chain_heads` (id, hash)
+SELECT 1, COALESCE((SELECT hash FROM audit_events WHERE hash <> '' ORDER BY id DESC LIMIT 1), '');
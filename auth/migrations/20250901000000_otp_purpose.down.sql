@@ -0,0 +1,2 @@
+ALTER TABLE otps DROP COLUMN attempts;
+ALTER TABLE otps DROP COLUMN purpose;
@@ -0,0 +1,4 @@
+-- A code only completes the flow it was sent for, and stops working after a
+-- few wrong guesses. Codes issued before this have no purpose and lapse.
+ALTER TABLE `otps` ADD `purpose` text NOT NULL DEFAULT '';
+ALTER TABLE `otps` ADD `attempts` integer NOT NULL DEFAULT 0;
@@ -0,0 +1,9 @@
+-- Requests counted towards rate limits, such as those on password resets
+CREATE TABLE `rate_limit_hits` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `bucket` varchar(128) NOT NULL,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_rate_limit_hits_bucket_created_at` (`bucket`,`created_at`),
+    INDEX `idx_rate_limit_hits_created_at` (`created_at`)
+);
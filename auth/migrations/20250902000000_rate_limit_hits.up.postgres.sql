@@ -0,0 +1,9 @@
+-- Requests counted towards rate limits, such as those on password resets
+CREATE TABLE "rate_limit_hits" (
+    "id" bigserial,
+    "bucket" varchar(128) NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_rate_limit_hits_bucket_created_at" ON "rate_limit_hits" ("bucket","created_at");
+CREATE INDEX IF NOT EXISTS "idx_rate_limit_hits_created_at" ON "rate_limit_hits" ("created_at");
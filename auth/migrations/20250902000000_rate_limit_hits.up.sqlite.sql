@@ -0,0 +1,8 @@
+-- Requests counted towards rate limits, such as those on password resets
+CREATE TABLE `rate_limit_hits` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `bucket` text NOT NULL,
+    `created_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_rate_limit_hits_bucket_created_at` ON `rate_limit_hits` (`bucket`,`created_at`);
+CREATE INDEX IF NOT EXISTS `idx_rate_limit_hits_created_at` ON `rate_limit_hits` (`created_at`);
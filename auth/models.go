@@ -1,25 +1,29 @@
 package auth
 
 import (
+	"context"
 	"errors"
+	"net/http"
 	"time"
 	
+	"github.com/rb4807/Golang-Utlis-Postgresql/db"
 	"gorm.io/gorm"
 )
 
 // User represents a user in the system
 type User struct {
-	ID              uint      `gorm:"primaryKey" json:"id"`
-	Username        string    `gorm:"size:50;uniqueIndex" json:"username" validate:"required,min=3,max=50"`
-	Email           string    `gorm:"size:100;uniqueIndex" json:"email" validate:"required,email"`
-	Password        string    `gorm:"size:255" json:"-"` // Hashed password, never expose in JSON
-	FirstName       string    `gorm:"size:50" json:"first_name"`
-	LastName        string    `gorm:"size:50" json:"last_name"`
-	IsActive        bool      `gorm:"default:true" json:"is_active"`
-	IsSuperuser     bool      `gorm:"default:false" json:"is_superuser"`
-	DateJoined      time.Time `gorm:"autoCreateTime" json:"date_joined"`
-	LastLogin       *time.Time `json:"last_login"`
-	PasswordChanged *time.Time `json:"password_changed"`
+	ID              uint           `gorm:"primaryKey" json:"id"`
+	Username        string         `gorm:"size:50" json:"username" validate:"required,min=3,max=50"` // Unique among undeleted users, see Migrations
+	Email           string         `gorm:"size:100" json:"email" validate:"required,email"`          // Unique among undeleted users, see Migrations
+	Password        string         `gorm:"size:255" json:"-"`                                        // Hashed password, never expose in JSON
+	FirstName       string         `gorm:"size:50" json:"first_name"`
+	LastName        string         `gorm:"size:50" json:"last_name"`
+	IsActive        bool           `gorm:"default:true" json:"is_active"`
+	IsSuperuser     bool           `gorm:"default:false" json:"is_superuser"`
+	DateJoined      time.Time      `gorm:"autoCreateTime" json:"date_joined"`
+	LastLogin       *time.Time     `json:"last_login"`
+	PasswordChanged *time.Time     `json:"password_changed"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"deleted_at"` // Set by DeleteUser, hiding the user from every lookup
 }
 
 // OTP stores one-time password information
@@ -28,66 +32,232 @@ type OTP struct {
 	UserID    uint      `gorm:"index"`
 	User      User      `gorm:"constraint:OnDelete:CASCADE;"`
 	OTPValue  string    `gorm:"size:10;column:otp_value" json:"otp_value"`
+	Purpose   string    `gorm:"size:32;not null;default:''" json:"purpose"` // The flow the code completes, one of the OTPPurpose constants
+	Attempts  int       `gorm:"not null;default:0" json:"attempts"`         // Codes checked against it so far
 	ExpiresAt time.Time `json:"expires_at"`
 	Verified  bool      `gorm:"default:false" json:"verified"`
 }
 
+// EmailChange stores a pending email address change awaiting verification
+type EmailChange struct {
+	ID          uint       `gorm:"primaryKey"`
+	UserID      uint       `gorm:"index"`
+	User        User       `gorm:"constraint:OnDelete:CASCADE;"`
+	NewEmail    string     `gorm:"size:100" json:"new_email"`
+	TokenHash   string     `gorm:"size:64;index" json:"-"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+}
+
 // Config holds the configuration for the authentication package
 type Config struct {
-	JWTSecret     string
-	TokenDuration time.Duration
-	DB            *gorm.DB
+	JWTSecret      string
+	TokenDuration  time.Duration
+	DB             *gorm.DB
+	Store          Store         // Where records are kept, defaults to NewGormStore(DB)
+	Notifier       Notifier      // Defaults to LogNotifier
+	EmailChangeTTL time.Duration // How long an email change token stays valid, defaults to 24 hours
+
+	DeletionGracePeriod  time.Duration // Delay before a requested account deletion is carried out, defaults to 30 days
+	ErasureMode          ErasureMode   // How deleted accounts are erased, defaults to ErasureAnonymize
+	DeletedUserRetention time.Duration // How long users deleted by DeleteUser can be restored before PurgeDeletedUsers removes them, defaults to 30 days
+
+	ImpersonationDuration time.Duration // Lifetime of impersonation tokens, defaults to 15 minutes
+	ElevatedTokenDuration time.Duration // Lifetime of tokens issued by Reauthenticate, defaults to 15 minutes
+
+	CookieMode           bool          // Deliver tokens in HttpOnly cookies for browser apps, see CSRFMiddleware
+	CookieDomain         string        // Domain attribute of auth cookies, defaults to the request host
+	CookieSameSite       http.SameSite // SameSite attribute of auth cookies, defaults to Lax
+	CookieInsecure       bool          // Drop the Secure attribute; only for local development over plain HTTP
+	RefreshTokenDuration time.Duration // Lifetime of refresh tokens in cookie mode, defaults to 30 days
+	RefreshCookiePath    string        // Path attribute of the refresh token cookie, defaults to /api/auth; use / when pages renew sessions too
+
+	AuditHashChain    bool // Link audit events into a tamper-evident hash chain
+	TrustProxyHeaders bool // Take the client IP from X-Forwarded-For / X-Real-IP
+	TrustedProxyHops  int  // Trusted proxies appending to X-Forwarded-For, defaults to 1 when TrustProxyHeaders is set
+
+	EventBus *EventBus // Receives user lifecycle events, defaults to a new empty bus
+	Hooks    *Hooks    // Custom logic run around Service operations, defaults to no hooks
+
+	UserModel Authenticatable // Application user type embedding User, defaults to User
+
+	GeoIPPath string // CSV file of IP ranges used to estimate login locations, see LoadGeoIPDatabase
+
+	LoginRisk RiskPolicy // New-device, impossible travel and failure burst detection
+
+	PasswordResetEmailLimit RateLimit // Password reset requests and attempts allowed per email address, defaults to 5 an hour
+	PasswordResetIPLimit    RateLimit // Password reset requests and attempts allowed per client IP, defaults to 20 an hour
+
+	QueryTimeout time.Duration // Limit on the database work of each Service call, none by default
 }
 
 // Service provides authentication functionality
 type Service struct {
 	config    Config
-	validator interface{} // This will be a *validator.Validate
+	validator interface{}     // This will be a *validator.Validate
+	request   RequestInfo     // Set by ForRequest
+	ctx       context.Context // Set by WithContext and ForRequest
+	work      *unitOfWork     // Set inside atomically
+	geoIP     *GeoIPDatabase
 }
 
 // Common errors
 var (
-	ErrInvalidCredentials = errors.New("invalid username or password")
-	ErrUserNotFound       = errors.New("user not found")
-	ErrInvalidPassword    = errors.New("current password is incorrect")
-	ErrUserNotInContext   = errors.New("user not found in context")
-	ErrConfigInvalid      = errors.New("configuration is invalid")
-	ErrEmailExists        = errors.New("email already exists")
-	ErrUsernameExists     = errors.New("username already exists")
+	ErrInvalidCredentials       = errors.New("invalid username or password")
+	ErrUserNotFound             = errors.New("user not found")
+	ErrInvalidPassword          = errors.New("current password is incorrect")
+	ErrUserNotInContext         = errors.New("user not found in context")
+	ErrConfigInvalid            = errors.New("configuration is invalid")
+	ErrEmailExists              = errors.New("email already exists")
+	ErrUsernameExists           = errors.New("username already exists")
+	ErrUserInactive             = errors.New("user account is inactive")
+	ErrInvalidCursor            = errors.New("invalid pagination cursor")
+	ErrInvalidSortField         = errors.New("invalid sort field")
+	ErrInvalidToken             = errors.New("invalid or expired token")
+	ErrInvalidEmail             = errors.New("invalid email address")
+	ErrNoPendingDeletion        = errors.New("no pending account deletion")
+	ErrNotAuthorized            = errors.New("not authorized")
+	ErrCannotImpersonate        = errors.New("this user cannot be impersonated")
+	ErrNotImpersonating         = errors.New("token is not an impersonation token")
+	ErrImpersonationForbidden   = errors.New("action not allowed while impersonating")
+	ErrAuditChainBroken         = errors.New("audit hash chain is broken")
+	ErrInvalidWebhookURL        = errors.New("webhook URL must be an absolute http or https URL")
+	ErrWebhookNotFound          = errors.New("webhook subscription not found")
+	ErrUnknownEventType         = errors.New("unknown event type")
+	ErrSessionNotFound          = errors.New("session not found")
+	ErrLoginBlocked             = errors.New("login blocked as suspicious")
+	ErrStepUpRequired           = errors.New("additional verification required")
+	ErrInvalidChallenge         = errors.New("invalid or expired verification code")
+	ErrReauthenticationRequired = errors.New("recent authentication required")
+	ErrImpersonationEnded       = errors.New("impersonation session has ended")
+	ErrTooManyRequests          = errors.New("too many requests, try again later")
 )
 
-// Initialize database tables
-func InitDB(db *gorm.DB) error {
-	// Auto migrate will create or modify tables based on struct definitions
-	return db.AutoMigrate(&User{}, &OTP{})
+// Initialize database tables by applying the pending auth migrations
+func InitDB(database *gorm.DB) error {
+	migrations, err := Migrations(database.Dialector.Name())
+	if err != nil {
+		return err
+	}
+	migrator, err := db.NewMigrator(database, migrations, db.MigratorOptions{})
+	if err != nil {
+		return err
+	}
+
+	_, err = migrator.Up()
+	return err
 }
 
-// GetUserByID retrieves a user by ID
+// GetUserByID calls GetUserByIDContext with the service's context
 func (s *Service) GetUserByID(userID uint) (*User, error) {
+	return s.GetUserByIDContext(s.context(), userID)
+}
+
+// GetUserByIDContext retrieves a user by ID
+func (s *Service) GetUserByIDContext(ctx context.Context, userID uint) (*User, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
 	var user User
-	result := s.config.DB.First(&user, userID)
-	
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	if err := s.store().Users().Get(s.context(), userID, &user); err != nil {
+		if errors.Is(err, ErrNotFound) {
 			return nil, ErrUserNotFound
 		}
-		return nil, result.Error
+		return nil, err
 	}
 	
 	return &user, nil
 }
 
-// UpdateUser updates user information
-func (s *Service) UpdateUser(user *User) error {
-	// Only update specific fields, not the entire record
-	result := s.config.DB.Model(user).Updates(map[string]interface{}{
-		"username":     user.Username,
-		"email":        user.Email,
-		"first_name":   user.FirstName,
-		"last_name":    user.LastName,
+// lockUser locks a user's row until the transaction ends, so operations that
+// read and then change the user's records take turns
+func (s *Service) lockUser(tx Store, userID uint) (*User, error) {
+	user, err := tx.Users().Lock(s.context(), userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// UpdateUser calls UpdateUserContext with the service's context
+func (s *Service) UpdateUser(model Authenticatable) error {
+	return s.UpdateUserContext(s.context(), model)
+}
+
+// UpdateUserContext updates user information, including the extra columns of an
+// application-defined user model
+func (s *Service) UpdateUserContext(ctx context.Context, model Authenticatable) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	user := model.AuthUser()
+
+	event := UserUpdated{
+		UserID:      user.ID,
+		Username:    user.Username,
+		Email:       user.Email,
+		IsActive:    user.IsActive,
+		IsSuperuser: user.IsSuperuser,
+		OccurredAt:  time.Now(),
+	}
+
+	deactivated := false
+	err := s.transaction(func(tx Store) error {
+		// Lock the row so concurrent updates agree on who deactivated the user
+		current, err := s.lockUser(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		deactivated = current.IsActive && !user.IsActive
+		// Tokens carry is_superuser, so they must not outlive a change to it
+		revoke := deactivated || current.IsSuperuser != user.IsSuperuser
+
+		// Only update specific fields, not the entire record
+		err = tx.Users().Update(s.context(), user.ID, map[string]interface{}{
+			"username":     user.Username,
+			"email":        user.Email,
+			"first_name":   user.FirstName,
+			"last_name":    user.LastName,
+			"is_active":    user.IsActive,
+			"is_superuser": user.IsSuperuser,
+		})
+		if err != nil {
+			return err
+		}
+	
+		if err := tx.Users().UpdateExtra(s.context(), model); err != nil {
+			return err
+		}
+		if revoke {
+			if _, err := tx.Sessions().RevokeOthers(s.context(), user.ID, 0, event.OccurredAt); err != nil {
+				return err
+			}
+		}
+
+		if err := s.enqueueEvent(tx, event); err != nil {
+			return err
+		}
+		if deactivated {
+			return s.enqueueEvent(tx, UserDeactivated{UserID: user.ID, OccurredAt: event.OccurredAt})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.publish(event)
+	if deactivated {
+		s.publish(UserDeactivated{UserID: user.ID, OccurredAt: event.OccurredAt})
+	}
+
+	s.audit(AuditUserUpdated, 0, user.ID, AuditMetadata{
 		"is_active":    user.IsActive,
 		"is_superuser": user.IsSuperuser,
 	})
-	
-	return result.Error
+	return nil
 }
\ No newline at end of file
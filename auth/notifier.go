@@ -0,0 +1,34 @@
+package auth
+
+import "log"
+
+// Notification is a message addressed to a user, typically delivered by email
+type Notification struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers notifications to users. Applications plug in their own
+// implementation (SMTP, SES, SMS...) through Config.Notifier.
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// LogNotifier is the default Notifier; it writes notifications to the standard logger
+type LogNotifier struct{}
+
+// Notify logs the notification instead of delivering it
+func (LogNotifier) Notify(notification Notification) error {
+	log.Printf("Notification to %s: %s - %s", notification.To, notification.Subject, notification.Body)
+	return nil
+}
+
+// notify sends a notification, logging rather than failing when delivery fails
+func (s *Service) notify(notification Notification) {
+	s.afterCommit(func() {
+		if err := s.config.Notifier.Notify(notification); err != nil {
+			log.Printf("Failed to send notification to %s: %v", notification.To, err)
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProfileUpdate holds the self-service profile changes a user may make.
+// Nil fields are left untouched, giving PATCH semantics.
+type ProfileUpdate struct {
+	FirstName *string
+	LastName  *string
+	Username  *string
+	Email     *string
+}
+
+// ProfileUpdateResult describes the outcome of UpdateProfile
+type ProfileUpdateResult struct {
+	User               *User
+	EmailChangePending bool
+	PendingEmail       string
+}
+
+// UpdateProfile calls UpdateProfileContext with the service's context
+func (s *Service) UpdateProfile(userID uint, update ProfileUpdate) (*ProfileUpdateResult, error) {
+	return s.UpdateProfileContext(s.context(), userID, update)
+}
+
+// UpdateProfileContext applies a user's own profile changes. Only names and the
+// username are written directly; an email change is held pending until the
+// new address is verified with ConfirmEmailChange.
+func (s *Service) UpdateProfileContext(ctx context.Context, userID uint, update ProfileUpdate) (*ProfileUpdateResult, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+
+	if update.FirstName != nil {
+		user.FirstName = strings.TrimSpace(*update.FirstName)
+		updates["first_name"] = user.FirstName
+	}
+	if update.LastName != nil {
+		user.LastName = strings.TrimSpace(*update.LastName)
+		updates["last_name"] = user.LastName
+	}
+	if update.Username != nil && strings.TrimSpace(*update.Username) != user.Username {
+		username := strings.TrimSpace(*update.Username)
+		taken, err := s.fieldTaken("username", username, userID)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, ErrUsernameExists
+		}
+		user.Username = username
+		updates["username"] = username
+	}
+
+	newEmail := ""
+	if update.Email != nil && !strings.EqualFold(strings.TrimSpace(*update.Email), user.Email) {
+		newEmail = strings.TrimSpace(*update.Email)
+		if !ValidateEmail(newEmail) {
+			return nil, ErrInvalidEmail
+		}
+		taken, err := s.fieldTaken("email", newEmail, userID)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, ErrEmailExists
+		}
+	}
+
+	if err := s.validateData(*user); err != nil {
+		return nil, err
+	}
+
+	// The profile changes and the email change request are saved together
+	err = s.atomically(func(s *Service) error {
+		if len(updates) > 0 {
+			if err := s.store().Users().Update(s.context(), user.ID, updates); err != nil {
+				return err
+			}
+
+			changed := make([]string, 0, len(updates))
+			for field := range updates {
+				changed = append(changed, field)
+			}
+			sort.Strings(changed)
+			s.audit(AuditProfileUpdated, userID, userID, AuditMetadata{"fields": changed})
+		}
+
+		if newEmail != "" {
+			return s.requestEmailChange(user, newEmail)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProfileUpdateResult{User: user}
+	if newEmail != "" {
+		result.EmailChangePending = true
+		result.PendingEmail = newEmail
+	}
+
+	return result, nil
+}
+
+// ConfirmEmailChange calls ConfirmEmailChangeContext with the service's context
+func (s *Service) ConfirmEmailChange(userID uint, token string) (*User, error) {
+	return s.ConfirmEmailChangeContext(s.context(), userID, token)
+}
+
+// ConfirmEmailChangeContext applies a pending email change once the user presents the
+// token that was sent to the new address
+func (s *Service) ConfirmEmailChangeContext(ctx context.Context, userID uint, token string) (*User, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	change, err := s.store().EmailChanges().GetPending(s.context(), userID, hashToken(token), time.Now())
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The address may have been claimed by someone else since the change was requested
+	taken, err := s.fieldTaken("email", change.NewEmail, userID)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, ErrEmailExists
+	}
+
+	oldEmail := user.Email
+	updates := map[string]interface{}{"email": change.NewEmail}
+	// Accounts registered with their email as username keep the two in sync
+	if user.Username == oldEmail {
+		updates["username"] = change.NewEmail
+	}
+
+	now := time.Now()
+	err = s.transaction(func(tx Store) error {
+		// Claim the change first so a token presented twice applies once
+		claimed, err := tx.EmailChanges().Confirm(s.context(), change.ID, now)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return ErrInvalidToken
+		}
+
+		return tx.Users().Update(s.context(), user.ID, updates)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(AuditEmailChanged, userID, userID, nil)
+
+	user.Email = change.NewEmail
+	if username, ok := updates["username"].(string); ok {
+		user.Username = username
+	}
+
+	s.notify(Notification{
+		To:      oldEmail,
+		Subject: "Your email address was changed",
+		Body:    fmt.Sprintf("The email address on your account was changed to %s. If you did not make this change, contact support immediately.", change.NewEmail),
+	})
+
+	return user, nil
+}
+
+// requestEmailChange records a pending email change, sends the verification
+// token to the new address and warns the old one
+func (s *Service) requestEmailChange(user *User, newEmail string) error {
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return err
+	}
+
+	change := EmailChange{
+		UserID:    user.ID,
+		NewEmail:  newEmail,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(s.config.EmailChangeTTL),
+	}
+
+	err = s.transaction(func(tx Store) error {
+		// Only the most recent request can be confirmed
+		if err := tx.EmailChanges().DeletePending(s.context(), user.ID); err != nil {
+			return err
+		}
+		return tx.EmailChanges().Create(s.context(), &change)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.audit(AuditEmailChangeRequested, user.ID, user.ID, nil)
+
+	s.notify(Notification{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body:    fmt.Sprintf("Use this token to confirm your new email address: %s", token),
+	})
+	s.notify(Notification{
+		To:      user.Email,
+		Subject: "Email change requested",
+		Body:    fmt.Sprintf("A request was made to change your account email to %s. If this wasn't you, change your password immediately.", newEmail),
+	})
+
+	return nil
+}
+
+// fieldTaken reports whether another user already uses the value for a unique column
+func (s *Service) fieldTaken(column, value string, excludeUserID uint) (bool, error) {
+	return s.store().Users().Taken(s.context(), column, value, excludeUserID)
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"strings"
+	"time"
+)
+
+// RateLimit allows at most Max requests in any period of length Window
+type RateLimit struct {
+	Max    int
+	Window time.Duration
+}
+
+// withDefaults fills in whichever of Max and Window is unset
+func (l RateLimit) withDefaults(max int, window time.Duration) RateLimit {
+	if l.Max <= 0 {
+		l.Max = max
+	}
+	if l.Window <= 0 {
+		l.Window = window
+	}
+	return l
+}
+
+// RateLimitHit records one request counted towards a rate limit. Hits are
+// kept in the database so every server instance counts against the same limit.
+type RateLimitHit struct {
+	ID        uint      `gorm:"primaryKey"`
+	Bucket    string    `gorm:"size:128;not null;index:idx_rate_limit_hits_bucket_created_at,priority:1"`
+	CreatedAt time.Time `gorm:"index:idx_rate_limit_hits_bucket_created_at,priority:2;index"`
+}
+
+// Actions limited by Config.PasswordResetEmailLimit and Config.PasswordResetIPLimit
+const (
+	rateLimitPasswordResetRequest = "password_reset_request"
+	rateLimitPasswordReset        = "password_reset"
+)
+
+// limitPasswordReset counts a password reset call against the limits on its
+// email address and client IP, failing with ErrTooManyRequests once either is
+// exceeded. Every address is limited, registered or not, so the limit reveals
+// nothing about which accounts exist; addresses are stored hashed.
+func (s *Service) limitPasswordReset(action, email string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if err := s.rateLimit(action+":email:"+hashToken(email), s.config.PasswordResetEmailLimit); err != nil {
+		return err
+	}
+	if s.request.IP == "" {
+		return nil
+	}
+	return s.rateLimit(action+":ip:"+s.request.IP, s.config.PasswordResetIPLimit)
+}
+
+// rateLimit records a request in bucket and fails with ErrTooManyRequests when
+// it is more than limit allows. Refused requests count too, so a client that
+// keeps retrying stays limited.
+func (s *Service) rateLimit(bucket string, limit RateLimit) error {
+	s = s.detached()
+
+	now := time.Now()
+	hits := s.store().RateLimits()
+	if err := hits.DeleteBefore(s.context(), now.Add(-s.longestRateLimitWindow())); err != nil {
+		return err
+	}
+	if err := hits.Create(s.context(), &RateLimitHit{Bucket: truncate(bucket, 128), CreatedAt: now}); err != nil {
+		return err
+	}
+	count, err := hits.Count(s.context(), truncate(bucket, 128), now.Add(-limit.Window))
+	if err != nil {
+		return err
+	}
+	if count > int64(limit.Max) {
+		return ErrTooManyRequests
+	}
+	return nil
+}
+
+// longestRateLimitWindow is how long hits must be kept for every limit to see them
+func (s *Service) longestRateLimitWindow() time.Duration {
+	window := s.config.PasswordResetEmailLimit.Window
+	if s.config.PasswordResetIPLimit.Window > window {
+		window = s.config.PasswordResetIPLimit.Window
+	}
+	return window
+}
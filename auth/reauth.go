@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReauthenticationRequiredError is returned when a token's authentication is too
+// old, or lacks a required method, for the operation being attempted
+type ReauthenticationRequiredError struct {
+	MaxAge  time.Duration
+	Factors []string
+}
+
+// Error implements error
+func (e *ReauthenticationRequiredError) Error() string {
+	return ErrReauthenticationRequired.Error()
+}
+
+// Unwrap lets errors.Is(err, ErrReauthenticationRequired) match
+func (e *ReauthenticationRequiredError) Unwrap() error {
+	return ErrReauthenticationRequired
+}
+
+// CheckRecentAuth reports whether the claims prove an authentication within
+// maxAge using every one of factors (amr values such as AMRPassword)
+func CheckRecentAuth(claims *TokenClaims, maxAge time.Duration, factors ...string) error {
+	required := &ReauthenticationRequiredError{MaxAge: maxAge, Factors: factors}
+
+	if claims.AuthTime == 0 || time.Since(time.Unix(claims.AuthTime, 0)) > maxAge {
+		return required
+	}
+	for _, factor := range factors {
+		if !containsString(claims.AMR, factor) {
+			return required
+		}
+	}
+	return nil
+}
+
+// RequestReauthenticationOTP calls RequestReauthenticationOTPContext with the service's context
+func (s *Service) RequestReauthenticationOTP(claims *TokenClaims) error {
+	return s.RequestReauthenticationOTPContext(s.context(), claims)
+}
+
+// RequestReauthenticationOTPContext sends the user a one-time code for Reauthenticate
+func (s *Service) RequestReauthenticationOTPContext(ctx context.Context, claims *TokenClaims) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if claims.IsImpersonated() {
+		return ErrImpersonationForbidden
+	}
+
+	user, err := s.GetUserByID(claims.UserID)
+	if err != nil {
+		return err
+	}
+
+	validityMinutes := int(s.config.ElevatedTokenDuration.Minutes())
+	if validityMinutes < 1 {
+		validityMinutes = 1
+	}
+	code, err := s.generateOTP(user.ID, OTPPurposeReauthentication, 6, validityMinutes)
+	if err != nil {
+		return err
+	}
+
+	s.notify(Notification{
+		To:      user.Email,
+		Subject: "Confirm it's you",
+		Body:    fmt.Sprintf("Your verification code is %s. It expires in %d minutes.", code, validityMinutes),
+	})
+	return nil
+}
+
+// Reauthenticate calls ReauthenticateContext with the service's context
+func (s *Service) Reauthenticate(claims *TokenClaims, password, otpValue string) (string, time.Time, error) {
+	return s.ReauthenticateContext(s.context(), claims, password, otpValue)
+}
+
+// ReauthenticateContext verifies the password and/or a one-time code for the user behind
+// claims and issues a short-lived token with a fresh auth_time. Its amr lists
+// only the methods verified here. The token keeps the caller's session.
+func (s *Service) ReauthenticateContext(ctx context.Context, claims *TokenClaims, password, otpValue string) (string, time.Time, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if claims.IsImpersonated() {
+		return "", time.Time{}, ErrImpersonationForbidden
+	}
+	if password == "" && otpValue == "" {
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+
+	model, err := s.GetUserModel(claims.UserID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	user := model.AuthUser()
+	if !user.IsActive {
+		return "", time.Time{}, ErrUserInactive
+	}
+
+	var amr []string
+	if password != "" {
+		if !s.VerifyPassword(user.Password, password) {
+			s.audit(AuditReauthenticationFailed, user.ID, user.ID, AuditMetadata{"reason": "invalid_password"})
+			return "", time.Time{}, ErrInvalidPassword
+		}
+		amr = append(amr, AMRPassword)
+	}
+	if otpValue != "" {
+		valid, err := s.verifyOTP(user.ID, OTPPurposeReauthentication, otpValue)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if !valid {
+			s.audit(AuditReauthenticationFailed, user.ID, user.ID, AuditMetadata{"reason": "invalid_otp"})
+			return "", time.Time{}, ErrInvalidChallenge
+		}
+		amr = append(amr, AMROTP)
+	}
+
+	token, expiresAt, err := s.generateToken(model, tokenOptions{
+		sessionID: claims.SessionID,
+		authTime:  time.Now(),
+		amr:       amr,
+		duration:  s.config.ElevatedTokenDuration,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	s.audit(AuditReauthenticated, user.ID, user.ID, AuditMetadata{"amr": amr})
+
+	return token, expiresAt, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// passwordResetOTPMinutes is how long a password reset code stays valid
+const passwordResetOTPMinutes = 15
+
+// RequestPasswordReset calls RequestPasswordResetContext with the service's context
+func (s *Service) RequestPasswordReset(email string) error {
+	return s.RequestPasswordResetContext(s.context(), email)
+}
+
+// RequestPasswordResetContext emails a one-time reset code to the account with the
+// given email. It succeeds whether or not such an account exists, so callers
+// cannot use it to discover registered addresses. It fails with
+// ErrTooManyRequests once the email address or client IP has made too many
+// password reset calls.
+func (s *Service) RequestPasswordResetContext(ctx context.Context, email string) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if err := s.limitPasswordReset(rateLimitPasswordResetRequest, email); err != nil {
+		return err
+	}
+
+	user, err := s.activeUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	code, err := s.generateOTP(user.ID, OTPPurposePasswordReset, 6, passwordResetOTPMinutes)
+	if err != nil {
+		return err
+	}
+
+	s.notify(Notification{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Your password reset code is %s. It expires in %d minutes. If you did not ask to reset your password, you can ignore this email.",
+			code, passwordResetOTPMinutes),
+	})
+	return nil
+}
+
+// ResetPasswordWithOTP calls ResetPasswordWithOTPContext with the service's context
+func (s *Service) ResetPasswordWithOTP(email, otpValue, newPassword string) error {
+	return s.ResetPasswordWithOTPContext(s.context(), email, otpValue, newPassword)
+}
+
+// ResetPasswordWithOTPContext sets a new password using a code from RequestPasswordReset
+// and signs the user out of every session. Like RequestPasswordReset it is
+// rate-limited by email address and client IP.
+func (s *Service) ResetPasswordWithOTPContext(ctx context.Context, email, otpValue, newPassword string) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if err := s.limitPasswordReset(rateLimitPasswordReset, email); err != nil {
+		return err
+	}
+
+	user, err := s.activeUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return ErrInvalidChallenge
+		}
+		return err
+	}
+
+	// The check is counted before the transaction, which a wrong code rolls back
+	counted, err := s.countOTPAttempt(user.ID, OTPPurposePasswordReset)
+	if err != nil {
+		return err
+	}
+	if !counted {
+		return ErrInvalidChallenge
+	}
+
+	// Consuming the code, setting the password and signing out other sessions
+	// succeed or fail together, so a vetoed password leaves the code usable
+	return s.atomically(func(s *Service) error {
+		valid, err := s.consumeOTP(user.ID, OTPPurposePasswordReset, otpValue)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return ErrInvalidChallenge
+		}
+
+		if err := s.ResetPassword(user.ID, newPassword); err != nil {
+			return err
+		}
+
+		_, err = s.RevokeOtherSessions(user.ID, 0)
+		return err
+	})
+}
+
+// activeUserByEmail looks up an active user by email, case-insensitively
+func (s *Service) activeUserByEmail(email string) (*User, error) {
+	var user User
+	if err := s.store().Users().GetActiveByEmail(s.context(), strings.TrimSpace(email), &user); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by repositories when no record matches a lookup
+var ErrNotFound = errors.New("record not found")
+
+// Store gives the Service access to the records it keeps. NewGormStore keeps
+// them in a database and NewMemoryStore in memory, which lets the Service and
+// the handlers built on it run in tests without a database.
+type Store interface {
+	Users() UserRepository
+	OTPs() OTPRepository
+	EmailChanges() EmailChangeRepository
+	Sessions() SessionRepository
+	AccountDeletions() AccountDeletionRepository
+	Impersonations() ImpersonationRepository
+	KnownDevices() KnownDeviceRepository
+	LoginAttempts() LoginAttemptRepository
+	LoginChallenges() LoginChallengeRepository
+	AuditEvents() AuditRepository
+	Outbox() OutboxRepository
+	Webhooks() WebhookRepository
+	RateLimits() RateLimitRepository
+
+	// Transaction runs fn with a Store whose changes are committed together
+	// when fn returns nil and discarded otherwise. Called on the Store given to
+	// fn, it runs fn as a nested transaction that can fail on its own.
+	Transaction(ctx context.Context, fn func(tx Store) error) error
+}
+
+// UserQuery is a validated ListUsers request
+type UserQuery struct {
+	Search         string // Matched case-insensitively against username and email
+	IsActive       *bool
+	IsSuperuser    *bool
+	DateJoinedFrom *time.Time
+	DateJoinedTo   *time.Time
+	SortBy         string // One of id, username, email or date_joined
+	SortDesc       bool
+	AfterValue     interface{} // Sort value of the last user on the previous page, a time.Time for date_joined
+	AfterID        uint        // ID of the last user on the previous page, zero on the first page
+	Limit          int
+	Deleted        bool       // Soft-deleted users instead of undeleted ones
+	DeletedBefore  *time.Time // With Deleted, only users deleted before this time
+}
+
+// UserRepository stores users. Methods taking an Authenticatable read or
+// write the whole configured user model, including its extra columns.
+// Soft-deleted users are left out of every method but List with
+// UserQuery.Deleted, Restore, Delete and Purge. Writes that would give two undeleted
+// users the same username or email fail with ErrUsernameExists or
+// ErrEmailExists.
+type UserRepository interface {
+	Create(ctx context.Context, model Authenticatable) error
+	Get(ctx context.Context, id uint, dest Authenticatable) error
+	GetByLogin(ctx context.Context, login string, dest Authenticatable) error // Exact username or email
+	GetActiveByEmail(ctx context.Context, email string, dest Authenticatable) error
+	// Lock returns the user and keeps other transactions from changing it
+	// until the current one ends
+	Lock(ctx context.Context, id uint) (*User, error)
+	// LockUnscoped and UpdateUnscoped work like Lock and Update but reach
+	// soft-deleted users as well; they are only for erasing accounts
+	LockUnscoped(ctx context.Context, id uint) (*User, error)
+	List(ctx context.Context, query UserQuery) ([]User, error)
+	Exists(ctx context.Context, id uint, username string) (bool, error) // Zero arguments match any value
+	Taken(ctx context.Context, column, value string, exceptID uint) (bool, error)
+	Update(ctx context.Context, id uint, fields map[string]interface{}) error
+	UpdateUnscoped(ctx context.Context, id uint, fields map[string]interface{}) error
+	UpdateExtra(ctx context.Context, model Authenticatable) error // Columns the model adds on top of User
+	// ReplacePassword sets a new password hash only while the user's hash is
+	// still currentHash and reports whether it did
+	ReplacePassword(ctx context.Context, id uint, currentHash, newHash string, changedAt time.Time) (bool, error)
+	SoftDelete(ctx context.Context, id uint, at time.Time) error
+	Restore(ctx context.Context, id uint) error // ErrNotFound unless the user is soft-deleted
+	// Delete permanently removes the user, soft-deleted or not, along with
+	// their OTPs, email changes, sessions, known devices and login challenges
+	Delete(ctx context.Context, id uint) error
+	// Purge is Delete for a user soft-deleted before deletedBefore, checked in
+	// the same statement; ErrNotFound otherwise, e.g. once restored
+	Purge(ctx context.Context, id uint, deletedBefore time.Time) error
+}
+
+// OTPRepository stores one-time passwords
+type OTPRepository interface {
+	Create(ctx context.Context, otp *OTP) error
+	// AddAttempt counts a check against the user's unexpired, unused code for
+	// purpose and reports false when there is none or it has had maxAttempts
+	AddAttempt(ctx context.Context, userID uint, purpose string, now time.Time, maxAttempts int) (bool, error)
+	// Consume marks the user's unexpired, unused code for purpose as used if
+	// it has the given value, and reports whether it did
+	Consume(ctx context.Context, userID uint, purpose, value string, now time.Time) (bool, error)
+	ListForUser(ctx context.Context, userID uint) ([]OTP, error)
+	DeleteForUser(ctx context.Context, userID uint) error
+	DeleteForPurpose(ctx context.Context, userID uint, purpose string) error
+}
+
+// EmailChangeRepository stores pending email address changes
+type EmailChangeRepository interface {
+	Create(ctx context.Context, change *EmailChange) error
+	GetPending(ctx context.Context, userID uint, tokenHash string, now time.Time) (*EmailChange, error)
+	Confirm(ctx context.Context, id uint, at time.Time) (bool, error) // False when already confirmed
+	ListForUser(ctx context.Context, userID uint) ([]EmailChange, error)
+	DeletePending(ctx context.Context, userID uint) error
+	DeleteForUser(ctx context.Context, userID uint) error
+}
+
+// SessionRepository stores login sessions. A session is active while it is
+// neither revoked nor expired.
+type SessionRepository interface {
+	Create(ctx context.Context, session *Session) error
+	GetActive(ctx context.Context, id, userID uint, now time.Time) (*Session, error)
+	GetByRefreshHash(ctx context.Context, refreshHash string, now time.Time) (*Session, error)
+	ListActive(ctx context.Context, userID uint, now time.Time) ([]Session, error) // Most recently used first
+	ListForUser(ctx context.Context, userID uint) ([]Session, error)
+	Touch(ctx context.Context, id uint, at time.Time) error
+	Extend(ctx context.Context, id uint, expiresAt time.Time) error
+	// RotateRefresh gives an unrevoked session a new refresh token hash and
+	// expiry. When currentHash is not empty the session must still carry it.
+	RotateRefresh(ctx context.Context, id uint, currentHash, newHash string, expiresAt time.Time) (bool, error)
+	Revoke(ctx context.Context, userID, id uint, at time.Time) (bool, error)
+	RevokeOthers(ctx context.Context, userID, exceptID uint, now time.Time) (int64, error) // Active sessions only
+	End(ctx context.Context, userID, id uint, at time.Time) error                          // Revokes and drops the refresh token
+	DeleteForUser(ctx context.Context, userID uint) error
+}
+
+// AccountDeletionRepository stores account deletion requests. A deletion is
+// pending while it is neither cancelled nor completed.
+type AccountDeletionRepository interface {
+	Create(ctx context.Context, deletion *AccountDeletion) error
+	GetPending(ctx context.Context, userID uint) (*AccountDeletion, error)
+	ListDue(ctx context.Context, now time.Time) ([]AccountDeletion, error)
+	ListForUser(ctx context.Context, userID uint) ([]AccountDeletion, error)
+	Cancel(ctx context.Context, userID uint, at time.Time) (bool, error) // False when none is pending
+	Complete(ctx context.Context, id uint, at time.Time) (bool, error)   // False when no longer pending
+}
+
+// ImpersonationRepository stores impersonation sessions
+type ImpersonationRepository interface {
+	Create(ctx context.Context, session *ImpersonationSession) error
+	GetActive(ctx context.Context, id uint, now time.Time) (*ImpersonationSession, error)
+	List(ctx context.Context, impersonatorID, targetID uint, limit int) ([]ImpersonationSession, error) // Newest first, zero IDs match any
+	End(ctx context.Context, id uint, at time.Time) error
+}
+
+// KnownDeviceRepository stores the devices users have logged in from
+type KnownDeviceRepository interface {
+	Known(ctx context.Context, userID uint, fingerprint string) (bool, error)
+	// Remember records the device, or refreshes the last IP, location and
+	// seen time of one already known
+	Remember(ctx context.Context, device *KnownDevice) error
+	ListForUser(ctx context.Context, userID uint) ([]KnownDevice, error)
+	DeleteForUser(ctx context.Context, userID uint) error
+}
+
+// LoginAttemptRepository stores login attempts
+type LoginAttemptRepository interface {
+	Create(ctx context.Context, attempt *LoginAttempt) error
+	LastLocatedSuccess(ctx context.Context, userID uint) (*LoginAttempt, error)
+	// CountUserFailures and CountIPFailures count the failures for one of
+	// reasons since the given time, on an account or from an address
+	CountUserFailures(ctx context.Context, userID uint, reasons []string, since time.Time) (int64, error)
+	CountIPFailures(ctx context.Context, ip string, reasons []string, since time.Time) (int64, error)
+	ListForUser(ctx context.Context, userID uint) ([]LoginAttempt, error)
+	DeleteForUser(ctx context.Context, userID uint) error
+}
+
+// LoginChallengeRepository stores step-up login challenges
+type LoginChallengeRepository interface {
+	Create(ctx context.Context, challenge *LoginChallenge) error
+	// GetOpen returns an uncompleted, unexpired challenge with fewer than
+	// maxAttempts failed attempts
+	GetOpen(ctx context.Context, tokenHash string, now time.Time, maxAttempts int) (*LoginChallenge, error)
+	AddAttempt(ctx context.Context, id uint) error
+	Complete(ctx context.Context, id uint, at time.Time) (bool, error) // False when already completed
+	DeleteForUser(ctx context.Context, userID uint) error
+}
+
+// AuditRepository stores audit events, which are only ever appended; Scrub
+// only erases personal data from events outside the hash chain
+type AuditRepository interface {
+	Create(ctx context.Context, event *AuditEvent) error
+	// LockChainHead returns the hash of the newest chained event, locking the
+	// chain head until the transaction ends
+	LockChainHead(ctx context.Context) (string, error)
+	ChainHead(ctx context.Context) (string, error) // As LockChainHead, without the lock
+	SetChainHead(ctx context.Context, hash string) error
+	List(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) // Newest first, filter.Limit is set
+	ListForUser(ctx context.Context, userID uint) ([]AuditEvent, error) // Events with the user as actor or target
+	// ListFailedLogins returns the failed logins recorded with login as the
+	// username in their metadata, i.e. for an unknown login name
+	ListFailedLogins(ctx context.Context, login string) ([]AuditEvent, error)
+	// Scrub replaces the client details and metadata of an event that is not
+	// hashed; hashed events are left unchanged
+	Scrub(ctx context.Context, id uint, ip, userAgent string, metadata AuditMetadata) error
+	// EachHashed calls fn with the hashed events in ID order, batchSize at a
+	// time, stopping at the first error
+	EachHashed(ctx context.Context, batchSize int, fn func(events []AuditEvent) error) error
+}
+
+// OutboxRepository stores events waiting to be fanned out to webhooks
+type OutboxRepository interface {
+	Create(ctx context.Context, message *OutboxMessage) error
+	Get(ctx context.Context, id uint) (*OutboxMessage, error)
+	// ClaimUnprocessed returns up to limit unprocessed messages, oldest first,
+	// skipping those claimed by other open transactions
+	ClaimUnprocessed(ctx context.Context, limit int) ([]OutboxMessage, error)
+	MarkProcessed(ctx context.Context, id uint, at time.Time) error
+	ListForUser(ctx context.Context, userID uint) ([]OutboxMessage, error) // Messages whose event is about the user
+	SetPayload(ctx context.Context, id uint, payload string) error
+}
+
+// RateLimitRepository stores the requests counted towards rate limits
+type RateLimitRepository interface {
+	Create(ctx context.Context, hit *RateLimitHit) error
+	Count(ctx context.Context, bucket string, since time.Time) (int64, error) // Hits in bucket after since
+	DeleteBefore(ctx context.Context, before time.Time) error
+}
+
+// WebhookRepository stores webhook subscriptions, deliveries and attempts
+type WebhookRepository interface {
+	CreateSubscription(ctx context.Context, subscription *WebhookSubscription) error
+	GetSubscription(ctx context.Context, id uint) (*WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context, activeOnly bool) ([]WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, id uint) error
+
+	CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	ListDeliveries(ctx context.Context, subscriptionID uint, status string, limit int) ([]WebhookDelivery, error) // Newest first, zero values match any
+	// LeaseDue claims up to limit pending deliveries due by now, pushing their
+	// next attempt to until so that other dispatchers skip them meanwhile
+	LeaseDue(ctx context.Context, now time.Time, limit int, until time.Time) ([]WebhookDelivery, error)
+	// UpdateDelivery saves the status, attempt count, next attempt and
+	// delivery time of the delivery
+	UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+
+	CreateAttempt(ctx context.Context, attempt *WebhookAttempt) error
+	ListAttempts(ctx context.Context, deliveryID uint) ([]WebhookAttempt, error)
+}
@@ -0,0 +1,504 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// RiskAction is the response to a risky login
+type RiskAction string
+
+const (
+	RiskAllow  RiskAction = "allow"   // Let the login through silently
+	RiskNotify RiskAction = "notify"  // Let the login through and alert the user
+	RiskStepUp RiskAction = "step_up" // Require a one-time code sent to the user
+	RiskBlock  RiskAction = "block"   // Reject the login
+)
+
+// severity orders actions so the strictest triggered one wins
+func (a RiskAction) severity() int {
+	switch a {
+	case RiskNotify:
+		return 1
+	case RiskStepUp:
+		return 2
+	case RiskBlock:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// RiskSignal names something suspicious about a login
+type RiskSignal string
+
+const (
+	SignalNewDevice        RiskSignal = "new_device"
+	SignalImpossibleTravel RiskSignal = "impossible_travel"
+	SignalFailureBurst     RiskSignal = "failure_burst"    // On the account
+	SignalIPFailureBurst   RiskSignal = "ip_failure_burst" // From the client's IP, on any account
+)
+
+// maxChallengeAttempts is how many wrong codes a step-up challenge accepts
+const maxChallengeAttempts = 5
+
+// credentialFailureReasons are the login failures counted towards a failure burst
+var credentialFailureReasons = []string{"user_not_found", "invalid_password", "invalid_otp"}
+
+// RiskPolicy configures login risk detection. Each signal maps to the action
+// taken when it fires; the strictest action among the fired signals applies.
+type RiskPolicy struct {
+	Enabled bool // Record login attempts and assess risk; off by default
+
+	NewDeviceAction RiskAction // Login from a device not seen before for the user, defaults to RiskNotify
+
+	ImpossibleTravelAction RiskAction // Consecutive logins too far apart to travel between, defaults to RiskStepUp
+	MaxTravelSpeedKmh      float64    // Fastest plausible travel speed, defaults to 1000 km/h
+	MinTravelDistanceKm    float64    // Distances below this are ignored to absorb GeoIP inaccuracy, defaults to 300 km
+
+	// Failures on an account and from an IP are counted apart, so failing
+	// logins against an account cannot lock its owner out and a noisy client
+	// behind a shared address does not hold up everyone else behind it
+	FailureBurstAction      RiskAction    // Many failed attempts on the account, defaults to RiskStepUp
+	FailureBurstThreshold   int           // Failed attempts on one account that make a burst, defaults to 5
+	IPFailureBurstAction    RiskAction    // Many failed attempts from the client's IP, defaults to RiskStepUp
+	IPFailureBurstThreshold int           // Failed attempts from one IP that make a burst, defaults to 20
+	FailureBurstWindow      time.Duration // Period in which failures are counted, defaults to 15 minutes
+
+	StepUpTTL time.Duration // Lifetime of a step-up challenge and its code, defaults to 10 minutes
+}
+
+// withDefaults fills unset policy fields
+func (p RiskPolicy) withDefaults() RiskPolicy {
+	if p.NewDeviceAction == "" {
+		p.NewDeviceAction = RiskNotify
+	}
+	if p.ImpossibleTravelAction == "" {
+		p.ImpossibleTravelAction = RiskStepUp
+	}
+	if p.MaxTravelSpeedKmh <= 0 {
+		p.MaxTravelSpeedKmh = 1000
+	}
+	if p.MinTravelDistanceKm <= 0 {
+		p.MinTravelDistanceKm = 300
+	}
+	if p.FailureBurstAction == "" {
+		p.FailureBurstAction = RiskStepUp
+	}
+	if p.FailureBurstThreshold <= 0 {
+		p.FailureBurstThreshold = 5
+	}
+	if p.IPFailureBurstAction == "" {
+		p.IPFailureBurstAction = RiskStepUp
+	}
+	if p.IPFailureBurstThreshold <= 0 {
+		p.IPFailureBurstThreshold = 20
+	}
+	if p.FailureBurstWindow <= 0 {
+		p.FailureBurstWindow = 15 * time.Minute
+	}
+	if p.StepUpTTL <= 0 {
+		p.StepUpTTL = 10 * time.Minute
+	}
+	return p
+}
+
+// actionFor returns the configured action for a signal
+func (p RiskPolicy) actionFor(signal RiskSignal) RiskAction {
+	switch signal {
+	case SignalNewDevice:
+		return p.NewDeviceAction
+	case SignalImpossibleTravel:
+		return p.ImpossibleTravelAction
+	case SignalFailureBurst:
+		return p.FailureBurstAction
+	case SignalIPFailureBurst:
+		return p.IPFailureBurstAction
+	default:
+		return RiskAllow
+	}
+}
+
+// KnownDevice is a device the user has logged in from before
+type KnownDevice struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"uniqueIndex:idx_known_devices_user_fingerprint" json:"user_id"`
+	User         User      `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	Fingerprint  string    `gorm:"size:64;uniqueIndex:idx_known_devices_user_fingerprint" json:"-"`
+	DeviceName   string    `gorm:"size:100" json:"device_name"`
+	LastIP       string    `gorm:"size:45" json:"last_ip"`
+	LastLocation string    `gorm:"size:255" json:"last_location"`
+	FirstSeenAt  time.Time `json:"first_seen_at"`
+	LastSeenAt   time.Time `json:"last_seen_at"`
+}
+
+// LoginAttempt records one login attempt. UserID is 0 when the username did not
+// match an account; there is no foreign key so attempts outlive the user row.
+type LoginAttempt struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"index" json:"user_id"`
+	IP          string    `gorm:"size:45;index" json:"ip"`
+	Fingerprint string    `gorm:"size:64" json:"-"`
+	Succeeded   bool      `json:"succeeded"`
+	Reason      string    `gorm:"size:32" json:"reason,omitempty"`
+	Location    string    `gorm:"size:255" json:"location"`
+	Latitude    *float64  `json:"latitude,omitempty"`
+	Longitude   *float64  `json:"longitude,omitempty"`
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+}
+
+// LoginChallenge is a pending step-up verification for a risky login
+type LoginChallenge struct {
+	ID          uint      `gorm:"primaryKey"`
+	UserID      uint      `gorm:"index"`
+	User        User      `gorm:"constraint:OnDelete:CASCADE;"`
+	TokenHash   string    `gorm:"size:64;uniqueIndex"`
+	Signals     string    `gorm:"size:255"`
+	Attempts    int       `gorm:"not null;default:0"`
+	ExpiresAt   time.Time `gorm:"index"`
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+}
+
+// RiskAssessment is the outcome of evaluating a login
+type RiskAssessment struct {
+	Signals  []RiskSignal
+	Action   RiskAction
+	Location *GeoLocation
+
+	fingerprint string
+}
+
+// StepUpRequiredError is returned by Login when the user must confirm the login
+// with a one-time code, which has been sent to them. Pass ChallengeToken and
+// the code to CompleteLoginChallenge to finish logging in.
+type StepUpRequiredError struct {
+	ChallengeToken string
+	ExpiresAt      time.Time
+	Signals        []RiskSignal
+}
+
+// Error implements error
+func (e *StepUpRequiredError) Error() string {
+	return ErrStepUpRequired.Error()
+}
+
+// Unwrap lets errors.Is(err, ErrStepUpRequired) match
+func (e *StepUpRequiredError) Unwrap() error {
+	return ErrStepUpRequired
+}
+
+// deviceFingerprint identifies a device by its User-Agent
+func deviceFingerprint(userAgent string) string {
+	return hashToken(userAgent)
+}
+
+// assessLogin evaluates a login with valid credentials against the risk policy
+func (s *Service) assessLogin(user *User) (*RiskAssessment, error) {
+	assessment := &RiskAssessment{Action: RiskAllow, fingerprint: deviceFingerprint(s.request.UserAgent)}
+	policy := s.config.LoginRisk
+	if !policy.Enabled {
+		return assessment, nil
+	}
+
+	if location, ok := s.geoIP.Lookup(s.request.IP); ok {
+		assessment.Location = &location
+	}
+
+	// First-seen device
+	known, err := s.store().KnownDevices().Known(s.context(), user.ID, assessment.fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	// A user's very first login is not from a "new" device worth alerting on
+	if !known && user.LastLogin != nil {
+		assessment.add(SignalNewDevice, policy)
+	}
+
+	// Impossible travel since the previous located login
+	if assessment.Location != nil {
+		previous, err := s.store().LoginAttempts().LastLocatedSuccess(s.context(), user.ID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if err == nil && impossibleTravel(*previous, *assessment.Location, time.Now(), policy) {
+			assessment.add(SignalImpossibleTravel, policy)
+		}
+	}
+
+	// Bursts of failures on the account, and from this IP on any account
+	since := time.Now().Add(-policy.FailureBurstWindow)
+	failures, err := s.store().LoginAttempts().CountUserFailures(s.context(), user.ID, credentialFailureReasons, since)
+	if err != nil {
+		return nil, err
+	}
+	if failures >= int64(policy.FailureBurstThreshold) {
+		assessment.add(SignalFailureBurst, policy)
+	}
+	if s.request.IP != "" {
+		failures, err := s.store().LoginAttempts().CountIPFailures(s.context(), s.request.IP, credentialFailureReasons, since)
+		if err != nil {
+			return nil, err
+		}
+		if failures >= int64(policy.IPFailureBurstThreshold) {
+			assessment.add(SignalIPFailureBurst, policy)
+		}
+	}
+
+	return assessment, nil
+}
+
+// add records a fired signal and escalates the action if needed
+func (a *RiskAssessment) add(signal RiskSignal, policy RiskPolicy) {
+	a.Signals = append(a.Signals, signal)
+	if action := policy.actionFor(signal); action.severity() > a.Action.severity() {
+		a.Action = action
+	}
+}
+
+// impossibleTravel reports whether getting from the previous login to location
+// by now would need an implausible speed
+func impossibleTravel(previous LoginAttempt, location GeoLocation, now time.Time, policy RiskPolicy) bool {
+	distance := haversineKm(*previous.Latitude, *previous.Longitude, location.Latitude, location.Longitude)
+	if distance < policy.MinTravelDistanceKm {
+		return false
+	}
+
+	hours := now.Sub(previous.CreatedAt).Hours()
+	if hours <= 0 {
+		return true
+	}
+	return distance/hours > policy.MaxTravelSpeedKmh
+}
+
+// haversineKm returns the great-circle distance between two coordinates
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// recordLoginAttempt stores a login attempt when risk detection is enabled. The
+// user is alerted the moment their account's failures reach a burst.
+func (s *Service) recordLoginAttempt(userID uint, succeeded bool, reason string, location *GeoLocation) {
+	policy := s.config.LoginRisk
+	if !policy.Enabled {
+		return
+	}
+	s = s.detached()
+
+	attempt := LoginAttempt{
+		UserID:      userID,
+		IP:          s.request.IP,
+		Fingerprint: deviceFingerprint(s.request.UserAgent),
+		Succeeded:   succeeded,
+		Reason:      reason,
+	}
+	if location == nil {
+		if l, ok := s.geoIP.Lookup(s.request.IP); ok {
+			location = &l
+		}
+	}
+	if location != nil {
+		attempt.Location = truncate(location.String(), 255)
+		attempt.Latitude = &location.Latitude
+		attempt.Longitude = &location.Longitude
+	}
+
+	if err := s.store().LoginAttempts().Create(s.context(), &attempt); err != nil {
+		log.Printf("Failed to record login attempt: %v", err)
+		return
+	}
+
+	if succeeded || userID == 0 || policy.FailureBurstAction == RiskAllow {
+		return
+	}
+	failures, err := s.store().LoginAttempts().CountUserFailures(s.context(), userID, credentialFailureReasons, time.Now().Add(-policy.FailureBurstWindow))
+	if err != nil {
+		log.Printf("Failed to count login failures: %v", err)
+		return
+	}
+	if failures == int64(policy.FailureBurstThreshold) {
+		if user, err := s.GetUserByID(userID); err == nil {
+			s.notify(Notification{
+				To:      user.Email,
+				Subject: "Repeated failed sign-in attempts",
+				Body: fmt.Sprintf("There have been %d failed attempts to sign in to your account in the last %s. If this wasn't you, consider changing your password.",
+					failures, policy.FailureBurstWindow),
+			})
+		}
+	}
+}
+
+// rememberDevice marks the current device as known for the user
+func (s *Service) rememberDevice(userID uint, assessment *RiskAssessment) {
+	now := time.Now()
+	location := ""
+	if assessment.Location != nil {
+		location = truncate(assessment.Location.String(), 255)
+	}
+
+	device := KnownDevice{
+		UserID:       userID,
+		Fingerprint:  assessment.fingerprint,
+		DeviceName:   parseDeviceName(s.request.UserAgent),
+		LastIP:       s.request.IP,
+		LastLocation: location,
+		FirstSeenAt:  now,
+		LastSeenAt:   now,
+	}
+	if err := s.store().KnownDevices().Remember(s.context(), &device); err != nil {
+		log.Printf("Failed to remember device: %v", err)
+	}
+}
+
+// notifyRisk tells the user about a risky login to their account
+func (s *Service) notifyRisk(user *User, assessment *RiskAssessment, blocked bool) {
+	where := "an unknown location"
+	if assessment.Location != nil && assessment.Location.String() != "" {
+		where = assessment.Location.String()
+	}
+
+	subject := "New sign-in to your account"
+	body := fmt.Sprintf("Your account was signed in to from %s (%s, IP %s) at %s.",
+		parseDeviceName(s.request.UserAgent), where, s.request.IP, time.Now().Format(time.RFC1123))
+	if blocked {
+		subject = "Suspicious sign-in blocked"
+		body = fmt.Sprintf("We blocked a sign-in to your account from %s (%s, IP %s) at %s.",
+			parseDeviceName(s.request.UserAgent), where, s.request.IP, time.Now().Format(time.RFC1123))
+	}
+	body += " If this wasn't you, change your password and revoke your other sessions."
+
+	s.notify(Notification{To: user.Email, Subject: subject, Body: body})
+}
+
+// startStepUp creates a login challenge and sends the user a one-time code
+func (s *Service) startStepUp(user *User, assessment *RiskAssessment) error {
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return err
+	}
+
+	ttl := s.config.LoginRisk.StepUpTTL
+	challenge := LoginChallenge{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		Signals:   joinSignals(assessment.Signals),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.store().LoginChallenges().Create(s.context(), &challenge); err != nil {
+		return err
+	}
+
+	validityMinutes := int(math.Ceil(ttl.Minutes()))
+	code, err := s.generateOTP(user.ID, OTPPurposeLoginStepUp, 6, validityMinutes)
+	if err != nil {
+		return err
+	}
+	s.notify(Notification{
+		To:      user.Email,
+		Subject: "Confirm your sign-in",
+		Body: fmt.Sprintf("Your verification code is %s. It expires in %d minutes. If you did not try to sign in, change your password.",
+			code, validityMinutes),
+	})
+
+	s.audit(AuditLoginStepUpRequired, user.ID, user.ID, AuditMetadata{"signals": challenge.Signals})
+
+	return &StepUpRequiredError{
+		ChallengeToken: token,
+		ExpiresAt:      challenge.ExpiresAt,
+		Signals:        assessment.Signals,
+	}
+}
+
+// CompleteLoginChallenge calls CompleteLoginChallengeContext with the service's context
+func (s *Service) CompleteLoginChallenge(challengeToken, otpValue string) (*User, string, error) {
+	return s.CompleteLoginChallengeContext(s.context(), challengeToken, otpValue)
+}
+
+// CompleteLoginChallengeContext finishes a login that required step-up verification,
+// returning the user and a token exactly as Login does
+func (s *Service) CompleteLoginChallengeContext(ctx context.Context, challengeToken, otpValue string) (*User, string, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	challenge, err := s.store().LoginChallenges().GetOpen(s.context(), hashToken(challengeToken), time.Now(), maxChallengeAttempts)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, "", ErrInvalidChallenge
+		}
+		return nil, "", err
+	}
+
+	valid, err := s.verifyOTP(challenge.UserID, OTPPurposeLoginStepUp, otpValue)
+	if err != nil {
+		return nil, "", err
+	}
+	if !valid {
+		s.store().LoginChallenges().AddAttempt(s.context(), challenge.ID)
+		s.recordLoginAttempt(challenge.UserID, false, "invalid_otp", nil)
+		return nil, "", ErrInvalidChallenge
+	}
+
+	// Claim the challenge so a concurrent request cannot reuse it
+	claimed, err := s.store().LoginChallenges().Complete(s.context(), challenge.ID, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	if !claimed {
+		return nil, "", ErrInvalidChallenge
+	}
+
+	model, err := s.GetUserModel(challenge.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	user := model.AuthUser()
+	if !user.IsActive {
+		return nil, "", ErrUserInactive
+	}
+
+	assessment := &RiskAssessment{
+		Signals:     splitSignals(challenge.Signals),
+		Action:      RiskStepUp,
+		fingerprint: deviceFingerprint(s.request.UserAgent),
+	}
+	if location, ok := s.geoIP.Lookup(s.request.IP); ok {
+		assessment.Location = &location
+	}
+
+	if err := s.completeAuthentication(model, user.Username, assessment); err != nil {
+		return nil, "", err
+	}
+	return s.issueLogin(model, user.Username, []string{AMRPassword, AMROTP})
+}
+
+func joinSignals(signals []RiskSignal) string {
+	parts := make([]string, len(signals))
+	for i, signal := range signals {
+		parts[i] = string(signal)
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitSignals(value string) []RiskSignal {
+	if value == "" {
+		return nil
+	}
+	parts := strings.Split(value, ",")
+	signals := make([]RiskSignal, len(parts))
+	for i, part := range parts {
+		signals[i] = RiskSignal(part)
+	}
+	return signals
+}
@@ -0,0 +1,411 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps the notifications sent to it
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (n *recordingNotifier) Notify(notification Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+// codePattern finds the code in a one-time code notification
+var codePattern = regexp.MustCompile(`code is (\S+)\.`)
+
+// lastCode returns the code in the last notification sent to address
+func (n *recordingNotifier) lastCode(t *testing.T, address string) string {
+	t.Helper()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i := len(n.sent) - 1; i >= 0; i-- {
+		if n.sent[i].To != address {
+			continue
+		}
+		if match := codePattern.FindStringSubmatch(n.sent[i].Body); match != nil {
+			return match[1]
+		}
+	}
+	t.Fatalf("no code was sent to %s", address)
+	return ""
+}
+
+// newTestService returns a Service on an empty memory store that fails the
+// test when a transaction is bypassed, see strictStore
+func newTestService(t *testing.T, config Config) (*Service, *recordingNotifier) {
+	t.Helper()
+	notifier := &recordingNotifier{}
+	config.JWTSecret = "test-secret"
+	config.Store = newStrictStore(t)
+	config.Notifier = notifier
+	if config.TokenDuration == 0 {
+		config.TokenDuration = time.Hour
+	}
+	s, err := NewService(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, notifier
+}
+
+// registerTestUser registers an active user with the password "password123"
+func registerTestUser(t *testing.T, s *Service, username, email string) uint {
+	t.Helper()
+	id, err := s.Register(User{Username: username, Email: email, FirstName: "Test", LastName: "User", IsActive: true}, "password123")
+	if err != nil {
+		t.Fatalf("registering %s: %v", username, err)
+	}
+	return id
+}
+
+func TestServiceRegisterAndLogin(t *testing.T) {
+	s, _ := newTestService(t, Config{})
+	id := registerTestUser(t, s, "alice", "alice@example.com")
+
+	_, err := s.Register(User{Username: "alice2", Email: "alice@example.com", FirstName: "A", LastName: "B"}, "password123")
+	if !errors.Is(err, ErrEmailExists) {
+		t.Errorf("duplicate email: got %v, want ErrEmailExists", err)
+	}
+
+	if _, _, err := s.Login("alice", "wrong-password"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("wrong password: got %v, want ErrInvalidPassword", err)
+	}
+	if _, _, err := s.Login("nobody", "password123"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("unknown user: got %v, want ErrUserNotFound", err)
+	}
+
+	user, token, err := s.Login("alice@example.com", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != id || user.LastLogin == nil {
+		t.Errorf("logged in user: %+v", user)
+	}
+	claims, err := s.ValidateAccessToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != id {
+		t.Errorf("token for user %d, want %d", claims.UserID, id)
+	}
+}
+
+func TestServiceRefreshSessionIsSingleUse(t *testing.T) {
+	s, _ := newTestService(t, Config{})
+	registerTestUser(t, s, "alice", "alice@example.com")
+	_, token, err := s.Login("alice", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair, err := s.NewTokenPair(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := s.RefreshSession(pair.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.RefreshToken == pair.RefreshToken {
+		t.Error("the refresh token was not rotated")
+	}
+	if _, err := s.RefreshSession(pair.RefreshToken); err == nil {
+		t.Error("a used refresh token was accepted again")
+	}
+}
+
+func TestServiceOTP(t *testing.T) {
+	s, _ := newTestService(t, Config{})
+	id := registerTestUser(t, s, "alice", "alice@example.com")
+
+	code, err := s.GenerateOTP(id, 6, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.VerifyOTP(id, code); err != nil || !ok {
+		t.Fatalf("first use: %v, %v", ok, err)
+	}
+	if ok, err := s.VerifyOTP(id, code); err != nil || ok {
+		t.Errorf("second use: %v, %v", ok, err)
+	}
+
+	// A code is burned once it has been guessed at too often
+	code, err = s.GenerateOTP(id, 6, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxOTPAttempts; i++ {
+		if ok, _ := s.VerifyOTP(id, "not-the-code"); ok {
+			t.Fatal("a wrong code was accepted")
+		}
+	}
+	if ok, _ := s.VerifyOTP(id, code); ok {
+		t.Error("the code still worked after too many wrong guesses")
+	}
+}
+
+func TestServicePasswordReset(t *testing.T) {
+	s, notifier := newTestService(t, Config{})
+	registerTestUser(t, s, "alice", "alice@example.com")
+	_, token, err := s.Login("alice", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RequestPasswordReset("nobody@example.com"); err != nil {
+		t.Errorf("unknown address: %v", err)
+	}
+	if err := s.RequestPasswordReset("alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	code := notifier.lastCode(t, "alice@example.com")
+
+	if err := s.ResetPasswordWithOTP("alice@example.com", "000000x", "new-password456"); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("wrong code: got %v, want ErrInvalidChallenge", err)
+	}
+	if err := s.ResetPasswordWithOTP("alice@example.com", code, "new-password456"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ResetPasswordWithOTP("alice@example.com", code, "other-password789"); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("reused code: got %v, want ErrInvalidChallenge", err)
+	}
+
+	if _, err := s.ValidateAccessToken(token); err == nil {
+		t.Error("a session survived the password reset")
+	}
+	if _, _, err := s.Login("alice", "new-password456"); err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+}
+
+func TestServicePasswordResetRateLimit(t *testing.T) {
+	s, _ := newTestService(t, Config{PasswordResetEmailLimit: RateLimit{Max: 2, Window: time.Hour}})
+	registerTestUser(t, s, "alice", "alice@example.com")
+
+	for i := 0; i < 2; i++ {
+		if err := s.RequestPasswordReset("alice@example.com"); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	if err := s.RequestPasswordReset("Alice@Example.com"); !errors.Is(err, ErrTooManyRequests) {
+		t.Errorf("request over the limit: got %v, want ErrTooManyRequests", err)
+	}
+}
+
+func TestServiceChangePassword(t *testing.T) {
+	s, _ := newTestService(t, Config{})
+	id := registerTestUser(t, s, "alice", "alice@example.com")
+
+	if err := s.ChangePassword(id, "wrong-password", "new-password456"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("wrong current password: got %v, want ErrInvalidPassword", err)
+	}
+	if err := s.ChangePassword(id, "password123", "new-password456"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Login("alice", "password123"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("login with the old password: %v", err)
+	}
+	if _, _, err := s.Login("alice", "new-password456"); err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+}
+
+func TestServiceDeleteRestoreAndPurge(t *testing.T) {
+	s, _ := newTestService(t, Config{})
+	id := registerTestUser(t, s, "alice", "alice@example.com")
+	_, token, err := s.Login("alice", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteUser(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateAccessToken(token); err == nil {
+		t.Error("a session survived deleting the user")
+	}
+	if _, err := s.GetUserByID(id); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("deleted user: got %v, want ErrUserNotFound", err)
+	}
+	if _, _, err := s.Login("alice", "password123"); err == nil {
+		t.Error("a deleted user logged in")
+	}
+
+	if err := s.RestoreUser(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Login("alice", "password123"); err != nil {
+		t.Errorf("login after restore: %v", err)
+	}
+
+	if err := s.DeleteUser(id); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.PurgeDeletedUsers(); err != nil || n != 0 {
+		t.Errorf("purged within retention: %d, %v", n, err)
+	}
+	s.config.DeletedUserRetention = -time.Second
+	if n, err := s.PurgeDeletedUsers(); err != nil || n != 1 {
+		t.Errorf("purged past retention: %d, %v", n, err)
+	}
+	if err := s.RestoreUser(id); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("restoring a purged user: got %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestServiceAccountDeletion(t *testing.T) {
+	s, _ := newTestService(t, Config{DeletionGracePeriod: time.Millisecond})
+	id := registerTestUser(t, s, "alice", "alice@example.com")
+
+	if _, err := s.RequestAccountDeletion(id, "wrong-password"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("wrong password: got %v, want ErrInvalidPassword", err)
+	}
+	if _, err := s.RequestAccountDeletion(id, "password123"); err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(http.MethodPost, "/login", nil)
+	if _, _, err := s.ForRequest(request).Login("alice", "wrong-password"); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatalf("failed login: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	erased, err := s.ProcessAccountDeletions()
+	if err != nil || erased != 1 {
+		t.Fatalf("erased %d, %v", erased, err)
+	}
+	user, err := s.GetUserByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email == "alice@example.com" || user.IsActive {
+		t.Errorf("user not anonymised: %+v", user)
+	}
+	if exists, err := s.UserExists(0, "alice"); err != nil || exists {
+		t.Errorf("the username is still taken: %v, %v", exists, err)
+	}
+
+	messages, err := s.config.Store.Outbox().ListForUser(s.context(), id)
+	if err != nil || len(messages) == 0 {
+		t.Fatalf("outbox: %v, %v", messages, err)
+	}
+	for _, message := range messages {
+		if strings.Contains(message.Payload, "alice") {
+			t.Errorf("outbox payload kept personal data: %s", message.Payload)
+		}
+	}
+	events, err := s.config.Store.AuditEvents().ListForUser(s.context(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		if event.IP != "" || event.UserAgent != "" {
+			t.Errorf("audit event %s kept client details: %+v", event.Action, event)
+		}
+	}
+}
+
+func TestServiceAccountDeletionOfDeletedUser(t *testing.T) {
+	s, _ := newTestService(t, Config{DeletionGracePeriod: time.Millisecond})
+	id := registerTestUser(t, s, "alice", "alice@example.com")
+	if _, err := s.RequestAccountDeletion(id, "password123"); err != nil {
+		t.Fatal(err)
+	}
+	// An administrator deleting the user must not keep them from being erased
+	if err := s.DeleteUser(id); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if erased, err := s.ProcessAccountDeletions(); err != nil || erased != 1 {
+		t.Fatalf("erased %d, %v", erased, err)
+	}
+	deleted, err := s.config.Store.Users().List(s.context(), UserQuery{Deleted: true, SortBy: "id", Limit: 10})
+	if err != nil || len(deleted) != 1 {
+		t.Fatalf("deleted users: %v, %v", deleted, err)
+	}
+	if deleted[0].Email == "alice@example.com" || deleted[0].Username == "alice" {
+		t.Errorf("user not anonymised: %+v", deleted[0])
+	}
+}
+
+func TestServiceSuperuserChange(t *testing.T) {
+	s, _ := newTestService(t, Config{})
+	id := registerTestUser(t, s, "alice", "alice@example.com")
+	token, err := s.GenerateJWT(&User{ID: id, Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sessionToken, err := s.Login("alice", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := s.GetUserByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.IsSuperuser = true
+	if err := s.UpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateAccessToken(sessionToken); err == nil {
+		t.Error("a session survived a change to is_superuser")
+	}
+
+	// A refreshed token carries the user's current claims, not the old token's
+	refreshed, err := s.RefreshJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.VerifyJWT(refreshed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claims.IsSuperuser {
+		t.Error("the refreshed token kept the old is_superuser claim")
+	}
+}
+
+func TestServiceAuditInsideAtomically(t *testing.T) {
+	s, _ := newTestService(t, Config{})
+	id := registerTestUser(t, s, "alice", "alice@example.com")
+	failure := errors.New("failure")
+
+	err := s.atomically(func(s *Service) error {
+		s.audit(AuditProfileUpdated, id, id, nil)
+		s.audit(AuditOTPFailed, id, id, nil)
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("got %v, want the error returned by fn", err)
+	}
+
+	events, err := s.ListAuditEvents(AuditFilter{TargetID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := map[string]bool{}
+	for _, event := range events {
+		actions[event.Action] = true
+	}
+	if actions[AuditProfileUpdated] {
+		t.Error("a change was audited although the transaction rolled back")
+	}
+	if !actions[AuditOTPFailed] {
+		t.Error("a failed attempt was not audited because the transaction rolled back")
+	}
+}
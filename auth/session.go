@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/db"
+)
+
+// sessionTouchInterval limits how often AuthMiddleware writes a session's last-seen time
+const sessionTouchInterval = time.Minute
+
+// Session is a login on one device. Tokens issued by Login carry the session ID
+// in their sid claim and stop working once the session is revoked.
+type Session struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index" json:"user_id"`
+	User       User       `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	DeviceName string     `gorm:"size:100" json:"device_name"`
+	UserAgent  string     `gorm:"size:255" json:"user_agent"`
+	IP         string     `gorm:"size:45" json:"ip"`
+	Location   string     `gorm:"size:255" json:"location"`
+	AMR        string     `gorm:"size:64" json:"-"` // Methods used at login, carried into refreshed tokens
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"index" json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+
+	RefreshTokenHash string `gorm:"size:64;index" json:"-"`
+}
+
+// createSession records a new login for the user from the current request
+func (s *Service) createSession(userID uint, amr []string) (*Session, error) {
+	now := time.Now()
+	session := Session{
+		UserID:     userID,
+		AMR:        strings.Join(amr, ","),
+		DeviceName: parseDeviceName(s.request.UserAgent),
+		UserAgent:  truncate(s.request.UserAgent, 255),
+		IP:         s.request.IP,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.config.TokenDuration),
+	}
+	if location, ok := s.geoIP.Lookup(s.request.IP); ok {
+		session.Location = truncate(location.String(), 255)
+	}
+
+	if err := s.store().Sessions().Create(s.context(), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListSessions calls ListSessionsContext with the service's context
+func (s *Service) ListSessions(userID uint) ([]Session, error) {
+	return s.ListSessionsContext(s.context(), userID)
+}
+
+// ListSessionsContext returns the user's active sessions, most recently used first
+func (s *Service) ListSessionsContext(ctx context.Context, userID uint) ([]Session, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	return s.store().Sessions().ListActive(s.context(), userID, time.Now())
+}
+
+// RevokeSession calls RevokeSessionContext with the service's context
+func (s *Service) RevokeSession(userID, sessionID uint) error {
+	return s.RevokeSessionContext(s.context(), userID, sessionID)
+}
+
+// RevokeSessionContext ends one of the user's sessions, invalidating its tokens
+func (s *Service) RevokeSessionContext(ctx context.Context, userID, sessionID uint) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	revoked, err := s.store().Sessions().Revoke(s.context(), userID, sessionID, time.Now())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrSessionNotFound
+	}
+
+	s.audit(AuditSessionRevoked, userID, userID, AuditMetadata{"session_id": sessionID})
+	return nil
+}
+
+// RevokeOtherSessions calls RevokeOtherSessionsContext with the service's context
+func (s *Service) RevokeOtherSessions(userID, currentSessionID uint) (int64, error) {
+	return s.RevokeOtherSessionsContext(s.context(), userID, currentSessionID)
+}
+
+// RevokeOtherSessionsContext ends every session of the user except currentSessionID
+// and returns how many were revoked
+func (s *Service) RevokeOtherSessionsContext(ctx context.Context, userID, currentSessionID uint) (int64, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	revoked, err := s.store().Sessions().RevokeOthers(s.context(), userID, currentSessionID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	if revoked > 0 {
+		s.audit(AuditSessionRevoked, userID, userID, AuditMetadata{"except_session_id": currentSessionID, "count": revoked})
+	}
+	return revoked, nil
+}
+
+// sessionActive reports whether the session behind the claims is still valid
+// and refreshes its last-seen time
+func (s *Service) sessionActive(claims *TokenClaims) (bool, error) {
+	// Read from the primary so a revoked session stops working at once
+	session, err := s.store().Sessions().GetActive(db.Primary(s.context()), claims.SessionID, claims.UserID, time.Now())
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if now := time.Now(); now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+		s.store().Sessions().Touch(s.context(), session.ID, now)
+	}
+	return true, nil
+}
@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/db"
+)
+
+// forEachStore runs a contract test against every Store implementation, so
+// the memory store used by the service and handler tests behaves like the
+// database it stands in for
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("gorm", func(t *testing.T) { test(t, newTestGormStore(t)) })
+	t.Run("memory", func(t *testing.T) { test(t, NewMemoryStore()) })
+}
+
+// newTestGormStore returns a GormStore on a migrated SQLite database of its own
+func newTestGormStore(t *testing.T) *GormStore {
+	t.Helper()
+	database, err := db.Open(context.Background(), db.Config{Driver: db.SQLite, Name: filepath.Join(t.TempDir(), "auth.db")})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close(database) })
+	if err := InitDB(database); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+	return NewGormStore(database)
+}
+
+// createTestUser stores an active user and returns it with its ID set
+func createTestUser(t *testing.T, store Store, username, email string) *User {
+	t.Helper()
+	user := &User{Username: username, Email: email, Password: "hash", FirstName: "Test", LastName: "User", IsActive: true}
+	if err := store.Users().Create(context.Background(), user); err != nil {
+		t.Fatalf("creating %s: %v", username, err)
+	}
+	return user
+}
+
+func TestStoreUniqueUsernameAndEmail(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		alice := createTestUser(t, store, "alice", "alice@example.com")
+
+		err := store.Users().Create(ctx, &User{Username: "alice", Email: "other@example.com"})
+		if !errors.Is(err, ErrUsernameExists) {
+			t.Errorf("duplicate username: got %v, want ErrUsernameExists", err)
+		}
+		err = store.Users().Create(ctx, &User{Username: "other", Email: "alice@example.com"})
+		if !errors.Is(err, ErrEmailExists) {
+			t.Errorf("duplicate email: got %v, want ErrEmailExists", err)
+		}
+
+		bob := createTestUser(t, store, "bob", "bob@example.com")
+		err = store.Users().Update(ctx, bob.ID, map[string]interface{}{"email": "alice@example.com"})
+		if !errors.Is(err, ErrEmailExists) {
+			t.Errorf("update to taken email: got %v, want ErrEmailExists", err)
+		}
+
+		// A soft-deleted user's username and email are free again, and
+		// restoring them fails while taken
+		if err := store.Users().SoftDelete(ctx, alice.ID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		createTestUser(t, store, "alice", "alice@example.com")
+		if err := store.Users().Restore(ctx, alice.ID); !errors.Is(err, ErrUsernameExists) && !errors.Is(err, ErrEmailExists) {
+			t.Errorf("restore over a taken username: got %v", err)
+		}
+	})
+}
+
+func TestStoreOTPSingleUse(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		user := createTestUser(t, store, "alice", "alice@example.com")
+		now := time.Now()
+
+		otp := &OTP{UserID: user.ID, OTPValue: "123456", Purpose: OTPPurposePasswordReset, ExpiresAt: now.Add(time.Minute)}
+		if err := store.OTPs().Create(ctx, otp); err != nil {
+			t.Fatal(err)
+		}
+
+		if ok, err := store.OTPs().Consume(ctx, user.ID, OTPPurposeGeneral, "123456", now); err != nil || ok {
+			t.Errorf("consumed for another purpose: %v, %v", ok, err)
+		}
+		if ok, err := store.OTPs().Consume(ctx, user.ID, OTPPurposePasswordReset, "654321", now); err != nil || ok {
+			t.Errorf("consumed with the wrong value: %v, %v", ok, err)
+		}
+		if ok, err := store.OTPs().Consume(ctx, user.ID, OTPPurposePasswordReset, "123456", now); err != nil || !ok {
+			t.Fatalf("first use: %v, %v", ok, err)
+		}
+		if ok, err := store.OTPs().Consume(ctx, user.ID, OTPPurposePasswordReset, "123456", now); err != nil || ok {
+			t.Errorf("second use: %v, %v", ok, err)
+		}
+
+		expired := &OTP{UserID: user.ID, OTPValue: "111111", Purpose: OTPPurposeGeneral, ExpiresAt: now.Add(-time.Second)}
+		if err := store.OTPs().Create(ctx, expired); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := store.OTPs().Consume(ctx, user.ID, OTPPurposeGeneral, "111111", now); err != nil || ok {
+			t.Errorf("consumed an expired code: %v, %v", ok, err)
+		}
+	})
+}
+
+func TestStoreOTPAttempts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		user := createTestUser(t, store, "alice", "alice@example.com")
+		now := time.Now()
+
+		otp := &OTP{UserID: user.ID, OTPValue: "123456", Purpose: OTPPurposeGeneral, ExpiresAt: now.Add(time.Minute)}
+		if err := store.OTPs().Create(ctx, otp); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if ok, err := store.OTPs().AddAttempt(ctx, user.ID, OTPPurposeGeneral, now, 2); err != nil || !ok {
+				t.Fatalf("attempt %d: %v, %v", i+1, ok, err)
+			}
+		}
+		if ok, err := store.OTPs().AddAttempt(ctx, user.ID, OTPPurposeGeneral, now, 2); err != nil || ok {
+			t.Errorf("attempt past the limit: %v, %v", ok, err)
+		}
+	})
+}
+
+func TestStoreTransactionRollback(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		failure := errors.New("failure")
+
+		var id uint
+		err := store.Transaction(ctx, func(tx Store) error {
+			user := createTestUser(t, tx, "alice", "alice@example.com")
+			id = user.ID
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Fatalf("got %v, want the error returned by fn", err)
+		}
+		if err := store.Users().Get(ctx, id, &User{}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("user created in a failed transaction: %v", err)
+		}
+
+		// A failed nested transaction only undoes its own changes
+		err = store.Transaction(ctx, func(tx Store) error {
+			createTestUser(t, tx, "bob", "bob@example.com")
+			if err := tx.Transaction(ctx, func(nested Store) error {
+				createTestUser(t, nested, "carol", "carol@example.com")
+				return failure
+			}); !errors.Is(err, failure) {
+				t.Errorf("nested transaction: got %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists, err := store.Users().Exists(ctx, 0, "bob"); err != nil || !exists {
+			t.Errorf("outer transaction lost: %v, %v", exists, err)
+		}
+		if exists, err := store.Users().Exists(ctx, 0, "carol"); err != nil || exists {
+			t.Errorf("failed nested transaction kept: %v, %v", exists, err)
+		}
+	})
+}
+
+func TestStoreSoftDeleteVisibility(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		alice := createTestUser(t, store, "alice", "alice@example.com")
+		deletedAt := time.Now().Add(-time.Hour)
+		if err := store.Users().SoftDelete(ctx, alice.ID, deletedAt); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Users().Get(ctx, alice.ID, &User{}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get: %v", err)
+		}
+		if err := store.Users().GetByLogin(ctx, "alice@example.com", &User{}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetByLogin: %v", err)
+		}
+		if exists, err := store.Users().Exists(ctx, alice.ID, ""); err != nil || exists {
+			t.Errorf("Exists: %v, %v", exists, err)
+		}
+		if err := store.Users().SoftDelete(ctx, alice.ID, time.Now()); !errors.Is(err, ErrNotFound) {
+			t.Errorf("deleting again: %v", err)
+		}
+
+		// Erasure reaches soft-deleted users
+		if err := store.Transaction(ctx, func(tx Store) error {
+			if _, err := tx.Users().LockUnscoped(ctx, alice.ID); err != nil {
+				return err
+			}
+			return tx.Users().UpdateUnscoped(ctx, alice.ID, map[string]interface{}{"first_name": "Erased"})
+		}); err != nil {
+			t.Errorf("unscoped lock and update: %v", err)
+		}
+
+		listed, err := store.Users().List(ctx, UserQuery{SortBy: "id", Limit: 10})
+		if err != nil || len(listed) != 0 {
+			t.Errorf("listed undeleted: %d, %v", len(listed), err)
+		}
+		listed, err = store.Users().List(ctx, UserQuery{Deleted: true, SortBy: "id", Limit: 10})
+		if err != nil || len(listed) != 1 || listed[0].ID != alice.ID || listed[0].FirstName != "Erased" {
+			t.Errorf("listed deleted: %v, %v", listed, err)
+		}
+
+		if err := store.Users().Purge(ctx, alice.ID, deletedAt); !errors.Is(err, ErrNotFound) {
+			t.Errorf("purged within retention: %v", err)
+		}
+		if err := store.Users().Restore(ctx, alice.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Users().Get(ctx, alice.ID, &User{}); err != nil {
+			t.Errorf("Get after restore: %v", err)
+		}
+		if err := store.Users().Purge(ctx, alice.ID, time.Now()); !errors.Is(err, ErrNotFound) {
+			t.Errorf("purged a restored user: %v", err)
+		}
+
+		if err := store.Users().SoftDelete(ctx, alice.ID, deletedAt); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Users().Purge(ctx, alice.ID, time.Now()); err != nil {
+			t.Fatalf("Purge: %v", err)
+		}
+		listed, err = store.Users().List(ctx, UserQuery{Deleted: true, SortBy: "id", Limit: 10})
+		if err != nil || len(listed) != 0 {
+			t.Errorf("listed purged: %d, %v", len(listed), err)
+		}
+	})
+}
+
+func TestStoreErasureLookups(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		for _, payload := range []string{`{"user_id":1,"email":"a@example.com"}`, `{"user_id":12,"email":"b@example.com"}`} {
+			if err := store.Outbox().Create(ctx, &OutboxMessage{EventType: EventUserRegistered, Payload: payload}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		messages, err := store.Outbox().ListForUser(ctx, 1)
+		if err != nil || len(messages) != 1 {
+			t.Fatalf("outbox messages for user 1: %v, %v", messages, err)
+		}
+		if err := store.Outbox().SetPayload(ctx, messages[0].ID, `{"user_id":1,"email":""}`); err != nil {
+			t.Fatal(err)
+		}
+		if message, err := store.Outbox().Get(ctx, messages[0].ID); err != nil || message.Payload != `{"user_id":1,"email":""}` {
+			t.Errorf("payload: %v, %v", message, err)
+		}
+
+		hashed := &AuditEvent{Action: AuditLoginFailed, IP: "192.0.2.1", Metadata: AuditMetadata{"username": "a_b"}, Hash: "hash"}
+		plain := &AuditEvent{Action: AuditLoginFailed, IP: "192.0.2.1", Metadata: AuditMetadata{"username": "a_b"}}
+		other := &AuditEvent{Action: AuditLoginFailed, Metadata: AuditMetadata{"username": "aXb"}}
+		for _, event := range []*AuditEvent{hashed, plain, other} {
+			if err := store.AuditEvents().Create(ctx, event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		events, err := store.AuditEvents().ListFailedLogins(ctx, "a_b")
+		if err != nil || len(events) != 2 {
+			t.Fatalf("failed logins: %v, %v", events, err)
+		}
+		for _, event := range events {
+			if err := store.AuditEvents().Scrub(ctx, event.ID, "", "", AuditMetadata{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		events, err = store.AuditEvents().ListFailedLogins(ctx, "a_b")
+		if err != nil || len(events) != 1 || events[0].ID != hashed.ID || events[0].IP == "" {
+			t.Errorf("after scrubbing, only the hashed event is unchanged: %v, %v", events, err)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// Authenticatable is implemented by user models the Service can work with.
+// Applications needing extra columns embed User in their own struct:
+//
+//	type Member struct {
+//		auth.User
+//		Phone      string `gorm:"size:20" json:"phone"`
+//		Department string `gorm:"size:50" json:"department"`
+//	}
+//
+// and set Config.UserModel to &Member{}. The embedded User provides AuthUser
+// and the "users" table name, so the extra columns live in the same table;
+// the application adds them with its own migrations.
+type Authenticatable interface {
+	AuthUser() *User
+}
+
+// AuthUser implements Authenticatable
+func (u *User) AuthUser() *User {
+	return u
+}
+
+// TableName keeps User, and every model embedding it, in the users table
+func (User) TableName() string {
+	return "users"
+}
+
+// LoadUser calls LoadUserContext with the service's context
+func (s *Service) LoadUser(userID uint, dest Authenticatable) error {
+	return s.LoadUserContext(s.context(), userID, dest)
+}
+
+// LoadUserContext fills dest, which must be the configured user model type or User,
+// with the user's row including any application-defined columns
+func (s *Service) LoadUserContext(ctx context.Context, userID uint, dest Authenticatable) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if err := s.store().Users().Get(s.context(), userID, dest); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// GetUserModel calls GetUserModelContext with the service's context
+func (s *Service) GetUserModel(userID uint) (Authenticatable, error) {
+	return s.GetUserModelContext(s.context(), userID)
+}
+
+// GetUserModelContext returns the user as a new instance of the configured user model
+func (s *Service) GetUserModelContext(ctx context.Context, userID uint) (Authenticatable, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	model := s.newUser()
+	if err := s.LoadUser(userID, model); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// newUser returns a new zero value of the configured user model
+func (s *Service) newUser() Authenticatable {
+	if s.config.UserModel == nil {
+		return &User{}
+	}
+	return newModelOf(s.config.UserModel)
+}
+
+// newModelOf allocates a new zero value of the same type as model
+func newModelOf(model Authenticatable) Authenticatable {
+	t := reflect.TypeOf(model)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return reflect.New(t).Interface().(Authenticatable)
+}
+
+// validateUserModel checks that a configured user model embeds User by value
+func validateUserModel(model Authenticatable) error {
+	if model == nil {
+		return nil
+	}
+	if newModelOf(model).AuthUser() == nil {
+		return errors.New("user model must embed auth.User by value")
+	}
+	return nil
+}
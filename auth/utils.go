@@ -3,12 +3,15 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"math/big"
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"golang.org/x/crypto/bcrypt"
@@ -23,8 +26,66 @@ func NewService(config Config) (*Service, error) {
 	if config.JWTSecret == "" {
 		return nil, errors.New("JWT secret is required")
 	}
-	if config.DB == nil {
-		return nil, errors.New("DB connection is required")
+	if config.Store == nil {
+		if config.DB == nil {
+			return nil, errors.New("DB connection or Store is required")
+		}
+		config.Store = NewGormStore(config.DB)
+	}
+	if err := validateUserModel(config.UserModel); err != nil {
+		return nil, err
+	}
+
+	if config.Notifier == nil {
+		config.Notifier = LogNotifier{}
+	}
+	if config.EmailChangeTTL <= 0 {
+		config.EmailChangeTTL = 24 * time.Hour
+	}
+	if config.DeletionGracePeriod <= 0 {
+		config.DeletionGracePeriod = 30 * 24 * time.Hour
+	}
+	if config.DeletedUserRetention <= 0 {
+		config.DeletedUserRetention = 30 * 24 * time.Hour
+	}
+	if config.ErasureMode == "" {
+		config.ErasureMode = ErasureAnonymize
+	}
+	if config.Hooks == nil {
+		config.Hooks = NewHooks()
+	}
+	if config.EventBus == nil {
+		config.EventBus = NewEventBus()
+	}
+	if config.ImpersonationDuration <= 0 {
+		config.ImpersonationDuration = 15 * time.Minute
+	}
+	if config.ElevatedTokenDuration <= 0 {
+		config.ElevatedTokenDuration = 15 * time.Minute
+	}
+	if config.CookieSameSite == 0 {
+		config.CookieSameSite = http.SameSiteLaxMode
+	}
+	if config.RefreshTokenDuration <= 0 {
+		config.RefreshTokenDuration = 30 * 24 * time.Hour
+	}
+	if config.RefreshCookiePath == "" {
+		config.RefreshCookiePath = refreshCookiePath
+	}
+	if config.TrustProxyHeaders && config.TrustedProxyHops <= 0 {
+		config.TrustedProxyHops = 1
+	}
+
+	config.LoginRisk = config.LoginRisk.withDefaults()
+	config.PasswordResetEmailLimit = config.PasswordResetEmailLimit.withDefaults(5, time.Hour)
+	config.PasswordResetIPLimit = config.PasswordResetIPLimit.withDefaults(20, time.Hour)
+
+	var geoIP *GeoIPDatabase
+	if config.GeoIPPath != "" {
+		var err error
+		if geoIP, err = LoadGeoIPDatabase(config.GeoIPPath); err != nil {
+			return nil, fmt.Errorf("loading GeoIP database: %w", err)
+		}
 	}
 	
 	validate := validator.New()
@@ -32,6 +93,7 @@ func NewService(config Config) (*Service, error) {
 	return &Service{
 		config:    config,
 		validator: validate,
+		geoIP:     geoIP,
 	}, nil
 }
 
@@ -71,6 +133,21 @@ func (s *Service) generateRandomOTP(length int) (string, error) {
 	return otp, nil
 }
 
+// generateSecureToken creates a random hex token from the given number of bytes
+func generateSecureToken(bytes int) (string, error) {
+	buf := make([]byte, bytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashToken returns the SHA-256 hex digest of a token so it can be stored safely
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // IsAuthenticated checks if a request is authenticated
 func (s *Service) IsAuthenticated(r *http.Request) bool {
 	_, err := GetUserFromContext(r.Context())
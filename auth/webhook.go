@@ -0,0 +1,416 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookSubscription is an outbound endpoint that receives signed event payloads
+type WebhookSubscription struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	URL        string    `gorm:"size:2048" json:"url"`
+	Secret     string    `gorm:"size:128" json:"-"`
+	EventTypes string    `gorm:"size:512" json:"event_types"` // Comma-separated, "*" for all events
+	Active     bool      `gorm:"default:true" json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WebhookDelivery tracks delivering one outbox message to one subscription
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	SubscriptionID uint       `gorm:"index" json:"subscription_id"`
+	OutboxID       uint       `gorm:"index" json:"outbox_id"`
+	EventType      EventType  `gorm:"size:64" json:"event_type"`
+	Status         string     `gorm:"size:16;index" json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"index" json:"next_attempt_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// WebhookAttempt is one HTTP request made for a delivery
+type WebhookAttempt struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	DeliveryID uint      `gorm:"index" json:"delivery_id"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `gorm:"size:1024" json:"error"`
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WebhookOptions tunes the webhook dispatcher
+type WebhookOptions struct {
+	PollInterval time.Duration // How often the outbox is polled, defaults to 5 seconds
+	MaxAttempts  int           // Attempts before a delivery is marked failed, defaults to 8
+	BaseBackoff  time.Duration // Delay before the first retry, doubled on each attempt, defaults to 30 seconds
+	MaxBackoff   time.Duration // Upper bound on the retry delay, defaults to 6 hours
+	Timeout      time.Duration // HTTP timeout per attempt, defaults to 10 seconds
+	BatchSize    int           // Rows handled per poll, defaults to 100
+}
+
+// webhookEnvelope is the JSON body posted to subscribers
+type webhookEnvelope struct {
+	ID         uint            `json:"id"`
+	Type       EventType       `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// CreateWebhookSubscription calls CreateWebhookSubscriptionContext with the service's context
+func (s *Service) CreateWebhookSubscription(endpoint string, eventTypes []EventType) (*WebhookSubscription, string, error) {
+	return s.CreateWebhookSubscriptionContext(s.context(), endpoint, eventTypes)
+}
+
+// CreateWebhookSubscriptionContext registers an endpoint for the given event types (all
+// events when empty) and returns it along with its generated signing secret
+func (s *Service) CreateWebhookSubscriptionContext(ctx context.Context, endpoint string, eventTypes []EventType) (*WebhookSubscription, string, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, "", ErrInvalidWebhookURL
+	}
+
+	types := "*"
+	if len(eventTypes) > 0 {
+		names := make([]string, len(eventTypes))
+		for i, t := range eventTypes {
+			if !isKnownEventType(t) {
+				return nil, "", ErrUnknownEventType
+			}
+			names[i] = string(t)
+		}
+		types = strings.Join(names, ",")
+	}
+
+	secret, err := generateSecureToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	subscription := WebhookSubscription{
+		URL:        endpoint,
+		Secret:     secret,
+		EventTypes: types,
+		Active:     true,
+	}
+	if err := s.store().Webhooks().CreateSubscription(s.context(), &subscription); err != nil {
+		return nil, "", err
+	}
+
+	return &subscription, secret, nil
+}
+
+// ListWebhookSubscriptions calls ListWebhookSubscriptionsContext with the service's context
+func (s *Service) ListWebhookSubscriptions() ([]WebhookSubscription, error) {
+	return s.ListWebhookSubscriptionsContext(s.context())
+}
+
+// ListWebhookSubscriptionsContext returns every webhook subscription
+func (s *Service) ListWebhookSubscriptionsContext(ctx context.Context) ([]WebhookSubscription, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	return s.store().Webhooks().ListSubscriptions(s.context(), false)
+}
+
+// DeleteWebhookSubscription calls DeleteWebhookSubscriptionContext with the service's context
+func (s *Service) DeleteWebhookSubscription(id uint) error {
+	return s.DeleteWebhookSubscriptionContext(s.context(), id)
+}
+
+// DeleteWebhookSubscriptionContext removes a subscription; its delivery log is kept
+func (s *Service) DeleteWebhookSubscriptionContext(ctx context.Context, id uint) error {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if err := s.store().Webhooks().DeleteSubscription(s.context(), id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrWebhookNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// ListWebhookDeliveries calls ListWebhookDeliveriesContext with the service's context
+func (s *Service) ListWebhookDeliveries(subscriptionID uint, status string, limit int) ([]WebhookDelivery, error) {
+	return s.ListWebhookDeliveriesContext(s.context(), subscriptionID, status, limit)
+}
+
+// ListWebhookDeliveriesContext returns deliveries, newest first, optionally filtered by
+// subscription and status
+func (s *Service) ListWebhookDeliveriesContext(ctx context.Context, subscriptionID uint, status string, limit int) ([]WebhookDelivery, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	if limit <= 0 || limit > maxUserPageSize {
+		limit = defaultUserPageSize
+	}
+
+	return s.store().Webhooks().ListDeliveries(s.context(), subscriptionID, status, limit)
+}
+
+// ListWebhookAttempts calls ListWebhookAttemptsContext with the service's context
+func (s *Service) ListWebhookAttempts(deliveryID uint) ([]WebhookAttempt, error) {
+	return s.ListWebhookAttemptsContext(s.context(), deliveryID)
+}
+
+// ListWebhookAttemptsContext returns every HTTP attempt made for a delivery
+func (s *Service) ListWebhookAttemptsContext(ctx context.Context, deliveryID uint) ([]WebhookAttempt, error) {
+	s, cancel := s.scope(ctx)
+	defer cancel()
+
+	return s.store().Webhooks().ListAttempts(s.context(), deliveryID)
+}
+
+// StartWebhookDispatcher polls the outbox, fans messages out to matching
+// subscriptions and delivers them with retries until stop is called
+func (s *Service) StartWebhookDispatcher(opts WebhookOptions) (stop func()) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 5 * time.Second
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = 30 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 6 * time.Hour
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+
+	client := &http.Client{Timeout: opts.Timeout}
+	ticker := time.NewTicker(opts.PollInterval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.fanOutOutbox(opts.BatchSize); err != nil {
+					log.Printf("Webhook outbox fan-out failed: %v", err)
+				}
+				if err := s.deliverDueWebhooks(client, opts); err != nil {
+					log.Printf("Webhook delivery run failed: %v", err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// fanOutOutbox turns unprocessed outbox messages into pending deliveries
+func (s *Service) fanOutOutbox(batchSize int) error {
+	return s.transaction(func(tx Store) error {
+		messages, err := tx.Outbox().ClaimUnprocessed(s.context(), batchSize)
+		if err != nil || len(messages) == 0 {
+			return err
+		}
+
+		subscriptions, err := tx.Webhooks().ListSubscriptions(s.context(), true)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, message := range messages {
+			for _, subscription := range subscriptions {
+				if !subscription.matches(message.EventType) {
+					continue
+				}
+				delivery := WebhookDelivery{
+					SubscriptionID: subscription.ID,
+					OutboxID:       message.ID,
+					EventType:      message.EventType,
+					Status:         DeliveryPending,
+					NextAttemptAt:  now,
+				}
+				if err := tx.Webhooks().CreateDelivery(s.context(), &delivery); err != nil {
+					return err
+				}
+			}
+			if err := tx.Outbox().MarkProcessed(s.context(), message.ID, now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// deliverDueWebhooks claims pending deliveries whose retry time has come and sends them
+func (s *Service) deliverDueWebhooks(client *http.Client, opts WebhookOptions) error {
+	// Lease the batch so other dispatchers skip it while the HTTP requests are
+	// in flight
+	lease := time.Now().Add(opts.Timeout + time.Minute)
+	due, err := s.store().Webhooks().LeaseDue(s.context(), time.Now(), opts.BatchSize, lease)
+	if err != nil {
+		return err
+	}
+
+	for i := range due {
+		s.attemptDelivery(client, opts, &due[i])
+	}
+
+	return nil
+}
+
+// attemptDelivery makes one HTTP attempt and records the outcome
+func (s *Service) attemptDelivery(client *http.Client, opts WebhookOptions, delivery *WebhookDelivery) {
+	subscription, err := s.store().Webhooks().GetSubscription(s.context(), delivery.SubscriptionID)
+	if err != nil {
+		// The subscription was deleted; give up on the delivery
+		delivery.Status = DeliveryFailed
+		s.store().Webhooks().UpdateDelivery(s.context(), delivery)
+		return
+	}
+	message, err := s.store().Outbox().Get(s.context(), delivery.OutboxID)
+	if err != nil {
+		delivery.Status = DeliveryFailed
+		s.store().Webhooks().UpdateDelivery(s.context(), delivery)
+		return
+	}
+
+	body, _ := json.Marshal(webhookEnvelope{
+		ID:         message.ID,
+		Type:       message.EventType,
+		OccurredAt: message.CreatedAt,
+		Data:       json.RawMessage(message.Payload),
+	})
+
+	attempt := WebhookAttempt{DeliveryID: delivery.ID, Attempt: delivery.Attempts + 1}
+	started := time.Now()
+	statusCode, sendErr := sendWebhook(client, subscription, message, body)
+	attempt.DurationMS = time.Since(started).Milliseconds()
+	attempt.StatusCode = statusCode
+	if sendErr != nil {
+		attempt.Error = truncate(sendErr.Error(), 1024)
+	}
+
+	delivery.Attempts = attempt.Attempt
+	switch {
+	case sendErr == nil:
+		now := time.Now()
+		delivery.Status = DeliverySucceeded
+		delivery.DeliveredAt = &now
+	case attempt.Attempt >= opts.MaxAttempts:
+		delivery.Status = DeliveryFailed
+	default:
+		delivery.NextAttemptAt = time.Now().Add(webhookBackoff(attempt.Attempt, opts.BaseBackoff, opts.MaxBackoff))
+	}
+
+	err = s.transaction(func(tx Store) error {
+		if err := tx.Webhooks().CreateAttempt(s.context(), &attempt); err != nil {
+			return err
+		}
+		return tx.Webhooks().UpdateDelivery(s.context(), delivery)
+	})
+	if err != nil {
+		log.Printf("Failed to record webhook attempt for delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// sendWebhook posts a signed payload and treats any non-2xx response as a failure
+func sendWebhook(client *http.Client, subscription *WebhookSubscription, message *OutboxMessage, body []byte) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", strconv.FormatUint(uint64(message.ID), 10))
+	req.Header.Set("X-Webhook-Event", string(message.EventType))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhookPayload(subscription.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhookPayload computes the hex HMAC-SHA256 of "timestamp.body". Receivers
+// recompute it with their secret and compare against X-Webhook-Signature.
+func SignWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the exponential retry delay with up to 20% jitter
+func webhookBackoff(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay + jitter
+}
+
+// isKnownEventType reports whether the Service emits the event type
+func isKnownEventType(eventType EventType) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the subscription wants the event type
+func (w *WebhookSubscription) matches(eventType EventType) bool {
+	for _, t := range strings.Split(w.EventTypes, ",") {
+		if t = strings.TrimSpace(t); t == "*" || t == string(eventType) {
+			return true
+		}
+	}
+	return false
+}
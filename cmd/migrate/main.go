@@ -0,0 +1,142 @@
+// Command migrate applies, reverts and generates database migrations.
+//
+//	migrate [flags] up              apply pending migrations
+//	migrate [flags] down [N]        revert the last N migrations (default 1)
+//	migrate [flags] status          list migrations and whether they are applied
+//	migrate [flags] diff NAME       write a migration for the difference between
+//	                                the auth models and the database
+//
+// The database is configured like the server, through DB_DRIVER, DATABASE_URL
+// and the DB_* variables. Migrations are the auth package's own plus the SQL
+// files in -dir, which defaults to MIGRATIONS_DIR or "migrations".
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+	"github.com/rb4807/Golang-Utlis-Postgresql/db"
+)
+
+func main() {
+	dir := flag.String("dir", db.MigrationsDir(), "directory of SQL migrations, defaults to MIGRATIONS_DIR")
+	dryRun := flag.Bool("dry-run", false, "print the SQL instead of running it")
+	allowModified := flag.Bool("allow-modified", false, "run even if an applied migration has been edited")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] up | down [N] | status | diff NAME\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found (proceeding with system env variables)")
+	}
+	config, err := db.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid database configuration: %v", err)
+	}
+	database, err := db.Connect(context.Background(), config)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	if flag.Arg(0) == "diff" {
+		if flag.NArg() != 2 {
+			log.Fatal("diff needs a migration name, e.g. diff add_user_timezone")
+		}
+		statements, err := db.Diff(database, auth.Models()...)
+		if err != nil {
+			log.Fatalf("Diff failed: %v", err)
+		}
+		if len(statements) == 0 {
+			fmt.Println("The database already matches the models")
+			return
+		}
+		up, down, err := db.WriteMigrationFiles(*dir, flag.Arg(1), config.DriverName(), statements, db.ReverseStatements(statements))
+		if err != nil {
+			log.Fatalf("Writing migration failed: %v", err)
+		}
+		fmt.Printf("Wrote %s\nWrote %s\nReview both files before applying them.\n", up, down)
+		return
+	}
+
+	migrations, err := auth.Migrations(config.DriverName())
+	if err != nil {
+		log.Fatalf("Loading auth migrations failed: %v", err)
+	}
+	sqlMigrations, err := db.LoadSQLMigrations(os.DirFS(*dir), ".", config.DriverName())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Loading migrations from %s failed: %v", *dir, err)
+	}
+	migrations = append(migrations, sqlMigrations...)
+
+	migrator, err := db.NewMigrator(database, migrations, db.MigratorOptions{
+		DryRun:                *dryRun,
+		Output:                os.Stdout,
+		AllowChecksumMismatch: *allowModified,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch flag.Arg(0) {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if !*dryRun {
+			fmt.Printf("Applied %d migration(s)\n", applied)
+		}
+
+	case "down":
+		steps := 1
+		if flag.NArg() > 1 {
+			if steps, err = strconv.Atoi(flag.Arg(1)); err != nil || steps < 1 {
+				log.Fatalf("Invalid number of migrations to revert: %s", flag.Arg(1))
+			}
+		}
+		reverted, err := migrator.Down(steps)
+		if err != nil {
+			log.Fatalf("Revert failed: %v", err)
+		}
+		if !*dryRun {
+			fmt.Printf("Reverted %d migration(s)\n", reverted)
+		}
+
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if status.ChecksumMismatch {
+				state += " (modified since applied)"
+			}
+			if status.Unknown {
+				state += " (not in this build)"
+			}
+			fmt.Printf("%d  %-40s %s\n", status.Version, status.Name, state)
+		}
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,542 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+	"github.com/rb4807/Golang-Utlis-Postgresql/dto"
+	"github.com/rb4807/Golang-Utlis-Postgresql/middleware"
+	"github.com/rb4807/Golang-Utlis-Postgresql/utils"
+)
+
+func AdminListUsers(authService *auth.Service) http.HandlerFunc {
+	return listUsers(authService, false)
+}
+
+func AdminListDeletedUsers(authService *auth.Service) http.HandlerFunc {
+	return listUsers(authService, true)
+}
+
+// listUsers serves a page of current or deleted users from the query parameters
+func listUsers(authService *auth.Service, deleted bool) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		filter := auth.UserFilter{
+			Search:   query.Get("search"),
+			SortBy:   query.Get("sort_by"),
+			SortDesc: query.Get("order") == "desc",
+			Cursor:   query.Get("cursor"),
+			Deleted:  deleted,
+		}
+
+		var err error
+		if filter.IsActive, err = parseOptionalBool(query.Get("is_active")); err != nil {
+			utils.SendJSONError(w, "is_active must be true or false", http.StatusBadRequest)
+			return
+		}
+		if filter.IsSuperuser, err = parseOptionalBool(query.Get("is_superuser")); err != nil {
+			utils.SendJSONError(w, "is_superuser must be true or false", http.StatusBadRequest)
+			return
+		}
+		if filter.DateJoinedFrom, err = parseOptionalTime(query.Get("date_joined_from")); err != nil {
+			utils.SendJSONError(w, "date_joined_from must be a date (YYYY-MM-DD) or RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		if filter.DateJoinedTo, err = parseOptionalTime(query.Get("date_joined_to")); err != nil {
+			utils.SendJSONError(w, "date_joined_to must be a date (YYYY-MM-DD) or RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		if limit := query.Get("limit"); limit != "" {
+			if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 {
+				utils.SendJSONError(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		page, err := authService.ListUsersContext(r.Context(), filter)
+		if err != nil {
+			switch {
+			case errors.Is(err, auth.ErrInvalidSortField):
+				utils.SendJSONError(w, "sort_by must be one of id, username, email, date_joined", http.StatusBadRequest)
+			case errors.Is(err, auth.ErrInvalidCursor):
+				utils.SendJSONError(w, "Invalid cursor", http.StatusBadRequest)
+			default:
+				utils.SendJSONError(w, "Failed to list users", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet}, handler)
+}
+
+func AdminGetUser(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		userID, err := parseUserID(r)
+		if err != nil {
+			utils.SendJSONError(w, "A valid user_id query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		user, err := authService.GetUserByIDContext(r.Context(), userID)
+		if err != nil {
+			sendUserLookupError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet}, handler)
+}
+
+func AdminCreateUser(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var req dto.AdminCreateUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.SendJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Password == "" {
+			utils.SendJSONError(w, "Password is required", http.StatusBadRequest)
+			return
+		}
+
+		user := auth.User{
+			Username:    req.Username,
+			Email:       req.Email,
+			FirstName:   req.FirstName,
+			LastName:    req.LastName,
+			IsActive:    req.IsActive == nil || *req.IsActive,
+			IsSuperuser: req.IsSuperuser,
+		}
+
+		created, err := authService.ForRequest(r).CreateUser(user, req.Password)
+		if err != nil {
+			if sendHookError(w, err) {
+				return
+			}
+			switch {
+			case errors.Is(err, auth.ErrEmailExists):
+				utils.SendJSONError(w, "Email already exists", http.StatusBadRequest)
+			case errors.Is(err, auth.ErrUsernameExists):
+				utils.SendJSONError(w, "Username already taken", http.StatusBadRequest)
+			default:
+				utils.SendJSONError(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+func AdminUpdateUser(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := parseUserID(r)
+		if err != nil {
+			utils.SendJSONError(w, "A valid user_id query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		var req dto.AdminUpdateUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.SendJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if userID == claims.UserID && ((req.IsActive != nil && !*req.IsActive) || (req.IsSuperuser != nil && !*req.IsSuperuser)) {
+			utils.SendJSONError(w, "You cannot deactivate or demote your own account", http.StatusBadRequest)
+			return
+		}
+
+		user, err := authService.GetUserByIDContext(r.Context(), userID)
+		if err != nil {
+			sendUserLookupError(w, err)
+			return
+		}
+
+		if req.Username != nil {
+			user.Username = *req.Username
+		}
+		if req.Email != nil {
+			if !auth.ValidateEmail(*req.Email) {
+				utils.SendJSONError(w, "Invalid email address", http.StatusBadRequest)
+				return
+			}
+			user.Email = *req.Email
+		}
+		if req.FirstName != nil {
+			user.FirstName = *req.FirstName
+		}
+		if req.LastName != nil {
+			user.LastName = *req.LastName
+		}
+		if req.IsActive != nil {
+			user.IsActive = *req.IsActive
+		}
+		if req.IsSuperuser != nil {
+			user.IsSuperuser = *req.IsSuperuser
+		}
+
+		if err := authService.ForRequest(r).UpdateUser(user); err != nil {
+			switch {
+			case errors.Is(err, auth.ErrEmailExists):
+				utils.SendJSONError(w, "Email already exists", http.StatusBadRequest)
+			case errors.Is(err, auth.ErrUsernameExists):
+				utils.SendJSONError(w, "Username already taken", http.StatusBadRequest)
+			default:
+				utils.SendJSONError(w, "Failed to update user", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPatch, http.MethodPut}, handler)
+}
+
+func AdminDeactivateUser(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := parseUserID(r)
+		if err != nil {
+			utils.SendJSONError(w, "A valid user_id query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		if userID == claims.UserID {
+			utils.SendJSONError(w, "You cannot deactivate your own account", http.StatusBadRequest)
+			return
+		}
+
+		if err := authService.ForRequest(r).DeactivateUser(userID); err != nil {
+			sendUserLookupError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "User deactivated successfully"})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+func AdminDeleteUser(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := parseUserID(r)
+		if err != nil {
+			utils.SendJSONError(w, "A valid user_id query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		if userID == claims.UserID {
+			utils.SendJSONError(w, "You cannot delete your own account", http.StatusBadRequest)
+			return
+		}
+
+		if err := authService.ForRequest(r).DeleteUser(userID); err != nil {
+			sendUserLookupError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "User deleted successfully"})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodDelete}, handler)
+}
+
+func AdminRestoreUser(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		userID, err := parseUserID(r)
+		if err != nil {
+			utils.SendJSONError(w, "A valid user_id query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := authService.ForRequest(r).RestoreUser(userID); err != nil {
+			switch {
+			case errors.Is(err, auth.ErrUserNotFound):
+				utils.SendJSONError(w, "Deleted user not found", http.StatusNotFound)
+			case errors.Is(err, auth.ErrEmailExists):
+				utils.SendJSONError(w, "Email already exists", http.StatusBadRequest)
+			case errors.Is(err, auth.ErrUsernameExists):
+				utils.SendJSONError(w, "Username already taken", http.StatusBadRequest)
+			default:
+				utils.SendJSONError(w, "Failed to restore user", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "User restored successfully"})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+func AdminPurgeDeletedUsers(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		purged, err := authService.ForRequest(r).PurgeDeletedUsers()
+		if err != nil {
+			utils.SendJSONError(w, "Failed to purge deleted users", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"purged": purged})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+// parseUserID reads the user_id query parameter
+func parseUserID(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, errors.New("invalid user_id")
+	}
+	return uint(id), nil
+}
+
+// parseOptionalBool parses a boolean query parameter, returning nil when it is absent
+func parseOptionalBool(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// parseOptionalTime parses a date or RFC3339 query parameter, returning nil when it is absent
+func parseOptionalTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// sendUserLookupError writes the response for errors returned when addressing a single user
+func sendUserLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrUserNotFound) {
+		utils.SendJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	utils.SendJSONError(w, "Error retrieving user", http.StatusInternalServerError)
+}
+
+func AdminImpersonateUser(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// Impersonation cannot be chained from an impersonation token
+		if claims.IsImpersonated() {
+			utils.SendJSONError(w, "This action is not allowed while impersonating a user", http.StatusForbidden)
+			return
+		}
+
+		userID, err := parseUserID(r)
+		if err != nil {
+			utils.SendJSONError(w, "A valid user_id query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		token, err := authService.ForRequest(r).Impersonate(claims.UserID, userID)
+		if err != nil {
+			switch {
+			case errors.Is(err, auth.ErrUserNotFound):
+				utils.SendJSONError(w, "User not found", http.StatusNotFound)
+			case errors.Is(err, auth.ErrCannotImpersonate):
+				utils.SendJSONError(w, "This user cannot be impersonated", http.StatusForbidden)
+			case errors.Is(err, auth.ErrNotAuthorized):
+				utils.SendJSONError(w, "Admin access required", http.StatusForbidden)
+			default:
+				utils.SendJSONError(w, "Failed to start impersonation", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		verified, err := authService.VerifyJWT(token)
+		if err != nil {
+			utils.SendJSONError(w, "Failed to start impersonation", http.StatusInternalServerError)
+			return
+		}
+
+		response := dto.TokenResponse{
+			Token:     token,
+			ExpiresAt: time.Unix(verified.ExpiresAt, 0),
+			UserID:    uint64(verified.UserID),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+func AdminListImpersonations(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		var impersonatorID, targetID uint64
+		var limit int
+		var err error
+		if v := query.Get("impersonator_id"); v != "" {
+			if impersonatorID, err = strconv.ParseUint(v, 10, 64); err != nil {
+				utils.SendJSONError(w, "impersonator_id must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := query.Get("target_id"); v != "" {
+			if targetID, err = strconv.ParseUint(v, 10, 64); err != nil {
+				utils.SendJSONError(w, "target_id must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := query.Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+				utils.SendJSONError(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		sessions, err := authService.ListImpersonationsContext(r.Context(), uint(impersonatorID), uint(targetID), limit)
+		if err != nil {
+			utils.SendJSONError(w, "Failed to list impersonations", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"impersonations": sessions})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet}, handler)
+}
+
+func AdminListAuditEvents(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		filter := auth.AuditFilter{Action: query.Get("action")}
+
+		ids := []struct {
+			param string
+			dest  *uint
+		}{
+			{"actor_id", &filter.ActorID},
+			{"target_id", &filter.TargetID},
+			{"before_id", &filter.BeforeID},
+		}
+		for _, id := range ids {
+			if v := query.Get(id.param); v != "" {
+				parsed, err := strconv.ParseUint(v, 10, 64)
+				if err != nil {
+					utils.SendJSONError(w, id.param+" must be a positive integer", http.StatusBadRequest)
+					return
+				}
+				*id.dest = uint(parsed)
+			}
+		}
+
+		var err error
+		if filter.From, err = parseOptionalTime(query.Get("from")); err != nil {
+			utils.SendJSONError(w, "from must be a date (YYYY-MM-DD) or RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		if filter.To, err = parseOptionalTime(query.Get("to")); err != nil {
+			utils.SendJSONError(w, "to must be a date (YYYY-MM-DD) or RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		if v := query.Get("limit"); v != "" {
+			if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+				utils.SendJSONError(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		events, err := authService.ListAuditEventsContext(r.Context(), filter)
+		if err != nil {
+			utils.SendJSONError(w, "Failed to list audit events", http.StatusInternalServerError)
+			return
+		}
+
+		response := map[string]interface{}{"events": events}
+		if len(events) > 0 {
+			response["next_before_id"] = events[len(events)-1].ID
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet}, handler)
+}
+
+func AdminVerifyAuditChain(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		brokenID, err := authService.VerifyAuditChainContext(r.Context())
+		if err != nil && !errors.Is(err, auth.ErrAuditChainBroken) {
+			utils.SendJSONError(w, "Failed to verify audit chain", http.StatusInternalServerError)
+			return
+		}
+
+		response := map[string]interface{}{"valid": err == nil}
+		if err != nil {
+			response["broken_at_id"] = brokenID
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet}, handler)
+}
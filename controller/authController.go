@@ -2,6 +2,7 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
@@ -27,8 +28,11 @@ func UserRegister(authService *auth.Service) http.HandlerFunc {
 			IsActive:  true,
 		}
 
-		userID, err := authService.Register(user, req.Password)
+		userID, err := authService.ForRequest(r).Register(user, req.Password)
 		if err != nil {
+			if sendHookError(w, err) {
+				return
+			}
 			switch {
 			case err == auth.ErrEmailExists:
 				utils.SendJSONError(w, "Email already exists", http.StatusBadRequest)
@@ -63,13 +67,18 @@ func UserLogin(authService *auth.Service) http.HandlerFunc {
 			return
 		}
 
-		user, token, err := authService.Login(req.Username, req.Password)
+		user, token, err := authService.ForRequest(r).Login(req.Username, req.Password)
 		if err != nil {
+			if sendHookError(w, err) || sendStepUpRequired(w, err) {
+				return
+			}
 			switch err {
+			case auth.ErrLoginBlocked:
+				utils.SendJSONError(w, "This sign-in looks suspicious and was blocked. Please try again later.", http.StatusForbidden, "login_blocked")
 			case auth.ErrUserNotFound:
 				utils.SendJSONError(w, "No account found with these details", http.StatusUnauthorized)
-			// case auth.ErrUserInactive:
-			//     utils.SendJSONError(w, "Account is inactive. Please contact support.", http.StatusForbidden)
+			case auth.ErrUserInactive:
+				utils.SendJSONError(w, "Account is inactive. Please contact support.", http.StatusForbidden)
 			case auth.ErrInvalidPassword:
 				utils.SendJSONError(w, "Invalid password", http.StatusUnauthorized)
 			default:
@@ -78,11 +87,109 @@ func UserLogin(authService *auth.Service) http.HandlerFunc {
 			return
 		}
 
-		expiresAt := time.Now().Add(24 * time.Hour)
+		sendLoginResponse(w, r, authService, user.ID, token)
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+func VerifyLoginChallenge(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var req dto.LoginChallengeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.SendJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.ChallengeToken == "" || req.OTP == "" {
+			utils.SendJSONError(w, "Challenge token and verification code are required", http.StatusBadRequest)
+			return
+		}
+
+		user, token, err := authService.ForRequest(r).CompleteLoginChallenge(req.ChallengeToken, req.OTP)
+		if err != nil {
+			if sendHookError(w, err) {
+				return
+			}
+			switch err {
+			case auth.ErrInvalidChallenge:
+				utils.SendJSONError(w, "Invalid or expired verification code", http.StatusUnauthorized)
+			case auth.ErrUserInactive:
+				utils.SendJSONError(w, "Account is inactive. Please contact support.", http.StatusForbidden)
+			default:
+				utils.SendJSONError(w, "Login failed", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		sendLoginResponse(w, r, authService, user.ID, token)
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+func Reauthenticate(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req dto.ReauthenticateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.SendJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.SendOTP {
+			if err := authService.ForRequest(r).RequestReauthenticationOTP(claims); err != nil {
+				if errors.Is(err, auth.ErrImpersonationForbidden) {
+					utils.SendJSONError(w, "This action is not allowed while impersonating a user", http.StatusForbidden)
+					return
+				}
+				utils.SendJSONError(w, "Failed to send verification code", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]string{"message": "A verification code has been sent to your email address"})
+			return
+		}
+
+		if req.Password == "" && req.OTP == "" {
+			utils.SendJSONError(w, "Password or verification code is required", http.StatusBadRequest)
+			return
+		}
+
+		token, expiresAt, err := authService.ForRequest(r).Reauthenticate(claims, req.Password, req.OTP)
+		if err != nil {
+			switch err {
+			case auth.ErrInvalidPassword:
+				utils.SendJSONError(w, "Invalid password", http.StatusUnauthorized)
+			case auth.ErrInvalidChallenge:
+				utils.SendJSONError(w, "Invalid or expired verification code", http.StatusUnauthorized)
+			case auth.ErrImpersonationForbidden:
+				utils.SendJSONError(w, "This action is not allowed while impersonating a user", http.StatusForbidden)
+			case auth.ErrUserInactive:
+				utils.SendJSONError(w, "Account is inactive. Please contact support.", http.StatusForbidden)
+			default:
+				utils.SendJSONError(w, "Reauthentication failed", http.StatusInternalServerError)
+			}
+			return
+		}
+
 		response := dto.TokenResponse{
 			Token:     token,
 			ExpiresAt: expiresAt,
-			UserID:    uint64(user.ID),
+			UserID:    uint64(claims.UserID),
+		}
+
+		// Browser clients receive the elevated token in place of their access cookie
+		if authService.CookieMode() {
+			authService.SetAccessCookie(w, token, expiresAt)
+			response.Token = ""
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -93,6 +200,73 @@ func UserLogin(authService *auth.Service) http.HandlerFunc {
 	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
 }
 
+func RefreshToken(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if !authService.CookieMode() {
+			utils.SendJSONError(w, "Refresh tokens are only issued in cookie mode", http.StatusNotFound)
+			return
+		}
+
+		cookie, err := r.Cookie(auth.RefreshTokenCookie)
+		if err != nil {
+			utils.SendJSONError(w, "Refresh token is required", http.StatusUnauthorized)
+			return
+		}
+
+		pair, err := authService.ForRequest(r).RefreshSession(cookie.Value)
+		if err != nil {
+			switch err {
+			case auth.ErrInvalidToken, auth.ErrUserNotFound, auth.ErrUserInactive:
+				authService.ClearAuthCookies(w)
+				utils.SendJSONError(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+			default:
+				utils.SendJSONError(w, "Failed to refresh session", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		csrfToken, err := authService.SetAuthCookies(w, pair)
+		if err != nil {
+			utils.SendJSONError(w, "Failed to refresh session", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(dto.TokenResponse{
+			ExpiresAt: pair.AccessExpiresAt,
+			UserID:    uint64(pair.UserID),
+			CSRFToken: csrfToken,
+		})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+func UserLogout(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := authService.ForRequest(r).Logout(claims); err != nil {
+			utils.SendJSONError(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
+
+		if authService.CookieMode() {
+			authService.ClearAuthCookies(w)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Logged out"})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
 func AdminHandler(w http.ResponseWriter, r *http.Request) {
 	claims, _ := auth.GetUserFromContext(r.Context())
 
@@ -114,3 +288,81 @@ func SuperuserHandler(w http.ResponseWriter, r *http.Request) {
 		"username": claims.Username,
 	})
 }
+
+// sendHookError writes the response for an operation vetoed by an auth hook and
+// reports whether err was such a veto
+func sendHookError(w http.ResponseWriter, err error) bool {
+	var hookErr *auth.HookError
+	if !errors.As(err, &hookErr) {
+		return false
+	}
+
+	status := hookErr.Status
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	utils.SendJSONError(w, hookErr.Message, status, hookErr.Code)
+	return true
+}
+
+// sendStepUpRequired writes the response asking the client to complete a login
+// challenge and reports whether err required one
+func sendStepUpRequired(w http.ResponseWriter, err error) bool {
+	var stepUp *auth.StepUpRequiredError
+	if !errors.As(err, &stepUp) {
+		return false
+	}
+
+	signals := make([]string, len(stepUp.Signals))
+	for i, signal := range stepUp.Signals {
+		signals[i] = string(signal)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(dto.StepUpResponse{
+		Message:        "A verification code has been sent to your email address",
+		Error:          "step_up_required",
+		ChallengeToken: stepUp.ChallengeToken,
+		ExpiresAt:      stepUp.ExpiresAt,
+		Signals:        signals,
+	})
+	return true
+}
+
+// sendLoginResponse completes a successful login. In cookie mode the tokens are
+// set as HttpOnly cookies and only the CSRF token is returned in the body.
+func sendLoginResponse(w http.ResponseWriter, r *http.Request, authService *auth.Service, userID uint, token string) {
+	claims, err := authService.VerifyJWT(token)
+	if err != nil {
+		utils.SendJSONError(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+	response := dto.TokenResponse{
+		Token:     token,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		UserID:    uint64(userID),
+	}
+
+	if authService.CookieMode() {
+		pair, err := authService.NewTokenPairContext(r.Context(), token)
+		if err != nil {
+			utils.SendJSONError(w, "Login failed", http.StatusInternalServerError)
+			return
+		}
+		csrfToken, err := authService.SetAuthCookies(w, pair)
+		if err != nil {
+			utils.SendJSONError(w, "Login failed", http.StatusInternalServerError)
+			return
+		}
+		response = dto.TokenResponse{
+			ExpiresAt: pair.AccessExpiresAt,
+			UserID:    uint64(userID),
+			CSRFToken: csrfToken,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,221 @@
+package controller_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+	"github.com/rb4807/Golang-Utlis-Postgresql/router"
+)
+
+// testAPI serves the JSON API routes from a Service on a memory store
+type testAPI struct {
+	t       *testing.T
+	handler http.Handler
+	store   *auth.MemoryStore
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	store := auth.NewMemoryStore()
+	authService, err := auth.NewService(auth.Config{
+		JWTSecret:     "test-secret",
+		TokenDuration: time.Hour,
+		Store:         store,
+		Notifier:      discardNotifier{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	router.AuthRoutes(mux, authService)
+	router.UserRoutes(mux, authService)
+	router.AdminRoutes(mux, authService)
+	return &testAPI{t: t, handler: mux, store: store}
+}
+
+type discardNotifier struct{}
+
+func (discardNotifier) Notify(auth.Notification) error { return nil }
+
+// do sends a request with an optional bearer token and JSON body and decodes
+// the JSON response into a map
+func (a *testAPI) do(method, path, token string, body interface{}) (int, map[string]interface{}) {
+	a.t.Helper()
+	data := []byte{}
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			a.t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest(method, path, bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	a.handler.ServeHTTP(w, r)
+
+	response := map[string]interface{}{}
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			a.t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return w.Code, response
+}
+
+// register creates a user through the API and returns their ID
+func (a *testAPI) register(email string) uint {
+	a.t.Helper()
+	code, body := a.do(http.MethodPost, "/api/auth/user_register", "", map[string]string{
+		"email": email, "password": "password123", "first_name": "Test", "last_name": "User",
+	})
+	if code != http.StatusCreated {
+		a.t.Fatalf("registering %s: %d %v", email, code, body)
+	}
+	return uint(body["id"].(float64))
+}
+
+// login signs in through the API and returns the access token
+func (a *testAPI) login(username, password string) string {
+	a.t.Helper()
+	code, body := a.do(http.MethodPost, "/api/auth/user_login", "", map[string]string{"username": username, "password": password})
+	if code != http.StatusOK {
+		a.t.Fatalf("logging in %s: %d %v", username, code, body)
+	}
+	return body["token"].(string)
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.register("alice@example.com")
+
+	code, body := api.do(http.MethodPost, "/api/auth/user_register", "", map[string]string{
+		"email": "alice@example.com", "password": "password123", "first_name": "Test", "last_name": "User",
+	})
+	// The API registers the email address as the username too
+	if code != http.StatusBadRequest || body["message"] != "Username already taken" {
+		t.Errorf("duplicate registration: %d %v", code, body)
+	}
+	if code, _ := api.do(http.MethodGet, "/api/auth/user_register", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET register: %d", code)
+	}
+
+	code, _ = api.do(http.MethodPost, "/api/auth/user_login", "", map[string]string{"username": "alice@example.com", "password": "wrong"})
+	if code != http.StatusUnauthorized {
+		t.Errorf("wrong password: %d", code)
+	}
+	code, _ = api.do(http.MethodPost, "/api/auth/user_login", "", map[string]string{"username": "nobody@example.com", "password": "password123"})
+	if code != http.StatusUnauthorized {
+		t.Errorf("unknown user: %d", code)
+	}
+
+	token := api.login("alice@example.com", "password123")
+	code, body = api.do(http.MethodGet, "/api/user/get_user_profile", token, nil)
+	if code != http.StatusOK || body["email"] != "alice@example.com" || uint(body["user_id"].(float64)) != id {
+		t.Errorf("profile: %d %v", code, body)
+	}
+	if code, _ := api.do(http.MethodGet, "/api/user/get_user_profile", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("profile without a token: %d", code)
+	}
+}
+
+func TestChangeUserPassword(t *testing.T) {
+	api := newTestAPI(t)
+	api.register("alice@example.com")
+	token := api.login("alice@example.com", "password123")
+
+	code, _ := api.do(http.MethodPost, "/api/user/change_user_password", token, map[string]string{
+		"current_password": "wrong", "new_password": "new-password456",
+	})
+	if code != http.StatusUnauthorized {
+		t.Errorf("wrong current password: %d", code)
+	}
+	code, body := api.do(http.MethodPost, "/api/user/change_user_password", token, map[string]string{
+		"current_password": "password123", "new_password": "new-password456",
+	})
+	if code != http.StatusOK {
+		t.Fatalf("change password: %d %v", code, body)
+	}
+
+	code, _ = api.do(http.MethodPost, "/api/auth/user_login", "", map[string]string{"username": "alice@example.com", "password": "password123"})
+	if code != http.StatusUnauthorized {
+		t.Errorf("login with the old password: %d", code)
+	}
+	api.login("alice@example.com", "new-password456")
+}
+
+func TestSessionsAndLogout(t *testing.T) {
+	api := newTestAPI(t)
+	api.register("alice@example.com")
+	token := api.login("alice@example.com", "password123")
+
+	code, body := api.do(http.MethodGet, "/api/user/sessions", token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("sessions: %d %v", code, body)
+	}
+	sessions := body["sessions"].([]interface{})
+	if len(sessions) != 1 || sessions[0].(map[string]interface{})["current"] != true {
+		t.Errorf("sessions: %v", sessions)
+	}
+
+	if code, body := api.do(http.MethodPost, "/api/auth/logout", token, nil); code != http.StatusOK {
+		t.Fatalf("logout: %d %v", code, body)
+	}
+	if code, _ := api.do(http.MethodGet, "/api/user/get_user_profile", token, nil); code != http.StatusUnauthorized {
+		t.Errorf("profile after logout: %d", code)
+	}
+}
+
+func TestAdminDeleteAndRestoreUser(t *testing.T) {
+	api := newTestAPI(t)
+	adminID := api.register("admin@example.com")
+	userID := api.register("alice@example.com")
+	if err := api.store.Users().Update(context.Background(), adminID, map[string]interface{}{"is_superuser": true}); err != nil {
+		t.Fatal(err)
+	}
+	adminToken := api.login("admin@example.com", "password123")
+	userToken := api.login("alice@example.com", "password123")
+
+	deletePath := "/api/admin/delete_user?user_id=" + strconv.FormatUint(uint64(userID), 10)
+	if code, _ := api.do(http.MethodDelete, deletePath, userToken, nil); code != http.StatusForbidden {
+		t.Errorf("delete by a non-admin: %d", code)
+	}
+	if code, _ := api.do(http.MethodDelete, "/api/admin/delete_user?user_id="+strconv.FormatUint(uint64(adminID), 10), adminToken, nil); code != http.StatusBadRequest {
+		t.Errorf("deleting yourself: %d", code)
+	}
+	if code, body := api.do(http.MethodDelete, deletePath, adminToken, nil); code != http.StatusOK {
+		t.Fatalf("delete: %d %v", code, body)
+	}
+	if code, _ := api.do(http.MethodGet, "/api/user/get_user_profile", userToken, nil); code != http.StatusUnauthorized {
+		t.Errorf("deleted user's session: %d", code)
+	}
+
+	code, body := api.do(http.MethodGet, "/api/admin/deleted_users", adminToken, nil)
+	if code != http.StatusOK {
+		t.Fatalf("deleted users: %d %v", code, body)
+	}
+	if users, _ := body["users"].([]interface{}); len(users) != 1 {
+		t.Errorf("deleted users: %v", body)
+	}
+
+	restorePath := "/api/admin/restore_user?user_id=" + strconv.FormatUint(uint64(userID), 10)
+	if code, body := api.do(http.MethodPost, restorePath, adminToken, nil); code != http.StatusOK {
+		t.Fatalf("restore: %d %v", code, body)
+	}
+	if code, _ := api.do(http.MethodPost, restorePath, adminToken, nil); code != http.StatusNotFound {
+		t.Errorf("restoring twice: %d", code)
+	}
+	api.login("alice@example.com", "password123")
+}
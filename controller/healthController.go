@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/db"
+	"github.com/rb4807/Golang-Utlis-Postgresql/middleware"
+	"github.com/rb4807/Golang-Utlis-Postgresql/utils"
+	"gorm.io/gorm"
+)
+
+// Healthz reports that the process is up; it does not touch the database so
+// a slow database does not get the server restarted
+func Healthz() http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok"})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet, http.MethodHead}, handler)
+}
+
+// Readyz reports whether the database answers within timeout and has every
+// migration applied, answering 503 until it does. The probe is public, so the
+// reason is logged and shown on /debug/db rather than in the response.
+func Readyz(database *gorm.DB, migrations []db.Migration, timeout time.Duration) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		readiness := db.CheckReadiness(r.Context(), database, migrations, timeout)
+
+		w.Header().Set("Content-Type", "application/json")
+		if !readiness.Ready {
+			log.Printf("Not ready: %s", readiness.Error)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{"status": "unavailable"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "ready"})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet, http.MethodHead}, handler)
+}
+
+// DebugDB reports the connection pools and the readiness details, including
+// pending and modified migrations, to administrators
+func DebugDB(database *gorm.DB, migrations []db.Migration, timeout time.Duration) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		stats, err := db.PoolStatus(database)
+		if err != nil {
+			utils.SendJSONError(w, "Failed to read database stats", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			db.Stats
+			Readiness db.Readiness `json:"readiness"`
+		}{stats, db.CheckReadiness(r.Context(), database, migrations, timeout)})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet}, handler)
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+	"github.com/rb4807/Golang-Utlis-Postgresql/middleware"
+	"github.com/rb4807/Golang-Utlis-Postgresql/utils"
+)
+
+// sessionResponse is a session as shown to its owner
+type sessionResponse struct {
+	ID         uint      `json:"id"`
+	DeviceName string    `json:"device_name"`
+	IP         string    `json:"ip"`
+	Location   string    `json:"location"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+func ListUserSessions(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		sessions, err := authService.ListSessionsContext(r.Context(), claims.UserID)
+		if err != nil {
+			utils.SendJSONError(w, "Failed to list sessions", http.StatusInternalServerError)
+			return
+		}
+
+		response := make([]sessionResponse, 0, len(sessions))
+		for _, session := range sessions {
+			response = append(response, sessionResponse{
+				ID:         session.ID,
+				DeviceName: session.DeviceName,
+				IP:         session.IP,
+				Location:   session.Location,
+				CreatedAt:  session.CreatedAt,
+				LastSeenAt: session.LastSeenAt,
+				ExpiresAt:  session.ExpiresAt,
+				Current:    session.ID == claims.SessionID,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"sessions": response})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet}, handler)
+}
+
+func RevokeUserSession(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := strconv.ParseUint(r.URL.Query().Get("session_id"), 10, 64)
+		if err != nil || id == 0 {
+			utils.SendJSONError(w, "A valid session_id query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := authService.ForRequest(r).RevokeSession(claims.UserID, uint(id)); err != nil {
+			if errors.Is(err, auth.ErrSessionNotFound) {
+				utils.SendJSONError(w, "Session not found", http.StatusNotFound)
+				return
+			}
+			utils.SendJSONError(w, "Failed to revoke session", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Session revoked"})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+func RevokeOtherUserSessions(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		revoked, err := authService.ForRequest(r).RevokeOtherSessions(claims.UserID, claims.SessionID)
+		if err != nil {
+			utils.SendJSONError(w, "Failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Other sessions revoked",
+			"revoked": revoked,
+		})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
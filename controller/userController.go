@@ -3,8 +3,12 @@ package controller
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"time"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
 	"github.com/rb4807/Golang-Utlis-Postgresql/dto"
 	"github.com/rb4807/Golang-Utlis-Postgresql/middleware"
@@ -19,22 +23,30 @@ func GetUserProfile(authService *auth.Service) http.HandlerFunc {
 			return
 		}
 
-		user, err := authService.GetUserByID(claims.UserID)
+		user, err := authService.GetUserByIDContext(r.Context(), claims.UserID)
 		if err != nil {
 			utils.SendJSONError(w, "Error retrieving user", http.StatusInternalServerError)
 			return
 		}
 
+		impersonation := map[string]interface{}{"active": claims.IsImpersonated()}
+		if claims.IsImpersonated() {
+			impersonation["impersonator_id"] = claims.Actor.UserID
+			impersonation["impersonator_username"] = claims.Actor.Username
+			impersonation["expires_at"] = time.Unix(claims.ExpiresAt, 0)
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"user_id":      user.ID,
-			"username":     user.Username,
-			"email":        user.Email,
-			"first_name":   user.FirstName,
-			"last_name":    user.LastName,
-			"is_superuser": user.IsSuperuser,
-			"date_joined":  user.DateJoined,
-			"last_login":   user.LastLogin,
+			"user_id":       user.ID,
+			"username":      user.Username,
+			"email":         user.Email,
+			"first_name":    user.FirstName,
+			"last_name":     user.LastName,
+			"is_superuser":  user.IsSuperuser,
+			"date_joined":   user.DateJoined,
+			"last_login":    user.LastLogin,
+			"impersonation": impersonation,
 		})
 	}
 
@@ -62,8 +74,11 @@ func ChangeUserPassword(authService *auth.Service) http.HandlerFunc {
 			return
 		}
 
-		err = authService.ChangePassword(userID, req.CurrentPassword, req.NewPassword)
+		err = authService.ForRequest(r).ChangePassword(userID, req.CurrentPassword, req.NewPassword)
 		if err != nil {
+			if sendHookError(w, err) {
+				return
+			}
 			switch {
 			case errors.Is(err, auth.ErrInvalidPassword):
 				utils.SendJSONError(w, "Current password is incorrect", http.StatusUnauthorized)
@@ -79,5 +94,247 @@ func ChangeUserPassword(authService *auth.Service) http.HandlerFunc {
 		json.NewEncoder(w).Encode(map[string]string{"message": "Password changed successfully"})
 	}
 
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+func UpdateUserProfile(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req dto.UpdateUserProfileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.SendJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		result, err := authService.ForRequest(r).UpdateProfile(claims.UserID, auth.ProfileUpdate{
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+			Username:  req.Username,
+			Email:     req.Email,
+		})
+		if err != nil {
+			var validationErrors validator.ValidationErrors
+			switch {
+			case errors.Is(err, auth.ErrUserNotFound):
+				utils.SendJSONError(w, "User not found", http.StatusNotFound)
+			case errors.Is(err, auth.ErrUsernameExists):
+				utils.SendJSONError(w, "Username already taken", http.StatusConflict)
+			case errors.Is(err, auth.ErrEmailExists):
+				utils.SendJSONError(w, "Email already exists", http.StatusConflict)
+			case errors.Is(err, auth.ErrInvalidEmail):
+				utils.SendJSONError(w, "Invalid email address", http.StatusBadRequest)
+			case errors.As(err, &validationErrors):
+				utils.SendJSONError(w, "Invalid profile data", http.StatusBadRequest, validationErrors.Error())
+			default:
+				utils.SendJSONError(w, "Failed to update profile", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		message := "Profile updated successfully"
+		if result.EmailChangePending {
+			message = "Profile updated. Check your new email address to confirm the change"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":              message,
+			"user_id":              result.User.ID,
+			"username":             result.User.Username,
+			"email":                result.User.Email,
+			"first_name":           result.User.FirstName,
+			"last_name":            result.User.LastName,
+			"email_change_pending": result.EmailChangePending,
+			"pending_email":        result.PendingEmail,
+		})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPatch, http.MethodPost}, handler)
+}
+
+func ConfirmEmailChange(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req dto.ConfirmEmailChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.SendJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Token == "" {
+			utils.SendJSONError(w, "Token is required", http.StatusBadRequest)
+			return
+		}
+
+		user, err := authService.ForRequest(r).ConfirmEmailChange(claims.UserID, req.Token)
+		if err != nil {
+			switch {
+			case errors.Is(err, auth.ErrInvalidToken):
+				utils.SendJSONError(w, "Invalid or expired token", http.StatusBadRequest)
+			case errors.Is(err, auth.ErrEmailExists):
+				utils.SendJSONError(w, "Email already exists", http.StatusConflict)
+			case errors.Is(err, auth.ErrUserNotFound):
+				utils.SendJSONError(w, "User not found", http.StatusNotFound)
+			default:
+				utils.SendJSONError(w, "Failed to confirm email change", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":  "Email address updated successfully",
+			"email":    user.Email,
+			"username": user.Username,
+		})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+func ExportUserData(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "zip" {
+			utils.SendJSONError(w, "format must be json or zip", http.StatusBadRequest)
+			return
+		}
+
+		export, err := authService.ForRequest(r).ExportUserData(claims.UserID)
+		if err != nil {
+			if errors.Is(err, auth.ErrUserNotFound) {
+				utils.SendJSONError(w, "User not found", http.StatusNotFound)
+				return
+			}
+			utils.SendJSONError(w, "Failed to export user data", http.StatusInternalServerError)
+			return
+		}
+
+		filename := fmt.Sprintf("user-%d-export-%s", claims.UserID, export.ExportedAt.Format("20060102150405"))
+
+		if format == "zip" {
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".zip"))
+			if err := export.WriteZip(w); err != nil {
+				log.Printf("Failed to write data export archive: %v", err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		json.NewEncoder(w).Encode(export)
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet}, handler)
+}
+
+func RequestAccountDeletion(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req dto.AccountDeletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.SendJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Password == "" {
+			utils.SendJSONError(w, "Password is required", http.StatusBadRequest)
+			return
+		}
+
+		deletion, err := authService.ForRequest(r).RequestAccountDeletion(claims.UserID, req.Password)
+		if err != nil {
+			switch {
+			case errors.Is(err, auth.ErrInvalidPassword):
+				utils.SendJSONError(w, "Password is incorrect", http.StatusUnauthorized)
+			case errors.Is(err, auth.ErrUserNotFound):
+				utils.SendJSONError(w, "User not found", http.StatusNotFound)
+			default:
+				utils.SendJSONError(w, "Failed to request account deletion", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":       "Account scheduled for deletion",
+			"requested_at":  deletion.RequestedAt,
+			"scheduled_for": deletion.ScheduledFor,
+		})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+func CancelAccountDeletion(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := authService.ForRequest(r).CancelAccountDeletion(claims.UserID); err != nil {
+			if errors.Is(err, auth.ErrNoPendingDeletion) {
+				utils.SendJSONError(w, "No pending account deletion", http.StatusNotFound)
+				return
+			}
+			utils.SendJSONError(w, "Failed to cancel account deletion", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Account deletion cancelled"})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+func StopImpersonation(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.GetUserFromContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := authService.ForRequest(r).StopImpersonation(claims); err != nil {
+			if errors.Is(err, auth.ErrNotImpersonating) {
+				utils.SendJSONError(w, "Not currently impersonating a user", http.StatusBadRequest)
+				return
+			}
+			utils.SendJSONError(w, "Failed to stop impersonation", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Impersonation stopped"})
+	}
+
 	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
 }
\ No newline at end of file
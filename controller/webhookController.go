@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+	"github.com/rb4807/Golang-Utlis-Postgresql/dto"
+	"github.com/rb4807/Golang-Utlis-Postgresql/middleware"
+	"github.com/rb4807/Golang-Utlis-Postgresql/utils"
+)
+
+func CreateWebhook(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var req dto.CreateWebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.SendJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		eventTypes := make([]auth.EventType, len(req.EventTypes))
+		for i, t := range req.EventTypes {
+			eventTypes[i] = auth.EventType(t)
+		}
+
+		subscription, secret, err := authService.CreateWebhookSubscriptionContext(r.Context(), req.URL, eventTypes)
+		if err != nil {
+			switch {
+			case errors.Is(err, auth.ErrInvalidWebhookURL):
+				utils.SendJSONError(w, "url must be an absolute http or https URL", http.StatusBadRequest)
+			case errors.Is(err, auth.ErrUnknownEventType):
+				utils.SendJSONError(w, "Unknown event type", http.StatusBadRequest)
+			default:
+				utils.SendJSONError(w, "Failed to create webhook", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// The secret is only ever returned here; receivers need it to verify signatures
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"subscription": subscription,
+			"secret":       secret,
+		})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodPost}, handler)
+}
+
+func ListWebhooks(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		subscriptions, err := authService.ListWebhookSubscriptionsContext(r.Context())
+		if err != nil {
+			utils.SendJSONError(w, "Failed to list webhooks", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"subscriptions": subscriptions})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet}, handler)
+}
+
+func DeleteWebhook(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.URL.Query().Get("webhook_id"), 10, 64)
+		if err != nil || id == 0 {
+			utils.SendJSONError(w, "A valid webhook_id query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := authService.DeleteWebhookSubscriptionContext(r.Context(), uint(id)); err != nil {
+			if errors.Is(err, auth.ErrWebhookNotFound) {
+				utils.SendJSONError(w, "Webhook not found", http.StatusNotFound)
+				return
+			}
+			utils.SendJSONError(w, "Failed to delete webhook", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Webhook deleted successfully"})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodDelete}, handler)
+}
+
+func ListWebhookDeliveries(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		var subscriptionID uint64
+		var limit int
+		var err error
+		if v := query.Get("webhook_id"); v != "" {
+			if subscriptionID, err = strconv.ParseUint(v, 10, 64); err != nil {
+				utils.SendJSONError(w, "webhook_id must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := query.Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+				utils.SendJSONError(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		deliveries, err := authService.ListWebhookDeliveriesContext(r.Context(), uint(subscriptionID), query.Get("status"), limit)
+		if err != nil {
+			utils.SendJSONError(w, "Failed to list webhook deliveries", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"deliveries": deliveries})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet}, handler)
+}
+
+func ListWebhookAttempts(authService *auth.Service) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.URL.Query().Get("delivery_id"), 10, 64)
+		if err != nil || id == 0 {
+			utils.SendJSONError(w, "A valid delivery_id query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		attempts, err := authService.ListWebhookAttemptsContext(r.Context(), uint(id))
+		if err != nil {
+			utils.SendJSONError(w, "Failed to list webhook attempts", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"attempts": attempts})
+	}
+
+	return middleware.RequestMethodValidator([]string{http.MethodGet}, handler)
+}
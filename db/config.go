@@ -0,0 +1,347 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Supported values of DB_DRIVER
+const (
+	Postgres = "postgres"
+	MySQL    = "mysql"
+	SQLite   = "sqlite"
+)
+
+// Config describes how to reach the database. URL, when set, wins over the
+// individual connection fields.
+type Config struct {
+	Driver   string // postgres, mysql or sqlite; inferred from URL when empty, defaults to postgres
+	URL      string // Full connection URL or driver-specific DSN
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string // Database name, or the file path for SQLite
+	SSLMode  string // PostgreSQL only, defaults to "disable"
+
+	// Connection pool; zero leaves the database/sql default
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// StatementTimeout cancels statements that run longer on the server. It is
+	// PostgreSQL's statement_timeout and MySQL's max_execution_time, which only
+	// covers SELECT; SQLite has no equivalent.
+	StatementTimeout time.Duration
+
+	// Connect retries a failed connection with exponential backoff, starting
+	// at RetryInterval (default 500ms) and capped at MaxRetryInterval (default
+	// 10s), until ConnectTimeout (default 30s) has passed
+	ConnectTimeout   time.Duration
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+
+	// Read replicas, as URLs or DSNs of the primary's driver. Reads are spread
+	// over the healthy replicas by ReplicaPolicy (random, round_robin or
+	// least_connections); a replica failing its health check every
+	// ReplicaCheckInterval (default 15s), or lagging more than ReplicaMaxLag
+	// (default 10s), is skipped until it recovers.
+	Replicas             []string
+	ReplicaPolicy        string
+	ReplicaMaxLag        time.Duration
+	ReplicaCheckInterval time.Duration
+}
+
+// ConfigFromEnv reads DB_DRIVER, DATABASE_URL and the DB_* connection
+// variables. Durations use Go syntax, e.g. DB_CONN_MAX_LIFETIME=30m, and
+// DB_REPLICA_URLS is a comma-separated list.
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		Driver:   os.Getenv("DB_DRIVER"),
+		URL:      os.Getenv("DATABASE_URL"),
+		Host:     os.Getenv("DB_HOST"),
+		Port:     os.Getenv("DB_PORT"),
+		User:     os.Getenv("DB_USER"),
+		Password: os.Getenv("DB_PASSWORD"),
+		Name:     os.Getenv("DB_NAME"),
+		SSLMode:  os.Getenv("DB_SSLMODE"),
+
+		ReplicaPolicy: os.Getenv("DB_REPLICA_POLICY"),
+	}
+	for _, replica := range strings.Split(os.Getenv("DB_REPLICA_URLS"), ",") {
+		if replica = strings.TrimSpace(replica); replica != "" {
+			config.Replicas = append(config.Replicas, replica)
+		}
+	}
+
+	ints := map[string]*int{
+		"DB_MAX_OPEN_CONNS": &config.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS": &config.MaxIdleConns,
+	}
+	for name, target := range ints {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return Config{}, fmt.Errorf("%s: %w", name, err)
+			}
+			*target = n
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"DB_CONN_MAX_LIFETIME":  &config.ConnMaxLifetime,
+		"DB_CONN_MAX_IDLE_TIME": &config.ConnMaxIdleTime,
+		"DB_STATEMENT_TIMEOUT":  &config.StatementTimeout,
+		"DB_CONNECT_TIMEOUT":    &config.ConnectTimeout,
+		"DB_RETRY_INTERVAL":     &config.RetryInterval,
+		"DB_MAX_RETRY_INTERVAL": &config.MaxRetryInterval,
+
+		"DB_REPLICA_MAX_LAG":        &config.ReplicaMaxLag,
+		"DB_REPLICA_CHECK_INTERVAL": &config.ReplicaCheckInterval,
+	}
+	for name, target := range durations {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return Config{}, fmt.Errorf("%s: %w", name, err)
+			}
+			*target = d
+		}
+	}
+
+	return config, nil
+}
+
+// applyPool sets the connection pool limits on conn
+func (c Config) applyPool(conn *sql.DB) {
+	if c.MaxOpenConns > 0 {
+		conn.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		conn.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		conn.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	if c.ConnMaxIdleTime > 0 {
+		conn.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	}
+}
+
+// Dialector returns the GORM dialector for the configured driver
+func (c Config) Dialector() (gorm.Dialector, error) {
+	driver, dsn, err := c.dsn()
+	if err != nil {
+		return nil, err
+	}
+
+	switch driver {
+	case Postgres:
+		return postgres.Open(dsn), nil
+	case MySQL:
+		return mysql.Open(dsn), nil
+	case SQLite:
+		return sqlite.Open(dsn), nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q", driver)
+}
+
+// DriverName returns the driver that will be used, after inference from the URL
+func (c Config) DriverName() string {
+	driver, _, err := c.dsn()
+	if err != nil {
+		return c.Driver
+	}
+	return driver
+}
+
+// dsn resolves the driver and builds its connection string, including the
+// statement timeout
+func (c Config) dsn() (string, string, error) {
+	driver, dsn, err := c.baseDSN()
+	if err != nil || c.StatementTimeout <= 0 {
+		return driver, dsn, err
+	}
+
+	millis := strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+	switch driver {
+	case Postgres:
+		if strings.Contains(dsn, "statement_timeout") {
+			break
+		}
+		if strings.Contains(dsn, "://") {
+			dsn += querySeparator(dsn) + "statement_timeout=" + millis
+		} else {
+			dsn += " statement_timeout=" + millis
+		}
+	case MySQL:
+		if !strings.Contains(dsn, "max_execution_time") {
+			dsn += querySeparator(dsn) + "max_execution_time=" + millis
+		}
+	}
+	return driver, dsn, nil
+}
+
+func querySeparator(dsn string) string {
+	if strings.Contains(dsn, "?") {
+		return "&"
+	}
+	return "?"
+}
+
+// baseDSN resolves the driver and builds its connection string
+func (c Config) baseDSN() (string, string, error) {
+	driver := strings.ToLower(strings.TrimSpace(c.Driver))
+	switch driver {
+	case "postgresql", "pgx":
+		driver = Postgres
+	case "sqlite3":
+		driver = SQLite
+	}
+
+	if c.URL != "" {
+		urlDriver, dsn, err := dsnFromURL(c.URL)
+		if err != nil {
+			return "", "", err
+		}
+		if urlDriver == "" {
+			// A bare DSN in the driver's own format
+			if driver == "" {
+				driver = Postgres
+			}
+			if driver == SQLite {
+				dsn, err := sqliteDSNFromString(c.URL)
+				return driver, dsn, err
+			}
+			return driver, c.URL, nil
+		}
+		if driver != "" && driver != urlDriver {
+			return "", "", fmt.Errorf("DB_DRIVER %q does not match DATABASE_URL scheme %q", driver, urlDriver)
+		}
+		return urlDriver, dsn, nil
+	}
+
+	if driver == "" {
+		driver = Postgres
+	}
+
+	switch driver {
+	case Postgres:
+		sslMode := c.SSLMode
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+		return driver, fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
+			c.Host, c.User, c.Password, c.Name, c.Port, sslMode), nil
+
+	case MySQL:
+		port := c.Port
+		if port == "" {
+			port = "3306"
+		}
+		return driver, fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?%s",
+			c.User, c.Password, c.Host, port, c.Name, mysqlParams(nil)), nil
+
+	case SQLite:
+		name := c.Name
+		if name == "" {
+			name = "app.db"
+		}
+		return driver, sqliteDSN(name, nil), nil
+	}
+
+	return "", "", fmt.Errorf("unsupported database driver %q", driver)
+}
+
+// dsnFromURL converts a DATABASE_URL into the driver's DSN format. It returns
+// an empty driver for strings without a recognised scheme.
+func dsnFromURL(raw string) (string, string, error) {
+	scheme, _, ok := strings.Cut(raw, "://")
+	if !ok {
+		if strings.HasPrefix(raw, "file:") {
+			dsn, err := sqliteDSNFromString(raw)
+			return SQLite, dsn, err
+		}
+		return "", "", nil
+	}
+
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		// pgx accepts URLs as they are
+		return Postgres, raw, nil
+
+	case "mysql":
+		u, err := url.Parse(raw)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid DATABASE_URL: %w", err)
+		}
+		password, _ := u.User.Password()
+		host := u.Host
+		if u.Port() == "" {
+			host += ":3306"
+		}
+		return MySQL, fmt.Sprintf("%s:%s@tcp(%s)/%s?%s",
+			u.User.Username(), password, host, strings.TrimPrefix(u.Path, "/"), mysqlParams(u.Query())), nil
+
+	case "sqlite", "sqlite3":
+		// sqlite://relative.db or sqlite:///absolute/path.db
+		dsn, err := sqliteDSNFromString(strings.TrimPrefix(raw, scheme+"://"))
+		return SQLite, dsn, err
+	}
+
+	return "", "", fmt.Errorf("unsupported DATABASE_URL scheme %q", scheme)
+}
+
+// mysqlParams adds the options auth relies on: time.Time scanning, UTC and
+// 4-byte UTF-8
+func mysqlParams(params url.Values) string {
+	if params == nil {
+		params = url.Values{}
+	}
+	for key, value := range map[string]string{"parseTime": "true", "loc": "UTC", "charset": "utf8mb4"} {
+		if params.Get(key) == "" {
+			params.Set(key, value)
+		}
+	}
+	return params.Encode()
+}
+
+// sqliteDSNFromString applies sqliteDSN to a file name or file: URI with its
+// own query parameters
+func sqliteDSNFromString(dsn string) (string, error) {
+	name, query, _ := strings.Cut(dsn, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("invalid SQLite DSN: %w", err)
+	}
+	return sqliteDSN(name, params), nil
+}
+
+// sqliteDSN enables foreign keys, which SQLite leaves off by default, and
+// waits on locks instead of failing immediately. Either setting given under
+// the driver's short alias is left alone.
+func sqliteDSN(name string, params url.Values) string {
+	if params == nil {
+		params = url.Values{}
+	}
+	for _, option := range []struct{ key, alias, value string }{
+		{"_foreign_keys", "_fk", "on"},
+		{"_busy_timeout", "_timeout", "5000"},
+	} {
+		if params.Get(option.key) == "" && params.Get(option.alias) == "" {
+			params.Set(option.key, option.value)
+		}
+	}
+	return name + "?" + params.Encode()
+}
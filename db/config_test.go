@@ -0,0 +1,120 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigDSN(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     Config
+		wantDriver string
+		wantDSN    string
+	}{
+		{
+			name:       "postgres fields",
+			config:     Config{Host: "db", Port: "5432", User: "app", Password: "secret", Name: "auth"},
+			wantDriver: Postgres,
+			wantDSN:    "host=db user=app password=secret dbname=auth port=5432 sslmode=disable",
+		},
+		{
+			name:       "postgres statement timeout",
+			config:     Config{Driver: "postgresql", Host: "db", Name: "auth", SSLMode: "require", StatementTimeout: 5 * time.Second},
+			wantDriver: Postgres,
+			wantDSN:    "host=db user= password= dbname=auth port= sslmode=require statement_timeout=5000",
+		},
+		{
+			name:       "postgres URL",
+			config:     Config{URL: "postgres://app:secret@db/auth?sslmode=require", StatementTimeout: time.Second},
+			wantDriver: Postgres,
+			wantDSN:    "postgres://app:secret@db/auth?sslmode=require&statement_timeout=1000",
+		},
+		{
+			name:       "bare postgres DSN",
+			config:     Config{URL: "host=db dbname=auth"},
+			wantDriver: Postgres,
+			wantDSN:    "host=db dbname=auth",
+		},
+		{
+			name:       "mysql fields",
+			config:     Config{Driver: MySQL, Host: "db", User: "app", Password: "secret", Name: "auth"},
+			wantDriver: MySQL,
+			wantDSN:    "app:secret@tcp(db:3306)/auth?charset=utf8mb4&loc=UTC&parseTime=true",
+		},
+		{
+			name:       "mysql URL",
+			config:     Config{URL: "mysql://app:secret@db:3307/auth?tls=true", StatementTimeout: 5 * time.Second},
+			wantDriver: MySQL,
+			wantDSN:    "app:secret@tcp(db:3307)/auth?charset=utf8mb4&loc=UTC&parseTime=true&tls=true&max_execution_time=5000",
+		},
+		{
+			name:       "sqlite default file",
+			config:     Config{Driver: SQLite},
+			wantDriver: SQLite,
+			wantDSN:    "app.db?_busy_timeout=5000&_foreign_keys=on",
+		},
+		{
+			name:       "sqlite ignores the statement timeout",
+			config:     Config{Driver: SQLite, Name: "/var/lib/auth.db", StatementTimeout: time.Second},
+			wantDriver: SQLite,
+			wantDSN:    "/var/lib/auth.db?_busy_timeout=5000&_foreign_keys=on",
+		},
+		{
+			name:       "sqlite URL",
+			config:     Config{URL: "sqlite:///var/lib/auth.db?cache=shared"},
+			wantDriver: SQLite,
+			wantDSN:    "/var/lib/auth.db?_busy_timeout=5000&_foreign_keys=on&cache=shared",
+		},
+		{
+			name:       "sqlite file URI",
+			config:     Config{URL: "file:auth.db?mode=rwc"},
+			wantDriver: SQLite,
+			wantDSN:    "file:auth.db?_busy_timeout=5000&_foreign_keys=on&mode=rwc",
+		},
+		{
+			name:       "bare sqlite DSN",
+			config:     Config{Driver: "sqlite3", URL: "auth.db"},
+			wantDriver: SQLite,
+			wantDSN:    "auth.db?_busy_timeout=5000&_foreign_keys=on",
+		},
+		{
+			name:       "sqlite driver aliases are kept",
+			config:     Config{Driver: SQLite, URL: "auth.db?_fk=0&_timeout=100"},
+			wantDriver: SQLite,
+			wantDSN:    "auth.db?_fk=0&_timeout=100",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver, dsn, err := test.config.dsn()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if driver != test.wantDriver || dsn != test.wantDSN {
+				t.Errorf("got %s %q, want %s %q", driver, dsn, test.wantDriver, test.wantDSN)
+			}
+		})
+	}
+}
+
+func TestConfigDSNErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"unsupported driver", Config{Driver: "oracle"}},
+		{"unsupported scheme", Config{URL: "redis://localhost"}},
+		{"driver and scheme disagree", Config{Driver: MySQL, URL: "postgres://db/auth"}},
+		{"invalid sqlite parameters", Config{URL: "file:auth.db?mode=%zz"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, dsn, err := test.config.dsn(); err == nil {
+				t.Errorf("got %q, want an error", dsn)
+			}
+		})
+	}
+}
@@ -1,95 +1,150 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"os"
+	"time"
 
 	"github.com/joho/godotenv"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// InitDB connects to the database configured in the environment and exits
+// the program if it cannot
 func InitDB() *gorm.DB {
+	db, err := Init()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	return db
+}
+
+// Init is InitDB returning an error instead of exiting. It waits for the
+// database to come up for as long as DB_CONNECT_TIMEOUT allows.
+func Init() (*gorm.DB, error) {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found (proceeding with system env variables)")
 	}
 
-	// Get environment variables
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbName := os.Getenv("DB_NAME")
-	sslMode := os.Getenv("DB_SSLMODE")
+	config, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := Connect(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Database connected successfully")
+	return db, nil
+}
+
+// Connect opens the database, retrying with exponential backoff while it is
+// unreachable until config.ConnectTimeout has passed or ctx is done
+func Connect(ctx context.Context, config Config) (*gorm.DB, error) {
+	timeout := config.ConnectTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	interval := config.RetryInterval
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+	maxInterval := config.MaxRetryInterval
+	if maxInterval <= 0 {
+		maxInterval = 10 * time.Second
+	}
+
+	// Configuration mistakes will not fix themselves
+	if _, err := config.Dialector(); err != nil {
+		return nil, err
+	}
 
-	if sslMode == "" {
-		sslMode = "disable"
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		// A database that accepts connections but never answers must not
+		// hold an attempt past the deadline
+		attemptCtx, cancel := context.WithDeadline(ctx, deadline)
+		db, err := Open(attemptCtx, config)
+		cancel()
+		if err == nil {
+			return db, nil
+		}
+
+		wait := interval
+		if remaining := time.Until(deadline); remaining < wait {
+			if remaining <= 0 {
+				return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			wait = remaining
+		}
+		log.Printf("Database not ready (attempt %d), retrying in %s: %v", attempt, wait.Round(time.Millisecond), err)
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-time.After(wait):
+		}
+		interval = min(interval*2, maxInterval)
 	}
+}
 
-	// Build DSN string
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
-		dbHost, dbUser, dbPassword, dbName, dbPort, sslMode)
+// Open connects to the database described by config once, applies the pool
+// settings and checks the connection, giving up on the check when ctx is done
+func Open(ctx context.Context, config Config) (*gorm.DB, error) {
+	dialector, err := config.Dialector()
+	if err != nil {
+		return nil, err
+	}
 
-	// Connect to PostgreSQL using GORM
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// The ping below covers the primary; replicas are allowed to be down
+	db, err := gorm.Open(dialector, &gorm.Config{DisableAutomaticPing: true})
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		closeDB(db)
+		return nil, err
+	}
+	if err := RegisterErrorTranslation(db); err != nil {
+		closeDB(db)
+		return nil, fmt.Errorf("registering error translation: %w", err)
 	}
 
-	// Check connection
 	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatalf("Failed to get underlying database: %v", err)
+		return nil, fmt.Errorf("getting underlying database: %w", err)
 	}
+	config.applyPool(sqlDB)
 
-	if err := sqlDB.Ping(); err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
+	// Check connection
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("pinging %s database: %w", config.DriverName(), err)
 	}
 
-	fmt.Println("Database connected successfully")
-	return db
+	if len(config.Replicas) > 0 {
+		replicas, err := newReplicaSet(config, config.DriverName())
+		if err != nil {
+			sqlDB.Close()
+			return nil, err
+		}
+		if err := db.Use(replicas); err != nil {
+			replicas.close()
+			sqlDB.Close()
+			return nil, fmt.Errorf("configuring replicas: %w", err)
+		}
+	}
+
+	return db, nil
 }
 
-// MYSQL CONNECTION
-
-// import (
-// 	"database/sql"
-// 	"fmt"
-// 	"log"
-// 	"os"
-// 	"github.com/joho/godotenv"
-// 	_ "github.com/go-sql-driver/mysql"
-// )
-
-// func InitDB() *sql.DB {
-// 	// Load .env file
-// 	if err := godotenv.Load(); err != nil {
-// 		log.Println("No .env file found (proceeding with system env variables)")
-// 	}
-
-// 	// Get environment variables
-// 	dbUser := os.Getenv("DB_USER")
-// 	dbPassword := os.Getenv("DB_PASSWORD")
-// 	dbHost := os.Getenv("DB_HOST")
-// 	dbPort := os.Getenv("DB_PORT")
-// 	dbName := os.Getenv("DB_NAME")
-
-// 	// Build DSN string
-// 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
-// 		dbUser, dbPassword, dbHost, dbPort, dbName)
-
-// 	// Connect to MySQL
-// 	db, err := sql.Open("mysql", dsn)
-// 	if err != nil {
-// 		log.Fatalf("Failed to connect to database: %v", err)
-// 	}
-
-// 	if err := db.Ping(); err != nil {
-// 		log.Fatalf("Failed to ping database: %v", err)
-// 	}
-
-// 	fmt.Println("Database connected successfully")
-// 	return db
-// }
+// closeDB releases the pool of a connection that failed to open
+func closeDB(db *gorm.DB) {
+	if db == nil || db.ConnPool == nil {
+		return
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// errDiffRollback aborts the transaction Diff runs AutoMigrate in
+var errDiffRollback = errors.New("diff rollback")
+
+// Diff returns the statements GORM's AutoMigrate would run to bring the
+// database in line with models. It runs AutoMigrate inside a transaction that
+// is always rolled back, so it needs transactional DDL: PostgreSQL and SQLite
+// are supported, MySQL commits DDL implicitly and is refused.
+func Diff(db *gorm.DB, models ...interface{}) ([]string, error) {
+	if db.Dialector.Name() == MySQL {
+		return nil, errors.New("diff is not supported on MySQL, which cannot roll back DDL; run it against PostgreSQL or SQLite")
+	}
+
+	if db.Dialector.Name() == SQLite {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		// A separate handle on the same pool, inspecting the schema through sqliteDiffDialector
+		if db, err = gorm.Open(sqliteDiffDialector{existingConnDialector(SQLite, sqlDB)}, &gorm.Config{Logger: logger.Discard}); err != nil {
+			return nil, err
+		}
+	}
+
+	recorder := &statementRecorder{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Session(&gorm.Session{Logger: recorder}).AutoMigrate(models...); err != nil {
+			return err
+		}
+		return errDiffRollback
+	})
+	if err != nil && !errors.Is(err, errDiffRollback) {
+		return nil, err
+	}
+
+	// Keep everything but the schema inspection; SQLite rebuilds tables with
+	// INSERT ... SELECT when a column changes
+	var ddl []string
+	for _, statement := range recorder.statements {
+		switch strings.ToUpper(strings.Fields(statement)[0]) {
+		case "SELECT", "PRAGMA", "SHOW", "SAVEPOINT", "RELEASE", "ROLLBACK":
+			continue
+		}
+		ddl = append(ddl, statement)
+	}
+	return ddl, nil
+}
+
+// sqliteDiffDialector reads string defaults written as single-quoted SQL
+// literals the way GORM's SQLite driver reads the double-quoted ones it
+// writes itself. The driver strips only double quotes, so without it Diff would
+// rebuild every table whose migration uses a proper string literal.
+type sqliteDiffDialector struct {
+	gorm.Dialector
+}
+
+func (d sqliteDiffDialector) Migrator(db *gorm.DB) gorm.Migrator {
+	return sqliteDiffMigrator{d.Dialector.Migrator(db)}
+}
+
+type sqliteDiffMigrator struct {
+	gorm.Migrator
+}
+
+func (m sqliteDiffMigrator) ColumnTypes(dst interface{}) ([]gorm.ColumnType, error) {
+	columnTypes, err := m.Migrator.ColumnTypes(dst)
+	for i, columnType := range columnTypes {
+		columnTypes[i] = sqliteDiffColumnType{columnType}
+	}
+	return columnTypes, err
+}
+
+// sqliteDiffColumnType embeds gorm.ColumnType through a named interface, whose
+// ColumnType method would otherwise clash with the embedded field
+type sqliteDiffColumnType struct {
+	columnType
+}
+
+type columnType = gorm.ColumnType
+
+func (c sqliteDiffColumnType) DefaultValue() (string, bool) {
+	value, ok := c.columnType.DefaultValue()
+	if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+		value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+	return value, ok
+}
+
+var (
+	createTable = regexp.MustCompile(`(?i)^CREATE TABLE (?:IF NOT EXISTS )?("[^"]+"|` + "`[^`]+`" + `|\S+)`)
+	createIndex = regexp.MustCompile(`(?i)^CREATE (?:UNIQUE )?INDEX (?:IF NOT EXISTS )?("[^"]+"|` + "`[^`]+`" + `|\S+)`)
+	addColumn   = regexp.MustCompile(`(?i)^ALTER TABLE ("[^"]+"|` + "`[^`]+`" + `|\S+) ADD (?:COLUMN )?("[^"]+"|` + "`[^`]+`" + `|\S+)`)
+)
+
+// ReverseStatements returns best-effort down statements for DDL produced by
+// Diff, newest first. Statements it cannot reverse are left as comments for
+// the author to complete.
+func ReverseStatements(statements []string) []string {
+	reversed := make([]string, 0, len(statements))
+	for i := len(statements) - 1; i >= 0; i-- {
+		statement := statements[i]
+		switch {
+		case createTable.MatchString(statement):
+			reversed = append(reversed, "DROP TABLE "+createTable.FindStringSubmatch(statement)[1])
+		case createIndex.MatchString(statement):
+			reversed = append(reversed, "DROP INDEX "+createIndex.FindStringSubmatch(statement)[1])
+		case addColumn.MatchString(statement):
+			m := addColumn.FindStringSubmatch(statement)
+			reversed = append(reversed, "ALTER TABLE "+m[1]+" DROP COLUMN "+m[2])
+		default:
+			reversed = append(reversed, "-- TODO: reverse "+strings.ReplaceAll(statement, "\n", " "))
+		}
+	}
+	return reversed
+}
+
+// WriteMigrationFiles writes an up and down migration for one driver into
+// dir, named with the current UTC timestamp, and returns their paths
+func WriteMigrationFiles(dir, name, driver string, up, down []string) (string, string, error) {
+	if len(up) == 0 {
+		return "", "", errors.New("no statements to write")
+	}
+	if !regexp.MustCompile(`^[a-z0-9_]+$`).MatchString(name) {
+		return "", "", fmt.Errorf("migration name %q must be lowercase letters, digits and underscores", name)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", err
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%s_%s", time.Now().UTC().Format("20060102150405"), name))
+	upPath := fmt.Sprintf("%s.up.%s.sql", base, driver)
+	downPath := fmt.Sprintf("%s.down.%s.sql", base, driver)
+
+	if err := os.WriteFile(upPath, []byte(joinStatements(up)), 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(downPath, []byte(joinStatements(down)), 0o644); err != nil {
+		return "", "", err
+	}
+	return upPath, downPath, nil
+}
+
+func joinStatements(statements []string) string {
+	var b strings.Builder
+	for _, statement := range statements {
+		b.WriteString(statement)
+		if !strings.HasPrefix(statement, "--") {
+			b.WriteString(";")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// statementRecorder is a GORM logger that collects the SQL of every statement
+type statementRecorder struct {
+	statements []string
+}
+
+func (r *statementRecorder) LogMode(logger.LogLevel) logger.Interface      { return r }
+func (r *statementRecorder) Info(context.Context, string, ...interface{})  {}
+func (r *statementRecorder) Warn(context.Context, string, ...interface{})  {}
+func (r *statementRecorder) Error(context.Context, string, ...interface{}) {}
+
+func (r *statementRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), err error) {
+	if sql, _ := fc(); strings.TrimSpace(sql) != "" {
+		r.statements = append(r.statements, strings.TrimSpace(sql))
+	}
+}
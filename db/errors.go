@@ -0,0 +1,250 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/gorm"
+)
+
+// Driver-independent constraint and concurrency errors. Translate wraps the
+// original driver error, so errors.Is works against these and the driver's
+// message is kept for logging.
+var (
+	ErrForeignKeyViolation = errors.New("foreign key violation")
+	ErrNotNull             = errors.New("not null violation")
+	ErrDeadlock            = errors.New("deadlock detected")
+	ErrSerialization       = errors.New("could not serialize access")
+)
+
+// ErrUniqueViolation reports a duplicate value in a unique index. Constraint
+// is the index name where the driver reports one; Column is the offending
+// column, comma-separated for composite indexes, where it can be determined.
+type ErrUniqueViolation struct {
+	Table      string
+	Constraint string
+	Column     string
+	Err        error
+}
+
+func (e *ErrUniqueViolation) Error() string {
+	if e.Column != "" {
+		return fmt.Sprintf("duplicate value for %s", e.Column)
+	}
+	return fmt.Sprintf("duplicate value violates %s", e.Constraint)
+}
+
+func (e *ErrUniqueViolation) Unwrap() error {
+	return e.Err
+}
+
+// IsUniqueViolation reports whether err is a unique violation, optionally on
+// one of the given columns
+func IsUniqueViolation(err error, columns ...string) bool {
+	var unique *ErrUniqueViolation
+	if !errors.As(Translate(err), &unique) {
+		return false
+	}
+	if len(columns) == 0 {
+		return true
+	}
+	for _, column := range columns {
+		if unique.Column == column {
+			return true
+		}
+	}
+	return false
+}
+
+// translatedError wraps a driver error with one of the sentinel errors above
+type translatedError struct {
+	kind error
+	err  error
+}
+
+func (e *translatedError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e *translatedError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// PostgreSQL SQLSTATE codes
+const (
+	pgUniqueViolation      = "23505"
+	pgForeignKeyViolation  = "23503"
+	pgNotNullViolation     = "23502"
+	pgDeadlockDetected     = "40P01"
+	pgSerializationFailure = "40001"
+)
+
+// MySQL error numbers
+const (
+	mysqlDuplicateEntry     = 1062
+	mysqlRowIsReferenced    = 1451
+	mysqlNoReferencedRow    = 1452
+	mysqlBadNull            = 1048
+	mysqlNoDefaultForField  = 1364
+	mysqlDeadlock           = 1213
+	mysqlLockWaitTimeout    = 1205
+	mysqlRowIsReferencedOld = 1217
+	mysqlNoReferencedRowOld = 1216
+)
+
+var (
+	// Key (email)=(a@b.com) already exists.
+	pgDetailColumns = regexp.MustCompile(`^Key \(([^)]+)\)=`)
+	// Duplicate entry 'a@b.com' for key 'users.idx_users_email'
+	mysqlDuplicateKey = regexp.MustCompile(`for key '([^']+)'$`)
+	// UNIQUE constraint failed: users.email
+	sqliteUniqueColumns = regexp.MustCompile(`constraint failed: (.+)$`)
+)
+
+// Translate maps driver errors to the errors of this package and returns any
+// other error unchanged
+func Translate(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var (
+		unique     *ErrUniqueViolation
+		translated *translatedError
+	)
+	if errors.As(err, &unique) || errors.As(err, &translated) {
+		return err
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return translatePostgres(pgErr, err)
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return translateMySQL(mysqlErr, err)
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return translateSQLite(sqliteErr, err)
+	}
+	return err
+}
+
+func translatePostgres(pgErr *pgconn.PgError, err error) error {
+	switch pgErr.Code {
+	case pgUniqueViolation:
+		unique := &ErrUniqueViolation{Table: pgErr.TableName, Constraint: pgErr.ConstraintName, Column: pgErr.ColumnName, Err: err}
+		if m := pgDetailColumns.FindStringSubmatch(pgErr.Detail); unique.Column == "" && m != nil {
+			unique.Column = strings.ReplaceAll(m[1], ", ", ",")
+		}
+		if unique.Column == "" {
+			unique.Column = columnFromIndex(unique.Table, unique.Constraint)
+		}
+		return unique
+	case pgForeignKeyViolation:
+		return &translatedError{ErrForeignKeyViolation, err}
+	case pgNotNullViolation:
+		return &translatedError{ErrNotNull, err}
+	case pgDeadlockDetected:
+		return &translatedError{ErrDeadlock, err}
+	case pgSerializationFailure:
+		return &translatedError{ErrSerialization, err}
+	}
+	return err
+}
+
+func translateMySQL(mysqlErr *mysql.MySQLError, err error) error {
+	switch mysqlErr.Number {
+	case mysqlDuplicateEntry:
+		unique := &ErrUniqueViolation{Err: err}
+		if m := mysqlDuplicateKey.FindStringSubmatch(mysqlErr.Message); m != nil {
+			// MySQL 8 reports "table.index", older versions just "index"
+			unique.Constraint = m[1]
+			if table, index, ok := strings.Cut(m[1], "."); ok {
+				unique.Table, unique.Constraint = table, index
+			}
+		}
+		unique.Column = columnFromIndex(unique.Table, unique.Constraint)
+		return unique
+	case mysqlRowIsReferenced, mysqlNoReferencedRow, mysqlRowIsReferencedOld, mysqlNoReferencedRowOld:
+		return &translatedError{ErrForeignKeyViolation, err}
+	case mysqlBadNull, mysqlNoDefaultForField:
+		return &translatedError{ErrNotNull, err}
+	case mysqlDeadlock:
+		return &translatedError{ErrDeadlock, err}
+	case mysqlLockWaitTimeout:
+		return &translatedError{ErrSerialization, err}
+	}
+	return err
+}
+
+func translateSQLite(sqliteErr sqlite3.Error, err error) error {
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		unique := &ErrUniqueViolation{Err: err}
+		if m := sqliteUniqueColumns.FindStringSubmatch(sqliteErr.Error()); m != nil {
+			// "users.email" or, for composite indexes, "t.a, t.b"
+			columns := strings.Split(m[1], ", ")
+			for i, column := range columns {
+				table, name, _ := strings.Cut(column, ".")
+				unique.Table, columns[i] = table, name
+			}
+			unique.Column = strings.Join(columns, ",")
+			unique.Constraint = m[1]
+		}
+		return unique
+	case sqlite3.ErrConstraintForeignKey:
+		return &translatedError{ErrForeignKeyViolation, err}
+	case sqlite3.ErrConstraintNotNull:
+		return &translatedError{ErrNotNull, err}
+	}
+
+	// SQLite has no row locks; a busy or locked database is the closest it
+	// gets to a serialization failure and is just as safe to retry
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		return &translatedError{ErrSerialization, err}
+	}
+	return err
+}
+
+// columnFromIndex recovers the column from GORM's default index name
+// idx_<table>_<column>, which is all MySQL reports
+func columnFromIndex(table, index string) string {
+	if table != "" {
+		if column, ok := strings.CutPrefix(index, "idx_"+table+"_"); ok {
+			return column
+		}
+	}
+	return ""
+}
+
+// RegisterErrorTranslation makes every query on db return translated errors
+func RegisterErrorTranslation(db *gorm.DB) error {
+	translate := func(tx *gorm.DB) {
+		if tx.Error != nil {
+			tx.Error = Translate(tx.Error)
+		}
+	}
+
+	callbacks := db.Callback()
+	for _, register := range []func() error{
+		func() error { return callbacks.Create().After("*").Register("db:translate_error", translate) },
+		func() error { return callbacks.Query().After("*").Register("db:translate_error", translate) },
+		func() error { return callbacks.Update().After("*").Register("db:translate_error", translate) },
+		func() error { return callbacks.Delete().After("*").Register("db:translate_error", translate) },
+		func() error { return callbacks.Row().After("*").Register("db:translate_error", translate) },
+		func() error { return callbacks.Raw().After("*").Register("db:translate_error", translate) },
+	} {
+		if err := register(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/gorm"
+)
+
+// newTestDB returns a connection to an SQLite database of its own
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	conn, err := Open(context.Background(), Config{Driver: SQLite, Name: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { Close(conn) })
+	return conn
+}
+
+// sqliteError runs the statements and returns the error of the last one, so
+// the SQLite cases are real driver errors rather than hand-built ones
+func sqliteError(t *testing.T, statements ...string) error {
+	t.Helper()
+	conn := newTestDB(t)
+	var err error
+	for _, statement := range statements {
+		err = conn.Exec(statement).Error
+	}
+	if err == nil {
+		t.Fatal("the last statement did not fail")
+	}
+	return err
+}
+
+func TestTranslate(t *testing.T) {
+	users := "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL UNIQUE)"
+	plain := errors.New("connection refused")
+
+	tests := []struct {
+		name       string
+		err        error
+		want       error  // Sentinel the result must match, nil for unique violations
+		wantColumn string // Column of the unique violation
+	}{
+		{
+			name: "postgres unique from detail",
+			err: &pgconn.PgError{Code: pgUniqueViolation, TableName: "users", ConstraintName: "idx_users_email",
+				Detail: "Key (email)=(a@example.com) already exists."},
+			wantColumn: "email",
+		},
+		{
+			name:       "postgres unique from index name",
+			err:        &pgconn.PgError{Code: pgUniqueViolation, TableName: "users", ConstraintName: "idx_users_username"},
+			wantColumn: "username",
+		},
+		{name: "postgres foreign key", err: &pgconn.PgError{Code: pgForeignKeyViolation}, want: ErrForeignKeyViolation},
+		{name: "postgres not null", err: &pgconn.PgError{Code: pgNotNullViolation}, want: ErrNotNull},
+		{name: "postgres deadlock", err: &pgconn.PgError{Code: pgDeadlockDetected}, want: ErrDeadlock},
+		{name: "postgres serialization", err: &pgconn.PgError{Code: pgSerializationFailure}, want: ErrSerialization},
+		{
+			name:       "mysql 8 unique",
+			err:        &mysql.MySQLError{Number: mysqlDuplicateEntry, Message: "Duplicate entry 'a@example.com' for key 'users.idx_users_email'"},
+			wantColumn: "email",
+		},
+		{
+			name: "mysql 5.7 unique",
+			err:  &mysql.MySQLError{Number: mysqlDuplicateEntry, Message: "Duplicate entry 'a@example.com' for key 'idx_users_email'"},
+		},
+		{name: "mysql foreign key", err: &mysql.MySQLError{Number: mysqlNoReferencedRow}, want: ErrForeignKeyViolation},
+		{name: "mysql not null", err: &mysql.MySQLError{Number: mysqlBadNull}, want: ErrNotNull},
+		{name: "mysql deadlock", err: &mysql.MySQLError{Number: mysqlDeadlock}, want: ErrDeadlock},
+		{name: "mysql lock wait timeout", err: &mysql.MySQLError{Number: mysqlLockWaitTimeout}, want: ErrSerialization},
+		{
+			name:       "sqlite unique",
+			err:        sqliteError(t, users, "INSERT INTO users VALUES (1, 'a@example.com')", "INSERT INTO users VALUES (2, 'a@example.com')"),
+			wantColumn: "email",
+		},
+		{
+			name: "sqlite foreign key",
+			err: sqliteError(t, users, "CREATE TABLE otps (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id))",
+				"INSERT INTO otps VALUES (1, 42)"),
+			want: ErrForeignKeyViolation,
+		},
+		{name: "sqlite not null", err: sqliteError(t, users, "INSERT INTO users (id) VALUES (1)"), want: ErrNotNull},
+		{name: "sqlite busy", err: sqlite3.Error{Code: sqlite3.ErrBusy}, want: ErrSerialization},
+		{name: "wrapped", err: fmt.Errorf("creating user: %w", &pgconn.PgError{Code: pgNotNullViolation}), want: ErrNotNull},
+		{name: "other error", err: plain, want: plain},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			translated := Translate(test.err)
+			if !errors.Is(translated, test.err) {
+				t.Errorf("%v does not wrap the driver error", translated)
+			}
+			if test.want != nil {
+				if !errors.Is(translated, test.want) {
+					t.Errorf("got %v, want %v", translated, test.want)
+				}
+				return
+			}
+
+			var unique *ErrUniqueViolation
+			if !errors.As(translated, &unique) {
+				t.Fatalf("got %v, want ErrUniqueViolation", translated)
+			}
+			if unique.Column != test.wantColumn {
+				t.Errorf("column %q, want %q", unique.Column, test.wantColumn)
+			}
+			if Translate(translated) != translated {
+				t.Error("translating twice changed the error")
+			}
+		})
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	err := &pgconn.PgError{Code: pgUniqueViolation, TableName: "users", ConstraintName: "idx_users_email"}
+
+	if !IsUniqueViolation(err) || !IsUniqueViolation(err, "username", "email") {
+		t.Error("the violation on email was not recognised")
+	}
+	if IsUniqueViolation(err, "username") {
+		t.Error("a violation on email matched username")
+	}
+	if IsUniqueViolation(&pgconn.PgError{Code: pgNotNullViolation}) || IsUniqueViolation(nil) {
+		t.Error("another error matched")
+	}
+}
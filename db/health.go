@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Readiness is the outcome of CheckReadiness
+type Readiness struct {
+	Ready              bool     `json:"ready"`
+	Error              string   `json:"error,omitempty"`
+	Version            int64    `json:"version"`        // Highest applied migration, zero when none is
+	LatestVersion      int64    `json:"latest_version"` // Highest registered migration
+	PendingMigrations  []string `json:"pending_migrations,omitempty"`
+	ModifiedMigrations []string `json:"modified_migrations,omitempty"` // Applied migrations edited since
+}
+
+// PoolStats is a snapshot of sql.DBStats
+type PoolStats struct {
+	MaxOpenConnections int           `json:"max_open_connections"`
+	Open               int           `json:"open"`
+	InUse              int           `json:"in_use"`
+	Idle               int           `json:"idle"`
+	WaitCount          int64         `json:"wait_count"`
+	WaitDuration       time.Duration `json:"wait_duration"`
+	MaxIdleClosed      int64         `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64         `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64         `json:"max_lifetime_closed"`
+}
+
+// ReplicaStats is the health and pool of one replica
+type ReplicaStats struct {
+	ReplicaHealth
+	Pool PoolStats `json:"pool"`
+}
+
+// Stats describes the connection pools of a database
+type Stats struct {
+	Driver   string         `json:"driver"`
+	Primary  PoolStats      `json:"primary"`
+	Replicas []ReplicaStats `json:"replicas,omitempty"`
+}
+
+// Ping checks that the primary answers within timeout
+func Ping(ctx context.Context, db *gorm.DB, timeout time.Duration) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return sqlDB.PingContext(ctx)
+}
+
+// CheckReadiness reports whether db can serve requests: the primary answers
+// within timeout and every one of migrations has been applied unmodified.
+// Applied migrations that are not registered, such as those of other
+// components, do not count against it.
+func CheckReadiness(ctx context.Context, db *gorm.DB, migrations []Migration, timeout time.Duration) Readiness {
+	var readiness Readiness
+	if err := Ping(ctx, db, timeout); err != nil {
+		readiness.Error = fmt.Sprintf("pinging database: %v", err)
+		return readiness
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	migrator, err := NewMigrator(db.WithContext(ctx), migrations, MigratorOptions{})
+	if err != nil {
+		readiness.Error = err.Error()
+		return readiness
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		readiness.Error = fmt.Sprintf("reading migrations: %v", err)
+		return readiness
+	}
+
+	for _, status := range statuses {
+		if status.Unknown {
+			continue
+		}
+		readiness.LatestVersion = status.Version
+		switch {
+		case status.AppliedAt == nil:
+			readiness.PendingMigrations = append(readiness.PendingMigrations, fmt.Sprintf("%d_%s", status.Version, status.Name))
+		case status.ChecksumMismatch:
+			readiness.ModifiedMigrations = append(readiness.ModifiedMigrations, fmt.Sprintf("%d_%s", status.Version, status.Name))
+		}
+		if status.AppliedAt != nil {
+			readiness.Version = status.Version
+		}
+	}
+
+	switch {
+	case len(readiness.PendingMigrations) > 0:
+		readiness.Error = fmt.Sprintf("%d pending migration(s)", len(readiness.PendingMigrations))
+	case len(readiness.ModifiedMigrations) > 0:
+		readiness.Error = fmt.Sprintf("%d applied migration(s) modified", len(readiness.ModifiedMigrations))
+	default:
+		readiness.Ready = true
+	}
+	return readiness
+}
+
+// PoolStatus returns the pool statistics of db and each of its replicas
+func PoolStatus(db *gorm.DB) (Stats, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats := Stats{Driver: db.Dialector.Name(), Primary: poolStats(sqlDB.Stats())}
+	if rs, ok := db.Config.Plugins[replicaPluginName].(*replicaSet); ok {
+		for i, health := range rs.status() {
+			stats.Replicas = append(stats.Replicas, ReplicaStats{
+				ReplicaHealth: health,
+				Pool:          poolStats(rs.replicas[i].conn.Stats()),
+			})
+		}
+	}
+	return stats, nil
+}
+
+func poolStats(s sql.DBStats) PoolStats {
+	return PoolStats{
+		MaxOpenConnections: s.MaxOpenConnections,
+		Open:               s.OpenConnections,
+		InUse:              s.InUse,
+		Idle:               s.Idle,
+		WaitCount:          s.WaitCount,
+		WaitDuration:       s.WaitDuration,
+		MaxIdleClosed:      s.MaxIdleClosed,
+		MaxIdleTimeClosed:  s.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  s.MaxLifetimeClosed,
+	}
+}
@@ -0,0 +1,433 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Errors returned by the migrator
+var (
+	ErrChecksumMismatch = errors.New("applied migration has been modified")
+	ErrNoDownMigration  = errors.New("migration cannot be reverted")
+	ErrMigrationLocked  = errors.New("another process is running migrations")
+)
+
+// Migration is one versioned schema change, written either as SQL or as Go
+// functions. Versions are applied in ascending order; timestamps such as
+// 20250601120000 keep independently written migrations apart.
+type Migration struct {
+	Version int64
+	Name    string
+
+	UpSQL   string // Statements separated by semicolons
+	DownSQL string
+
+	Up   func(tx *gorm.DB) error // Used when UpSQL is empty
+	Down func(tx *gorm.DB) error // Used when DownSQL is empty
+
+	NoTransaction bool // Run outside a transaction, e.g. for CREATE INDEX CONCURRENTLY
+}
+
+// Checksum identifies the content of an SQL migration so later edits to an
+// applied migration are detected. Go migrations have no checksum, so keep
+// them to data changes and write schema changes as SQL.
+func (m Migration) Checksum() string {
+	if m.UpSQL == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(m.UpSQL))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%d_%s", m.Version, m.Name)
+}
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	Version    int64     `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Name       string    `gorm:"size:255" json:"name"`
+	Checksum   string    `gorm:"size:64" json:"checksum"`
+	AppliedAt  time.Time `json:"applied_at"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// TableName keeps the conventional name regardless of GORM naming settings
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationStatus describes one migration known to the migrator or the database
+type MigrationStatus struct {
+	Version          int64      `json:"version"`
+	Name             string     `json:"name"`
+	AppliedAt        *time.Time `json:"applied_at"`
+	ChecksumMismatch bool       `json:"checksum_mismatch"`
+	Unknown          bool       `json:"unknown"` // Applied but not registered, e.g. from another component
+}
+
+// MigratorOptions configures a Migrator
+type MigratorOptions struct {
+	DryRun                bool          // Print the SQL of pending migrations instead of running them
+	Output                io.Writer     // Destination of dry-run SQL, required with DryRun
+	AllowChecksumMismatch bool          // Log edited migrations instead of refusing to run
+	LockTimeout           time.Duration // How long to wait for another migrating process, defaults to 1m
+}
+
+// Migrator applies and reverts migrations, holding a database lock so only
+// one replica migrates at a time
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+	options    MigratorOptions
+}
+
+// NewMigrator validates and orders migrations
+func NewMigrator(db *gorm.DB, migrations []Migration, options MigratorOptions) (*Migrator, error) {
+	if options.DryRun && options.Output == nil {
+		return nil, errors.New("dry run needs an output")
+	}
+	if options.LockTimeout == 0 {
+		options.LockTimeout = time.Minute
+	}
+
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 || m.Name == "" {
+			return nil, fmt.Errorf("migration %s needs a positive version and a name", m)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", m.Version, sorted[i-1].Name, m.Name)
+		}
+		if m.UpSQL == "" && m.Up == nil {
+			return nil, fmt.Errorf("migration %s has no up step", m)
+		}
+	}
+
+	// Migrations and the schema_migrations table they record must never be
+	// read from a lagging replica
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Migrator{db: db.WithContext(Primary(ctx)), migrations: sorted, options: options}, nil
+}
+
+// Up applies every pending migration in order and returns how many ran
+func (m *Migrator) Up() (int, error) {
+	applied := 0
+	err := m.locked(func(conn *gorm.DB) error {
+		done, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		if err := m.verify(done); err != nil {
+			return err
+		}
+		if err := m.adoptChecksums(conn, done); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			if err := m.run(conn, migration, true); err != nil {
+				return fmt.Errorf("migration %s: %w", migration, err)
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the most recently applied migrations, at most steps of them
+func (m *Migrator) Down(steps int) (int, error) {
+	reverted := 0
+	err := m.locked(func(conn *gorm.DB) error {
+		done, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+			if migration.DownSQL == "" && migration.Down == nil {
+				return fmt.Errorf("migration %s: %w", migration, ErrNoDownMigration)
+			}
+			if err := m.run(conn, migration, false); err != nil {
+				return fmt.Errorf("reverting migration %s: %w", migration, err)
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists registered and applied migrations by version
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	done, err := m.applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := done[migration.Version]; ok {
+			appliedAt := record.AppliedAt
+			status.AppliedAt = &appliedAt
+			status.ChecksumMismatch = !record.matches(migration)
+			delete(done, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range done {
+		appliedAt := record.AppliedAt
+		statuses = append(statuses, MigrationStatus{Version: record.Version, Name: record.Name, AppliedAt: &appliedAt, Unknown: true})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// applied returns the recorded migrations; a missing table means none
+func (m *Migrator) applied(conn *gorm.DB) (map[int64]SchemaMigration, error) {
+	done := map[int64]SchemaMigration{}
+	if !conn.Migrator().HasTable(&SchemaMigration{}) {
+		return done, nil
+	}
+
+	var records []SchemaMigration
+	if err := conn.Order("version").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		done[record.Version] = record
+	}
+	return done, nil
+}
+
+// verify refuses to run when an applied SQL migration has since been edited
+func (m *Migrator) verify(done map[int64]SchemaMigration) error {
+	for _, migration := range m.migrations {
+		record, ok := done[migration.Version]
+		if !ok || record.matches(migration) {
+			continue
+		}
+		if !m.options.AllowChecksumMismatch {
+			return fmt.Errorf("migration %s: %w", migration, ErrChecksumMismatch)
+		}
+		log.Printf("Warning: applied migration %s has been modified", migration)
+	}
+	return nil
+}
+
+// adoptChecksums records the checksum of SQL migrations that were applied
+// without one, i.e. that were Go migrations when they ran and have since been
+// rewritten as SQL, so edits from now on are detected
+func (m *Migrator) adoptChecksums(conn *gorm.DB, done map[int64]SchemaMigration) error {
+	if m.options.DryRun {
+		return nil
+	}
+	for _, migration := range m.migrations {
+		record, ok := done[migration.Version]
+		if !ok || record.Checksum != "" || migration.Checksum() == "" {
+			continue
+		}
+		err := conn.Model(&SchemaMigration{}).Where("version = ?", migration.Version).
+			Update("checksum", migration.Checksum()).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether the record was applied from the migration's
+// current content. A record without a checksum adopts the migration's.
+func (r SchemaMigration) matches(migration Migration) bool {
+	return r.Checksum == "" || r.Checksum == migration.Checksum()
+}
+
+// run applies or reverts one migration and updates schema_migrations
+func (m *Migrator) run(conn *gorm.DB, migration Migration, up bool) error {
+	sql, fn := migration.UpSQL, migration.Up
+	if !up {
+		sql, fn = migration.DownSQL, migration.Down
+	}
+
+	if m.options.DryRun {
+		return m.printDryRun(conn, migration, up, sql, fn)
+	}
+
+	if !conn.Migrator().HasTable(&SchemaMigration{}) {
+		if err := conn.Migrator().CreateTable(&SchemaMigration{}); err != nil {
+			return err
+		}
+	}
+
+	started := time.Now()
+	apply := func(tx *gorm.DB) error {
+		if sql != "" {
+			for _, statement := range SplitStatements(sql) {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+		} else if err := fn(tx); err != nil {
+			return err
+		}
+
+		if !up {
+			return tx.Delete(&SchemaMigration{}, migration.Version).Error
+		}
+		return tx.Create(&SchemaMigration{
+			Version:    migration.Version,
+			Name:       migration.Name,
+			Checksum:   migration.Checksum(),
+			AppliedAt:  time.Now(),
+			DurationMs: time.Since(started).Milliseconds(),
+		}).Error
+	}
+
+	var err error
+	if migration.NoTransaction {
+		err = apply(conn)
+	} else {
+		err = conn.Transaction(apply)
+	}
+	if err != nil {
+		return err
+	}
+
+	direction := "Applied"
+	if !up {
+		direction = "Reverted"
+	}
+	log.Printf("%s migration %s in %s", direction, migration, time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// printDryRun writes what a migration would execute. Go migrations are run
+// against a dry-run session, so statements that depend on reading the schema
+// may differ from a real run.
+func (m *Migrator) printDryRun(conn *gorm.DB, migration Migration, up bool, sql string, fn func(*gorm.DB) error) error {
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+	fmt.Fprintf(m.options.Output, "-- %s (%s)\n", migration, direction)
+
+	if sql != "" {
+		for _, statement := range SplitStatements(sql) {
+			fmt.Fprintf(m.options.Output, "%s;\n", statement)
+		}
+		fmt.Fprintln(m.options.Output)
+		return nil
+	}
+
+	recorder := &statementRecorder{}
+	if err := fn(conn.Session(&gorm.Session{DryRun: true, Logger: recorder})); err != nil {
+		return err
+	}
+	for _, statement := range recorder.statements {
+		fmt.Fprintf(m.options.Output, "%s;\n", statement)
+	}
+	fmt.Fprintln(m.options.Output)
+	return nil
+}
+
+// locked runs fn on a single connection holding the migration lock.
+// PostgreSQL and MySQL use advisory locks; SQLite has none, so a row in
+// schema_migrations_lock stands in for one. A process that dies while
+// migrating SQLite leaves that row behind, and it has to be deleted by hand.
+func (m *Migrator) locked(fn func(conn *gorm.DB) error) error {
+	if m.options.DryRun {
+		return fn(m.db)
+	}
+
+	return m.db.Connection(func(conn *gorm.DB) error {
+		// Start each statement afresh while staying on the pinned connection
+		conn = conn.Session(&gorm.Session{NewDB: true})
+		key := migrationLockKey()
+		timeout := m.options.LockTimeout
+
+		switch conn.Dialector.Name() {
+		case Postgres:
+			deadline := time.Now().Add(timeout)
+			for {
+				var locked bool
+				if err := conn.Raw("SELECT pg_try_advisory_lock(?)", key).Scan(&locked).Error; err != nil {
+					return err
+				}
+				if locked {
+					break
+				}
+				if time.Now().After(deadline) {
+					return ErrMigrationLocked
+				}
+				time.Sleep(time.Second)
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", key)
+
+		case MySQL:
+			var locked *int
+			name := fmt.Sprintf("schema_migrations_%d", key)
+			if err := conn.Raw("SELECT GET_LOCK(?, ?)", name, int(timeout.Seconds())).Scan(&locked).Error; err != nil {
+				return err
+			}
+			if locked == nil || *locked != 1 {
+				return ErrMigrationLocked
+			}
+			defer conn.Exec("SELECT RELEASE_LOCK(?)", name)
+
+		case SQLite:
+			err := conn.Exec("CREATE TABLE IF NOT EXISTS schema_migrations_lock (id INTEGER PRIMARY KEY, locked_at DATETIME NOT NULL)").Error
+			if err != nil {
+				return err
+			}
+			deadline := time.Now().Add(timeout)
+			for {
+				result := conn.Exec("INSERT OR IGNORE INTO schema_migrations_lock (id, locked_at) VALUES (1, ?)", time.Now())
+				if result.Error != nil && !isRetryable(Translate(result.Error)) {
+					return result.Error
+				}
+				if result.Error == nil && result.RowsAffected == 1 {
+					break
+				}
+				if time.Now().After(deadline) {
+					return ErrMigrationLocked
+				}
+				time.Sleep(time.Second)
+			}
+			defer conn.Exec("DELETE FROM schema_migrations_lock WHERE id = 1")
+		}
+
+		return fn(conn)
+	})
+}
+
+// migrationLockKey is the advisory lock id shared by every process migrating
+// the same database
+func migrationLockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte("schema_migrations"))
+	return int64(h.Sum64() >> 1)
+}
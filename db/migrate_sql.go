@@ -0,0 +1,195 @@
+package db
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sqlMigrationFile matches 20250601120000_add_index.up.sql and the driver
+// specific 20250601120000_add_index.up.postgres.sql
+var sqlMigrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)(?:\.(postgres|mysql|sqlite))?\.sql$`)
+
+// MigrationsDir is the directory of the application's SQL migrations, from
+// MIGRATIONS_DIR and defaulting to "migrations"
+func MigrationsDir() string {
+	if dir := os.Getenv("MIGRATIONS_DIR"); dir != "" {
+		return dir
+	}
+	return "migrations"
+}
+
+// LoadSQLMigrations reads the SQL migrations in a directory of fsys for one
+// driver. A file for the driver replaces the generic file of the same
+// migration, so only statements that differ between databases need copies.
+func LoadSQLMigrations(fsys fs.FS, dir, driver string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type source struct {
+		sql      string
+		specific bool
+	}
+	byVersion := map[int64]*Migration{}
+	sources := map[string]source{}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := sqlMigrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			if path.Ext(entry.Name()) == ".sql" {
+				return nil, fmt.Errorf("migration file %s does not match VERSION_name.up|down[.driver].sql", entry.Name())
+			}
+			continue
+		}
+		if match[4] != "" && match[4] != driver {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s: %w", entry.Name(), err)
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by %s and %s", version, migration.Name, match[2])
+		}
+
+		key := match[1] + "." + match[3]
+		if existing, ok := sources[key]; ok && existing.specific {
+			continue
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sources[key] = source{sql: string(content), specific: match[4] != ""}
+
+		if match[3] == "up" {
+			migration.UpSQL = string(content)
+		} else {
+			migration.DownSQL = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.UpSQL == "" {
+			return nil, fmt.Errorf("migration %s has no up file", migration)
+		}
+		migrations = append(migrations, *migration)
+	}
+	return migrations, nil
+}
+
+// SplitStatements splits SQL on semicolons outside quotes, comments and
+// PostgreSQL dollar-quoted bodies, and drops empty statements
+func SplitStatements(sql string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+	)
+	flush := func() {
+		if statement := strings.TrimSpace(current.String()); statement != "" && !onlyComments(statement) {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for end < len(sql) {
+				if sql[end] == c {
+					// A doubled quote is an escaped quote
+					if end+1 < len(sql) && sql[end+1] == c {
+						end += 2
+						continue
+					}
+					break
+				}
+				if sql[end] == '\\' && c == '\'' {
+					end++
+				}
+				end++
+			}
+			current.WriteString(sql[i:min(end+1, len(sql))])
+			i = end
+			continue
+
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			current.WriteString(sql[i : i+end])
+			i += end - 1
+			continue
+
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				end = len(sql) - i - 4
+			}
+			current.WriteString(sql[i:min(i+end+4, len(sql))])
+			i += end + 3
+			continue
+
+		case c == '$':
+			if tag := dollarTag(sql[i:]); tag != "" {
+				end := strings.Index(sql[i+len(tag):], tag)
+				if end < 0 {
+					end = len(sql) - i - 2*len(tag)
+				}
+				stop := min(i+len(tag)+end+len(tag), len(sql))
+				current.WriteString(sql[i:stop])
+				i = stop - 1
+				continue
+			}
+
+		case c == ';':
+			flush()
+			continue
+		}
+		current.WriteByte(c)
+	}
+	flush()
+	return statements
+}
+
+// dollarTag returns the $tag$ opening a dollar-quoted string, if s starts with one
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1]
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
+// onlyComments reports whether a statement holds nothing but line comments
+func onlyComments(statement string) bool {
+	for _, line := range strings.Split(statement, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// testMigrations creates two tables, the second with a row in it
+func testMigrations() []Migration {
+	return []Migration{
+		{Version: 1, Name: "create_one", UpSQL: "CREATE TABLE one (id INTEGER PRIMARY KEY)", DownSQL: "DROP TABLE one"},
+		{Version: 2, Name: "create_two", UpSQL: "CREATE TABLE two (id INTEGER PRIMARY KEY); INSERT INTO two VALUES (1)", DownSQL: "DROP TABLE two"},
+	}
+}
+
+func TestMigratorChecksumMismatch(t *testing.T) {
+	conn := newTestDB(t)
+	migrator, err := NewMigrator(conn, testMigrations(), MigratorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := migrator.Up(); err != nil || n != 2 {
+		t.Fatalf("applied %d, %v", n, err)
+	}
+
+	edited := testMigrations()
+	edited[0].UpSQL = "CREATE TABLE one (id INTEGER PRIMARY KEY, name TEXT)"
+	edited = append(edited, Migration{Version: 3, Name: "create_three", UpSQL: "CREATE TABLE three (id INTEGER PRIMARY KEY)"})
+
+	tests := []struct {
+		name        string
+		options     MigratorOptions
+		wantErr     error
+		wantApplied int
+	}{
+		{name: "refused", wantErr: ErrChecksumMismatch},
+		{name: "allowed", options: MigratorOptions{AllowChecksumMismatch: true}, wantApplied: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrator, err := NewMigrator(conn, edited, test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := migrator.Up()
+			if !errors.Is(err, test.wantErr) || n != test.wantApplied {
+				t.Errorf("applied %d, %v; want %d, %v", n, err, test.wantApplied, test.wantErr)
+			}
+
+			statuses, err := migrator.Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !statuses[0].ChecksumMismatch || statuses[1].ChecksumMismatch {
+				t.Errorf("statuses %+v, want only the first mismatched", statuses)
+			}
+		})
+	}
+}
+
+func TestMigratorAdoptsMissingChecksums(t *testing.T) {
+	conn := newTestDB(t)
+	migrations := testMigrations()
+	migrations[0].UpSQL = ""
+	migrations[0].Up = func(tx *gorm.DB) error { return tx.Exec("CREATE TABLE one (id INTEGER PRIMARY KEY)").Error }
+	migrator, err := NewMigrator(conn, migrations[:1], MigratorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewritten as SQL, the migration adopts the checksum and edits are caught
+	if migrator, err = NewMigrator(conn, testMigrations()[:1], MigratorOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("adopting the checksum: %v", err)
+	}
+	edited := testMigrations()[:1]
+	edited[0].UpSQL += " WITHOUT ROWID"
+	if migrator, err = NewMigrator(conn, edited, MigratorOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestMigratorLockOnSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	open := func() *Migrator {
+		t.Helper()
+		conn, err := Open(context.Background(), Config{Driver: SQLite, Name: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { Close(conn) })
+		migrator, err := NewMigrator(conn, testMigrations(), MigratorOptions{LockTimeout: 10 * time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return migrator
+	}
+
+	// Processes migrating at once take turns, so each migration runs once
+	migrators := []*Migrator{open(), open(), open()}
+	applied := make([]int, len(migrators))
+	errs := make([]error, len(migrators))
+	var wg sync.WaitGroup
+	for i, migrator := range migrators {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			applied[i], errs[i] = migrator.Up()
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for i := range migrators {
+		if errs[i] != nil {
+			t.Errorf("migrator %d: %v", i, errs[i])
+		}
+		total += applied[i]
+	}
+	if total != 2 {
+		t.Errorf("applied %v, want each migration once", applied)
+	}
+
+	// A held lock makes others give up after LockTimeout
+	conn := migrators[0].db
+	if err := conn.Exec("INSERT INTO schema_migrations_lock (id, locked_at) VALUES (1, ?)", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	waiting, err := NewMigrator(conn, testMigrations(), MigratorOptions{LockTimeout: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := waiting.Down(1); !errors.Is(err, ErrMigrationLocked) {
+		t.Errorf("got %v, want ErrMigrationLocked", err)
+	}
+
+	if err := conn.Exec("DELETE FROM schema_migrations_lock").Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := waiting.Down(1); err != nil || n != 1 {
+		t.Errorf("reverted %d, %v after the lock was released", n, err)
+	}
+}
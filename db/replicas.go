@@ -0,0 +1,490 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// Replica load-balancing policies
+const (
+	PolicyRandom           = "random"
+	PolicyRoundRobin       = "round_robin"
+	PolicyLeastConnections = "least_connections"
+)
+
+// replicaPluginName identifies the replica set among the GORM plugins
+const replicaPluginName = "db:replicas"
+
+// ReplicaHealth is the last health check result of one replica
+type ReplicaHealth struct {
+	Name      string        `json:"name"`
+	Healthy   bool          `json:"healthy"`
+	Lag       time.Duration `json:"lag"`
+	Error     string        `json:"error,omitempty"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// replica is one read replica and its health
+type replica struct {
+	name    string
+	conn    *sql.DB
+	healthy atomic.Bool
+
+	mu     sync.Mutex
+	health ReplicaHealth
+}
+
+// replicaSet routes reads to healthy replicas and writes to the primary.
+// Routing itself is done by GORM's dbresolver; the set adds health checks,
+// read-your-writes and the Primary escape hatch on top.
+type replicaSet struct {
+	driver   string
+	replicas []*replica
+	byConn   map[gorm.ConnPool]*replica
+	policy   dbresolver.Policy
+	maxLag   time.Duration
+	interval time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newReplicaSet opens a connection pool per replica without waiting for them;
+// a replica that is down starts out unhealthy
+func newReplicaSet(config Config, driver string) (*replicaSet, error) {
+	rs := &replicaSet{
+		driver:   driver,
+		byConn:   map[gorm.ConnPool]*replica{},
+		maxLag:   config.ReplicaMaxLag,
+		interval: config.ReplicaCheckInterval,
+		stop:     make(chan struct{}),
+	}
+	if rs.maxLag <= 0 {
+		rs.maxLag = 10 * time.Second
+	}
+	if rs.interval <= 0 {
+		rs.interval = 15 * time.Second
+	}
+
+	switch config.ReplicaPolicy {
+	case "", PolicyRandom:
+		rs.policy = dbresolver.RandomPolicy{}
+	case PolicyRoundRobin:
+		rs.policy = dbresolver.RoundRobinPolicy()
+	case PolicyLeastConnections:
+		rs.policy = dbresolver.PolicyFunc(leastConnections)
+	default:
+		return nil, fmt.Errorf("unknown replica policy %q", config.ReplicaPolicy)
+	}
+
+	for i, replicaURL := range config.Replicas {
+		replicaConfig := config
+		replicaConfig.Driver, replicaConfig.URL, replicaConfig.Replicas = driver, replicaURL, nil
+
+		_, dsn, err := replicaConfig.dsn()
+		if err != nil {
+			rs.close()
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		conn, err := sql.Open(sqlDriverName(driver), dsn)
+		if err != nil {
+			rs.close()
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		replicaConfig.applyPool(conn)
+
+		r := &replica{name: replicaName(i, replicaURL), conn: conn}
+		r.health.Name = r.name
+		rs.replicas = append(rs.replicas, r)
+		rs.byConn[conn] = r
+	}
+	return rs, nil
+}
+
+func (rs *replicaSet) Name() string {
+	return replicaPluginName
+}
+
+// Initialize registers dbresolver with the replicas, the routing callbacks
+// and starts the health checks
+func (rs *replicaSet) Initialize(db *gorm.DB) error {
+	dialectors := make([]gorm.Dialector, 0, len(rs.replicas))
+	for _, r := range rs.replicas {
+		dialectors = append(dialectors, existingConnDialector(rs.driver, r.conn))
+	}
+	if err := db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: dialectors,
+		Policy:   dbresolver.PolicyFunc(rs.resolve),
+	})); err != nil {
+		return err
+	}
+
+	// Before("*") prepends, so route and pin run ahead of dbresolver's own
+	// callback; unpin runs after it, just ahead of the statement itself
+	callbacks := db.Callback()
+	for _, register := range []func() error{
+		func() error { return callbacks.Query().Before("*").Register("db:route", rs.route) },
+		func() error { return callbacks.Row().Before("*").Register("db:route", rs.route) },
+		func() error { return callbacks.Raw().Before("*").Register("db:route", rs.route) },
+		func() error { return callbacks.Create().Before("*").Register("db:pin", pin) },
+		func() error { return callbacks.Query().Before("*").Register("db:pin", pin) },
+		func() error { return callbacks.Update().Before("*").Register("db:pin", pin) },
+		func() error { return callbacks.Delete().Before("*").Register("db:pin", pin) },
+		func() error { return callbacks.Row().Before("*").Register("db:pin", pin) },
+		func() error { return callbacks.Raw().Before("*").Register("db:pin", pin) },
+		func() error { return callbacks.Create().Before("gorm:begin_transaction").Register("db:unpin", unpin) },
+		func() error { return callbacks.Query().Before("gorm:query").Register("db:unpin", unpin) },
+		func() error { return callbacks.Update().Before("gorm:begin_transaction").Register("db:unpin", unpin) },
+		func() error { return callbacks.Delete().Before("gorm:begin_transaction").Register("db:unpin", unpin) },
+		func() error { return callbacks.Row().Before("gorm:row").Register("db:unpin", unpin) },
+		func() error { return callbacks.Raw().Before("gorm:raw").Register("db:unpin", unpin) },
+		func() error { return callbacks.Create().After("*").Register("db:track_write", trackWrite) },
+		func() error { return callbacks.Update().After("*").Register("db:track_write", trackWrite) },
+		func() error { return callbacks.Delete().After("*").Register("db:track_write", trackWrite) },
+		func() error { return callbacks.Raw().After("*").Register("db:track_write", trackWrite) },
+	} {
+		if err := register(); err != nil {
+			return err
+		}
+	}
+
+	rs.checkAll()
+	go rs.monitor()
+	return nil
+}
+
+// route sends a read to the primary when the context asks for it or no
+// replica is healthy
+func (rs *replicaSet) route(tx *gorm.DB) {
+	if pinned(tx.Statement.ConnPool) {
+		return
+	}
+	if usePrimary(tx.Statement.Context) || !rs.anyHealthy() {
+		dbresolver.Write.ModifyStatement(tx.Statement)
+	}
+}
+
+// pinnedPoolKey holds the connection pin saved for unpin
+const pinnedPoolKey = "db:pinned_pool"
+
+// pinned reports whether pool is a single connection taken from the primary,
+// as inside DB.Connection, rather than the primary's pool. dbresolver only
+// leaves transactions alone and would send the statement elsewhere, away from
+// the session state and advisory locks held on that connection.
+func pinned(pool gorm.ConnPool) bool {
+	switch pool.(type) {
+	case *sql.DB, *gorm.PreparedStmtDB:
+		return false
+	}
+	return true
+}
+
+// pin saves a pinned connection before dbresolver replaces it
+func pin(tx *gorm.DB) {
+	if pinned(tx.Statement.ConnPool) {
+		tx.Statement.Settings.Store(pinnedPoolKey, tx.Statement.ConnPool)
+	}
+}
+
+// unpin puts back the connection saved by pin
+func unpin(tx *gorm.DB) {
+	if pool, ok := tx.Statement.Settings.LoadAndDelete(pinnedPoolKey); ok {
+		tx.Statement.ConnPool = pool.(gorm.ConnPool)
+	}
+}
+
+// resolve applies the load-balancing policy to the healthy replicas
+func (rs *replicaSet) resolve(pools []gorm.ConnPool) gorm.ConnPool {
+	healthy := make([]gorm.ConnPool, 0, len(pools))
+	for _, pool := range pools {
+		if r, ok := rs.byConn[pool]; !ok || r.healthy.Load() {
+			healthy = append(healthy, pool)
+		}
+	}
+	// A replica may have failed since route looked; any replica beats none
+	if len(healthy) == 0 {
+		healthy = pools
+	}
+	return rs.policy.Resolve(healthy)
+}
+
+func (rs *replicaSet) anyHealthy() bool {
+	for _, r := range rs.replicas {
+		if r.healthy.Load() {
+			return true
+		}
+	}
+	return false
+}
+
+// monitor re-checks every replica until the set is closed
+func (rs *replicaSet) monitor() {
+	ticker := time.NewTicker(rs.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rs.stop:
+			return
+		case <-ticker.C:
+			rs.checkAll()
+		}
+	}
+}
+
+func (rs *replicaSet) checkAll() {
+	var wg sync.WaitGroup
+	for _, r := range rs.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			rs.check(r)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// check pings a replica and measures its replication lag
+func (rs *replicaSet) check(r *replica) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	health := ReplicaHealth{Name: r.name, CheckedAt: time.Now()}
+	err := r.conn.PingContext(ctx)
+	if err == nil {
+		health.Lag, err = replicationLag(ctx, rs.driver, r.conn)
+	}
+	if err == nil && health.Lag > rs.maxLag {
+		err = fmt.Errorf("replication lag %s exceeds %s", health.Lag.Round(time.Millisecond), rs.maxLag)
+	}
+	health.Healthy = err == nil
+	if err != nil {
+		health.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	first := r.health.CheckedAt.IsZero()
+	r.health = health
+	r.mu.Unlock()
+
+	if was := r.healthy.Swap(health.Healthy); was != health.Healthy || first {
+		switch {
+		case !health.Healthy:
+			log.Printf("Database replica %s removed from reads: %v", r.name, err)
+		case !first:
+			log.Printf("Database replica %s is healthy again", r.name)
+		}
+	}
+}
+
+func (rs *replicaSet) status() []ReplicaHealth {
+	statuses := make([]ReplicaHealth, 0, len(rs.replicas))
+	for _, r := range rs.replicas {
+		r.mu.Lock()
+		statuses = append(statuses, r.health)
+		r.mu.Unlock()
+	}
+	return statuses
+}
+
+func (rs *replicaSet) close() {
+	rs.stopOnce.Do(func() { close(rs.stop) })
+	for _, r := range rs.replicas {
+		r.conn.Close()
+	}
+}
+
+// replicationLag returns how far a replica is behind its primary
+func replicationLag(ctx context.Context, driver string, conn *sql.DB) (time.Duration, error) {
+	switch driver {
+	case Postgres:
+		// An idle primary writes nothing to replay, so a replica that has
+		// replayed everything it received is not lagging however old the
+		// last transaction is
+		var seconds float64
+		err := conn.QueryRowContext(ctx, `SELECT COALESCE(CASE
+			WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+		END, 0)`).Scan(&seconds)
+		return time.Duration(seconds * float64(time.Second)), err
+
+	case MySQL:
+		rows, err := conn.QueryContext(ctx, "SHOW REPLICA STATUS")
+		if err != nil {
+			// Before MySQL 8.0.22
+			if rows, err = conn.QueryContext(ctx, "SHOW SLAVE STATUS"); err != nil {
+				return 0, err
+			}
+		}
+		defer rows.Close()
+
+		columns, err := rows.Columns()
+		if err != nil || !rows.Next() {
+			// Not configured as a replica
+			return 0, err
+		}
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return 0, err
+		}
+		for i, column := range columns {
+			if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+				continue
+			}
+			if !values[i].Valid {
+				return 0, errors.New("replication is not running")
+			}
+			var seconds int64
+			if _, err := fmt.Sscan(values[i].String, &seconds); err != nil {
+				return 0, err
+			}
+			return time.Duration(seconds) * time.Second, nil
+		}
+	}
+
+	// SQLite replicas are file copies without a replication stream
+	return 0, nil
+}
+
+// leastConnections picks the pool with the fewest connections in use
+func leastConnections(pools []gorm.ConnPool) gorm.ConnPool {
+	best, bestInUse := pools[rand.Intn(len(pools))], -1
+	for _, pool := range pools {
+		conn, ok := pool.(*sql.DB)
+		if !ok {
+			continue
+		}
+		if inUse := conn.Stats().InUse; bestInUse < 0 || inUse < bestInUse {
+			best, bestInUse = pool, inUse
+		}
+	}
+	return best
+}
+
+// existingConnDialector wraps an open pool in the driver's GORM dialector
+func existingConnDialector(driver string, conn *sql.DB) gorm.Dialector {
+	switch driver {
+	case MySQL:
+		// Skip the version query so a replica that is down does not fail startup
+		return mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true})
+	case SQLite:
+		// Reads the SQLite version, so a replica file must exist at startup
+		return &sqlite.Dialector{Conn: conn}
+	}
+	return postgres.New(postgres.Config{Conn: conn})
+}
+
+// sqlDriverName is the database/sql driver registered by each GORM driver
+func sqlDriverName(driver string) string {
+	switch driver {
+	case MySQL:
+		return "mysql"
+	case SQLite:
+		return "sqlite3"
+	}
+	return "pgx"
+}
+
+// replicaName labels a replica in logs and status output without credentials
+func replicaName(i int, replicaURL string) string {
+	name := fmt.Sprintf("replica-%d", i+1)
+	if u, err := url.Parse(replicaURL); err == nil && u.Host != "" {
+		name += " (" + u.Host + ")"
+	}
+	return name
+}
+
+// ReplicaStatus returns the health of each replica of db, or nil without replicas
+func ReplicaStatus(db *gorm.DB) []ReplicaHealth {
+	if rs, ok := db.Config.Plugins[replicaPluginName].(*replicaSet); ok {
+		return rs.status()
+	}
+	return nil
+}
+
+// Close stops the replica health checks and closes every connection pool of db
+func Close(db *gorm.DB) error {
+	if rs, ok := db.Config.Plugins[replicaPluginName].(*replicaSet); ok {
+		rs.close()
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// routing is the per-context read routing state
+type routing struct {
+	primary bool        // Always read from the primary
+	wrote   atomic.Bool // A write has happened; read-your-writes sends reads to the primary
+}
+
+type routingKey struct{}
+
+// Primary returns a context whose queries all go to the primary, for reads
+// that must not see replication lag
+func Primary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, routingKey{}, &routing{primary: true})
+}
+
+// WithReadYourWrites returns a context in which reads go to the primary once
+// a write has been made through it, so a request sees its own changes
+func WithReadYourWrites(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(routingKey{}).(*routing); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, routingKey{}, &routing{})
+}
+
+// ReadYourWritesMiddleware gives every request a read-your-writes context
+func ReadYourWritesMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithReadYourWrites(r.Context())))
+	})
+}
+
+func usePrimary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	state, ok := ctx.Value(routingKey{}).(*routing)
+	return ok && (state.primary || state.wrote.Load())
+}
+
+// trackWrite records a successful write in the statement's context
+func trackWrite(tx *gorm.DB) {
+	if tx.Error != nil || tx.Statement.Context == nil {
+		return
+	}
+	state, ok := tx.Statement.Context.Value(routingKey{}).(*routing)
+	if !ok {
+		return
+	}
+
+	// Raw covers Exec; reads through Raw are routed, not tracked
+	if sql := strings.TrimSpace(tx.Statement.SQL.String()); len(sql) >= 6 && strings.EqualFold(sql[:6], "select") {
+		return
+	}
+	state.wrote.Store(true)
+}
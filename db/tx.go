@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Retry limits of WithTx
+const (
+	maxTxAttempts = 5
+	txRetryDelay  = 20 * time.Millisecond
+)
+
+// WithTx runs fn in a transaction on conn, committing when fn returns nil and
+// rolling back otherwise. Transactions that fail with ErrSerialization or
+// ErrDeadlock are retried from the start with a growing, jittered delay, so fn
+// must leave effects outside the database until WithTx has returned.
+//
+// When conn is already a transaction, fn runs in a savepoint instead and a
+// retryable failure is returned for the outermost WithTx to retry, as the
+// database has usually aborted the whole transaction by then.
+func WithTx(ctx context.Context, conn *gorm.DB, fn func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	conn = conn.WithContext(ctx)
+	if InTransaction(conn) {
+		return Translate(conn.Transaction(fn))
+	}
+
+	delay := txRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := Translate(conn.Transaction(fn, opts...))
+		if err == nil || attempt == maxTxAttempts || !isRetryable(err) {
+			return err
+		}
+
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay)))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// InTransaction reports whether conn runs its statements in a transaction
+func InTransaction(conn *gorm.DB) bool {
+	committer, ok := conn.Statement.ConnPool.(gorm.TxCommitter)
+	return ok && committer != nil
+}
+
+// ForUpdate locks the rows a query reads until the transaction ends. SQLite
+// has no row locks; its single writer serialises the transactions instead.
+func ForUpdate(tx *gorm.DB) *gorm.DB {
+	return tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate})
+}
+
+// isRetryable reports whether a transaction failed only because it collided
+// with a concurrent one
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrSerialization) || errors.Is(err, ErrDeadlock)
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// newItemsDB returns a test database with an items table
+func newItemsDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	conn := newTestDB(t)
+	if err := conn.Exec("CREATE TABLE items (name TEXT PRIMARY KEY)").Error; err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// itemNames returns the names in the items table in order
+func itemNames(t *testing.T, conn *gorm.DB) []string {
+	t.Helper()
+	var names []string
+	if err := conn.Raw("SELECT name FROM items ORDER BY name").Scan(&names).Error; err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestWithTxRetries(t *testing.T) {
+	conflict := fmt.Errorf("conflict: %w", ErrSerialization)
+	other := errors.New("other failure")
+
+	tests := []struct {
+		name         string
+		failures     int   // Attempts that fail before one succeeds
+		failWith     error // Error of the failing attempts
+		wantAttempts int
+		wantErr      error
+	}{
+		{name: "success", wantAttempts: 1},
+		{name: "serialization failure", failures: 2, failWith: conflict, wantAttempts: 3},
+		{name: "deadlock", failures: 1, failWith: fmt.Errorf("deadlock: %w", ErrDeadlock), wantAttempts: 2},
+		{name: "gives up", failures: maxTxAttempts, failWith: conflict, wantAttempts: maxTxAttempts, wantErr: ErrSerialization},
+		{name: "not retryable", failures: 1, failWith: other, wantAttempts: 1, wantErr: other},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := newItemsDB(t)
+			attempts := 0
+			err := WithTx(context.Background(), conn, func(tx *gorm.DB) error {
+				attempts++
+				if err := tx.Exec("INSERT INTO items VALUES (?)", "item").Error; err != nil {
+					return err
+				}
+				if attempts <= test.failures {
+					return test.failWith
+				}
+				return nil
+			})
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got %v, want %v", err, test.wantErr)
+			}
+			if attempts != test.wantAttempts {
+				t.Errorf("%d attempts, want %d", attempts, test.wantAttempts)
+			}
+			// Failed attempts are rolled back, so the insert is never duplicated
+			wantItems := 1
+			if test.wantErr != nil {
+				wantItems = 0
+			}
+			if names := itemNames(t, conn); len(names) != wantItems {
+				t.Errorf("items %v, want %d", names, wantItems)
+			}
+		})
+	}
+}
+
+func TestWithTxCancelledWhileWaiting(t *testing.T) {
+	conn := newItemsDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	attempts := 0
+	err := WithTx(ctx, conn, func(tx *gorm.DB) error {
+		attempts++
+		cancel()
+		return ErrSerialization
+	})
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrSerialization) {
+		t.Errorf("got %v, want both the cancellation and the last failure", err)
+	}
+	if attempts != 1 {
+		t.Errorf("%d attempts after cancelling, want 1", attempts)
+	}
+}
+
+func TestWithTxSavepoints(t *testing.T) {
+	ctx := context.Background()
+	failure := errors.New("failure")
+
+	tests := []struct {
+		name      string
+		nested    error // Returned by the nested WithTx
+		wantOuter error // Returned by the outer fn
+		wantItems []string
+	}{
+		{name: "nested commit", wantItems: []string{"inner", "outer"}},
+		{name: "nested rollback", nested: failure, wantItems: []string{"outer"}},
+		{name: "nested failure fails the outer", nested: failure, wantOuter: failure, wantItems: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := newItemsDB(t)
+			err := WithTx(ctx, conn, func(tx *gorm.DB) error {
+				if !InTransaction(tx) {
+					t.Error("fn is not in a transaction")
+				}
+				if err := tx.Exec("INSERT INTO items VALUES ('outer')").Error; err != nil {
+					return err
+				}
+				err := WithTx(ctx, tx, func(nested *gorm.DB) error {
+					if err := nested.Exec("INSERT INTO items VALUES ('inner')").Error; err != nil {
+						return err
+					}
+					return test.nested
+				})
+				if !errors.Is(err, test.nested) {
+					t.Errorf("nested: got %v, want %v", err, test.nested)
+				}
+				return test.wantOuter
+			})
+			if !errors.Is(err, test.wantOuter) {
+				t.Errorf("got %v, want %v", err, test.wantOuter)
+			}
+
+			names := itemNames(t, conn)
+			if fmt.Sprint(names) != fmt.Sprint(test.wantItems) {
+				t.Errorf("items %v, want %v", names, test.wantItems)
+			}
+		})
+	}
+
+	if InTransaction(newTestDB(t)) {
+		t.Error("a plain connection reports a transaction")
+	}
+}
+
+func TestWithTxNestedRetryIsLeftToTheOutermost(t *testing.T) {
+	conn := newItemsDB(t)
+	outer, inner := 0, 0
+	err := WithTx(context.Background(), conn, func(tx *gorm.DB) error {
+		outer++
+		return WithTx(context.Background(), tx, func(nested *gorm.DB) error {
+			inner++
+			if outer == 1 {
+				return ErrDeadlock
+			}
+			return nested.Exec("INSERT INTO items VALUES ('item')").Error
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The savepoint is not retried on its own: the whole transaction is
+	if outer != 2 || inner != 2 {
+		t.Errorf("outer ran %d times and inner %d, want 2 each", outer, inner)
+	}
+}
@@ -0,0 +1,20 @@
+package dto
+
+type AdminCreateUserRequest struct {
+	Username    string `json:"username"`
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	IsActive    *bool  `json:"is_active"`
+	IsSuperuser bool   `json:"is_superuser"`
+}
+
+type AdminUpdateUserRequest struct {
+	Username    *string `json:"username"`
+	Email       *string `json:"email"`
+	FirstName   *string `json:"first_name"`
+	LastName    *string `json:"last_name"`
+	IsActive    *bool   `json:"is_active"`
+	IsSuperuser *bool   `json:"is_superuser"`
+}
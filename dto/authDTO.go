@@ -18,7 +18,27 @@ type RegisterRequest struct {
 }
 
 type TokenResponse struct {
-	Token     string    `json:"token"`
+	Token     string    `json:"token,omitempty"` // Omitted in cookie mode
 	ExpiresAt time.Time `json:"expires_at"`
-	UserID    uint64     `json:"user_id"`
+	UserID    uint64    `json:"user_id"`
+	CSRFToken string    `json:"csrf_token,omitempty"` // Cookie mode only; send back in X-CSRF-Token
+}
+
+type LoginChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	OTP            string `json:"otp"`
+}
+
+type StepUpResponse struct {
+	Message        string    `json:"message"`
+	Error          string    `json:"error"`
+	ChallengeToken string    `json:"challenge_token"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	Signals        []string  `json:"signals"`
+}
+
+type ReauthenticateRequest struct {
+	Password string `json:"password"`
+	OTP      string `json:"otp"`
+	SendOTP  bool   `json:"send_otp"` // Only send a code to the user's email
 }
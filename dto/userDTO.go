@@ -6,8 +6,16 @@ type ChangePasswordRequest struct {
 }
 
 type UpdateUserProfileRequest struct {
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Email     string `json:"email"`
-	Username  string `json:"username"`
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Email     *string `json:"email"`
+	Username  *string `json:"username"`
+}
+
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token"`
+}
+
+type AccountDeletionRequest struct {
+	Password string `json:"password"`
 }
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"os"
+)
+
+// The page templates and static files are compiled into the binary
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// embeddedDir returns one embedded directory, or the same directory on disk
+// when files are reloaded for development
+func embeddedDir(files embed.FS, dir string, reload bool) fs.FS {
+	if reload {
+		return os.DirFS(dir)
+	}
+	sub, err := fs.Sub(files, dir)
+	if err != nil {
+		log.Fatalf("Failed to load embedded %s: %v", dir, err)
+	}
+	return sub
+}
@@ -6,9 +6,11 @@ import (
 	"net/http"
 	"time"
 	"os"
+	"strconv"
 	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
 	"github.com/rb4807/Golang-Utlis-Postgresql/db"
 	"github.com/rb4807/Golang-Utlis-Postgresql/router"
+	"github.com/rb4807/Golang-Utlis-Postgresql/web"
 )
 
 func main() {
@@ -26,19 +28,71 @@ func main() {
 		log.Fatal("JWT_SECRET environment variable is required")
 	}
 
+	// Bound the database work of each auth call, e.g. AUTH_QUERY_TIMEOUT=5s
+	var queryTimeout time.Duration
+	if value := os.Getenv("AUTH_QUERY_TIMEOUT"); value != "" {
+		var err error
+		if queryTimeout, err = time.ParseDuration(value); err != nil {
+			log.Fatalf("Invalid AUTH_QUERY_TIMEOUT: %v", err)
+		}
+	}
+
+	// Number of proxies in front of the server, e.g. TRUSTED_PROXY_HOPS=2 behind a CDN and a load balancer
+	var trustedProxyHops int
+	if value := os.Getenv("TRUSTED_PROXY_HOPS"); value != "" {
+		var err error
+		if trustedProxyHops, err = strconv.Atoi(value); err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXY_HOPS: %v", err)
+		}
+	}
+
 	// Initialize auth service
 	authService, err := auth.NewService(auth.Config{
 		JWTSecret:     jwtSecret,
 		TokenDuration: 24 * time.Hour,
 		DB:            database, // Use the correct field name (DB instead of DBConnection)
+
+		AuditHashChain:    os.Getenv("AUDIT_HASH_CHAIN") == "true",
+		TrustProxyHeaders: os.Getenv("TRUST_PROXY_HEADERS") == "true",
+		TrustedProxyHops:  trustedProxyHops,
+		GeoIPPath:         os.Getenv("GEOIP_DB_PATH"),
+		LoginRisk:         auth.RiskPolicy{Enabled: os.Getenv("LOGIN_RISK_ENABLED") == "true"},
+		CookieMode:        os.Getenv("AUTH_COOKIE_MODE") == "true",
+		CookieDomain:      os.Getenv("AUTH_COOKIE_DOMAIN"),
+		CookieInsecure:    os.Getenv("AUTH_COOKIE_INSECURE") == "true",
+		RefreshCookiePath: "/", // The web pages renew sessions too
+		QueryTimeout:      queryTimeout,
 	})
 	if err != nil {
 		log.Fatalf("Failed to initialize auth service: %v", err)
 	}
 
+	// Erase accounts whose deletion grace period has ended
+	stopErasure := authService.StartErasureWorker(time.Hour)
+	defer stopErasure()
+
+	// Remove soft-deleted users once they can no longer be restored
+	stopPurge := authService.StartPurgeWorker(time.Hour)
+	defer stopPurge()
+
+	// Deliver outbox events to webhook subscribers
+	stopWebhooks := authService.StartWebhookDispatcher(auth.WebhookOptions{})
+	defer stopWebhooks()
+
+
+	// Server-rendered login, registration and account pages
+	reload := os.Getenv("TEMPLATE_RELOAD") == "true"
+	webApp, err := web.New(authService, web.Options{
+		Templates: embeddedDir(embeddedTemplates, "templates", reload),
+		Static:    embeddedDir(embeddedStatic, "static", reload),
+		Reload:    reload,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize web pages: %v", err)
+	}
 
 	// Set up routes with all middleware applied
-	r := router.InitRoutes(authService)
+	r := router.InitRoutes(authService, webApp, database)
 
 	// Start server
 	fmt.Println("Server starting on port 8080...")
@@ -0,0 +1,33 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+	"github.com/rb4807/Golang-Utlis-Postgresql/controller"
+)
+
+func AdminRoutes(mux *http.ServeMux, authService *auth.Service) {
+	baseAppPath := "/api/admin"
+
+	// Protected
+	mux.Handle(fmt.Sprintf("%s/list_users", baseAppPath), authService.AdminMiddleware(controller.AdminListUsers(authService)))
+	mux.Handle(fmt.Sprintf("%s/get_user", baseAppPath), authService.AdminMiddleware(controller.AdminGetUser(authService)))
+	mux.Handle(fmt.Sprintf("%s/create_user", baseAppPath), authService.AdminMiddleware(controller.AdminCreateUser(authService)))
+	mux.Handle(fmt.Sprintf("%s/update_user", baseAppPath), authService.AdminMiddleware(controller.AdminUpdateUser(authService)))
+	mux.Handle(fmt.Sprintf("%s/deactivate_user", baseAppPath), authService.AdminMiddleware(controller.AdminDeactivateUser(authService)))
+	mux.Handle(fmt.Sprintf("%s/delete_user", baseAppPath), authService.AdminMiddleware(controller.AdminDeleteUser(authService)))
+	mux.Handle(fmt.Sprintf("%s/deleted_users", baseAppPath), authService.AdminMiddleware(controller.AdminListDeletedUsers(authService)))
+	mux.Handle(fmt.Sprintf("%s/restore_user", baseAppPath), authService.AdminMiddleware(controller.AdminRestoreUser(authService)))
+	mux.Handle(fmt.Sprintf("%s/purge_deleted_users", baseAppPath), authService.AdminMiddleware(controller.AdminPurgeDeletedUsers(authService)))
+	mux.Handle(fmt.Sprintf("%s/impersonate_user", baseAppPath), authService.AdminMiddleware(controller.AdminImpersonateUser(authService)))
+	mux.Handle(fmt.Sprintf("%s/impersonations", baseAppPath), authService.AdminMiddleware(controller.AdminListImpersonations(authService)))
+	mux.Handle(fmt.Sprintf("%s/audit_events", baseAppPath), authService.AdminMiddleware(controller.AdminListAuditEvents(authService)))
+	mux.Handle(fmt.Sprintf("%s/verify_audit_chain", baseAppPath), authService.AdminMiddleware(controller.AdminVerifyAuditChain(authService)))
+	mux.Handle(fmt.Sprintf("%s/create_webhook", baseAppPath), authService.AdminMiddleware(controller.CreateWebhook(authService)))
+	mux.Handle(fmt.Sprintf("%s/list_webhooks", baseAppPath), authService.AdminMiddleware(controller.ListWebhooks(authService)))
+	mux.Handle(fmt.Sprintf("%s/delete_webhook", baseAppPath), authService.AdminMiddleware(controller.DeleteWebhook(authService)))
+	mux.Handle(fmt.Sprintf("%s/webhook_deliveries", baseAppPath), authService.AdminMiddleware(controller.ListWebhookDeliveries(authService)))
+	mux.Handle(fmt.Sprintf("%s/webhook_attempts", baseAppPath), authService.AdminMiddleware(controller.ListWebhookAttempts(authService)))
+}
@@ -14,8 +14,12 @@ func AuthRoutes(mux *http.ServeMux, authService *auth.Service) {
 	// Public
 	mux.HandleFunc(fmt.Sprintf("%s/user_register", baseAppPath), controller.UserRegister(authService))
 	mux.HandleFunc(fmt.Sprintf("%s/user_login", baseAppPath), controller.UserLogin(authService))
+	mux.HandleFunc(fmt.Sprintf("%s/verify_login_challenge", baseAppPath), controller.VerifyLoginChallenge(authService))
+	mux.HandleFunc(fmt.Sprintf("%s/refresh_token", baseAppPath), controller.RefreshToken(authService))
 
 	// Protected
+	mux.Handle(fmt.Sprintf("%s/reauthenticate", baseAppPath), authService.AuthMiddleware(controller.Reauthenticate(authService)))
+	mux.Handle(fmt.Sprintf("%s/logout", baseAppPath), authService.AuthMiddleware(controller.UserLogout(authService)))
 	mux.Handle(fmt.Sprintf("%s/admin", baseAppPath), authService.AdminMiddleware(http.HandlerFunc(controller.AdminHandler)))
 	mux.Handle(fmt.Sprintf("%s/superuser", baseAppPath), authService.SuperuserMiddleware(http.HandlerFunc(controller.SuperuserHandler)))
 }
@@ -0,0 +1,40 @@
+package router
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+	"github.com/rb4807/Golang-Utlis-Postgresql/controller"
+	"github.com/rb4807/Golang-Utlis-Postgresql/db"
+	"gorm.io/gorm"
+)
+
+// readinessTimeout bounds each database check made by /readyz
+const readinessTimeout = 2 * time.Second
+
+func HealthRoutes(mux *http.ServeMux, authService *auth.Service, database *gorm.DB) {
+	// The same migrations cmd/migrate applies: the auth package's own and
+	// the application's SQL files
+	migrations, err := auth.Migrations(database.Dialector.Name())
+	if err != nil {
+		log.Fatalf("Failed to load auth migrations: %v", err)
+	}
+	dir := db.MigrationsDir()
+	sqlMigrations, err := db.LoadSQLMigrations(os.DirFS(dir), ".", database.Dialector.Name())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Failed to load migrations from %s: %v", dir, err)
+	}
+	migrations = append(migrations, sqlMigrations...)
+
+	// Public, for the orchestrator's liveness and readiness probes
+	mux.HandleFunc("/healthz", controller.Healthz())
+	mux.HandleFunc("/readyz", controller.Readyz(database, migrations, readinessTimeout))
+
+	// Protected
+	mux.Handle("/debug/db", authService.AdminMiddleware(controller.DebugDB(database, migrations, readinessTimeout)))
+}
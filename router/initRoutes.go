@@ -4,14 +4,27 @@ import (
 	"net/http"
 
 	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+	"github.com/rb4807/Golang-Utlis-Postgresql/db"
 	"github.com/rb4807/Golang-Utlis-Postgresql/middleware"
+	"github.com/rb4807/Golang-Utlis-Postgresql/web"
+	"gorm.io/gorm"
 )
 
-func InitRoutes(authService *auth.Service) http.Handler {
+func InitRoutes(authService *auth.Service, webApp *web.App, database *gorm.DB) http.Handler {
 	mux := http.NewServeMux()
 
 	UserRoutes(mux, authService)
 	AuthRoutes(mux, authService)
+	AdminRoutes(mux, authService)
+	HealthRoutes(mux, authService, database)
 
-	return middleware.LoggingMiddleware(middleware.ErrorCatchMiddleware(middleware.PageNotFoundMiddleware(mux)))
+	// Server-rendered pages
+	if webApp != nil {
+		webApp.Routes(mux)
+	}
+
+	// Requests read their own writes from the primary when replicas are configured
+	handler := db.ReadYourWritesMiddleware(authService.CSRFMiddleware(middleware.PageNotFoundMiddleware(mux)))
+
+	return middleware.LoggingMiddleware(middleware.ErrorCatchMiddleware(handler))
 }
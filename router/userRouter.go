@@ -3,6 +3,7 @@ package router
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
 	"github.com/rb4807/Golang-Utlis-Postgresql/controller"
@@ -11,9 +12,21 @@ import (
 func UserRoutes(mux *http.ServeMux, authService *auth.Service) {
 	baseAppPath := "/api/user"
 
+	// Sensitive routes need a login or reauthentication within the last 15 minutes
+	recentAuth := authService.RequireRecentAuth(15 * time.Minute)
+
 	// Public
 
 	// Protected
 	mux.Handle(fmt.Sprintf("%s/get_user_profile", baseAppPath), authService.AuthMiddleware(controller.GetUserProfile(authService)))
-	mux.Handle(fmt.Sprintf("%s/change_user_password", baseAppPath), authService.AuthMiddleware(controller.ChangeUserPassword(authService)))	
+	mux.Handle(fmt.Sprintf("%s/change_user_password", baseAppPath), authService.AuthMiddleware(authService.BlockImpersonation(recentAuth(controller.ChangeUserPassword(authService)))))
+	mux.Handle(fmt.Sprintf("%s/update_user_profile", baseAppPath), authService.AuthMiddleware(authService.BlockImpersonation(recentAuth(controller.UpdateUserProfile(authService)))))
+	mux.Handle(fmt.Sprintf("%s/confirm_email_change", baseAppPath), authService.AuthMiddleware(authService.BlockImpersonation(recentAuth(controller.ConfirmEmailChange(authService)))))
+	mux.Handle(fmt.Sprintf("%s/export_user_data", baseAppPath), authService.AuthMiddleware(authService.BlockImpersonation(recentAuth(controller.ExportUserData(authService)))))
+	mux.Handle(fmt.Sprintf("%s/request_account_deletion", baseAppPath), authService.AuthMiddleware(authService.BlockImpersonation(recentAuth(controller.RequestAccountDeletion(authService)))))
+	mux.Handle(fmt.Sprintf("%s/cancel_account_deletion", baseAppPath), authService.AuthMiddleware(authService.BlockImpersonation(controller.CancelAccountDeletion(authService))))
+	mux.Handle(fmt.Sprintf("%s/stop_impersonation", baseAppPath), authService.AuthMiddleware(controller.StopImpersonation(authService)))
+	mux.Handle(fmt.Sprintf("%s/sessions", baseAppPath), authService.AuthMiddleware(controller.ListUserSessions(authService)))
+	mux.Handle(fmt.Sprintf("%s/revoke_session", baseAppPath), authService.AuthMiddleware(authService.BlockImpersonation(controller.RevokeUserSession(authService))))
+	mux.Handle(fmt.Sprintf("%s/revoke_other_sessions", baseAppPath), authService.AuthMiddleware(authService.BlockImpersonation(controller.RevokeOtherUserSessions(authService))))
 }
\ No newline at end of file
@@ -0,0 +1,80 @@
+package view
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"time"
+)
+
+// builtinFuncs are available to every template
+func builtinFuncs() template.FuncMap {
+	return template.FuncMap{
+		"date":     formatTime("2 Jan 2006"),
+		"datetime": formatTime("2 Jan 2006 15:04"),
+		"url":      buildURL,
+		"dict":     dict,
+	}
+}
+
+// formatTime returns a function formatting time.Time and *time.Time values,
+// printing nothing for nil or zero times
+func formatTime(layout string) func(interface{}) string {
+	return func(value interface{}) string {
+		var t time.Time
+		switch v := value.(type) {
+		case time.Time:
+			t = v
+		case *time.Time:
+			if v == nil {
+				return ""
+			}
+			t = *v
+		default:
+			return ""
+		}
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(layout)
+	}
+}
+
+// buildURL appends alternating query keys and values to a path:
+//
+//	{{url "/login" "next" currentPath}}
+func buildURL(path string, pairs ...interface{}) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("url needs a value for every query key")
+	}
+	if len(pairs) == 0 {
+		return path, nil
+	}
+
+	query := url.Values{}
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return "", fmt.Errorf("url query keys must be strings")
+		}
+		query.Add(key, fmt.Sprint(pairs[i+1]))
+	}
+	return path + "?" + query.Encode(), nil
+}
+
+// dict builds a map from alternating keys and values, to pass several values
+// to a partial
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict needs an even number of arguments")
+	}
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings")
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
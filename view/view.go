@@ -0,0 +1,336 @@
+// Package view renders html/template pages with shared layouts and partials.
+//
+// Templates live in one directory (or fs.FS) laid out as:
+//
+//	layouts/*.html   page skeletons; a layout may sit inside another layout
+//	partials/*.html  {{define}} blocks available to every page
+//	pages/**/*.html  one file per page, named by its path without ".html"
+//
+// A page or layout names its parent layout with a directive on its first line:
+//
+//	{{/* layout "account" */}}
+//
+// Pages without the directive use Options.DefaultLayout; `layout ""` renders
+// the page on its own. Layouts are filled from the innermost outwards through
+// {{block}}: a child layout or page overrides the blocks its parent declares.
+//
+// Templates are parsed once and shared by every request. The request a page
+// is rendered for reaches it as a *Request in the data, which the request
+// functions take as their argument:
+//
+//	{{csrfField .Request}}
+package view
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// layoutDirective matches {{/* layout "name" */}} at the start of a file
+var layoutDirective = regexp.MustCompile(`^\s*\{\{/\*\s*layout\s+"([^"]*)"\s*\*/\}\}`)
+
+// maxLayoutDepth guards against layouts that include each other
+const maxLayoutDepth = 10
+
+// Options configures an Engine
+type Options struct {
+	FS            fs.FS  // Template files, e.g. an embed.FS; defaults to os.DirFS(Dir)
+	Dir           string // Template directory used when FS is nil, defaults to "templates"
+	Reload        bool   // Re-parse templates before every render; for development only
+	DefaultLayout string // Layout for pages without a layout directive, defaults to "base"
+	ErrorPage     string // Page rendered by RenderError with an *ErrorData, defaults to "error"
+
+	Funcs template.FuncMap // Extra functions available to every template
+
+	// CSRFToken returns the request's CSRF token for the csrfField and
+	// csrfToken functions. It may set a cookie on w.
+	CSRFToken func(w http.ResponseWriter, r *http.Request) string
+	// CurrentUser returns the logged-in user for the currentUser function, or nil
+	CurrentUser func(r *http.Request) interface{}
+}
+
+// ErrorData is the data RenderError passes to the error page
+type ErrorData struct {
+	Title   string
+	Status  int
+	Message string
+	Request *Request
+}
+
+// Request is the request a page is rendered for, passed to the request
+// functions from the page's data. NewRequest creates one.
+type Request struct {
+	w http.ResponseWriter
+	r *http.Request
+
+	csrfOnce  sync.Once
+	csrfToken string
+}
+
+// NewRequest wraps a request for the data of a page rendered in response to it
+func NewRequest(w http.ResponseWriter, r *http.Request) *Request {
+	return &Request{w: w, r: r}
+}
+
+// ResponseWriter returns the writer of the response, or nil
+func (q *Request) ResponseWriter() http.ResponseWriter {
+	if q == nil {
+		return nil
+	}
+	return q.w
+}
+
+// HTTPRequest returns the request, or nil
+func (q *Request) HTTPRequest() *http.Request {
+	if q == nil {
+		return nil
+	}
+	return q.r
+}
+
+// Engine holds the parsed templates
+type Engine struct {
+	options Options
+
+	mu    sync.RWMutex
+	pages map[string]*template.Template
+}
+
+// New parses every page and returns an engine ready to render them
+func New(options Options) (*Engine, error) {
+	if options.FS == nil {
+		if options.Dir == "" {
+			options.Dir = "templates"
+		}
+		options.FS = os.DirFS(options.Dir)
+	}
+	if options.DefaultLayout == "" {
+		options.DefaultLayout = "base"
+	}
+	if options.ErrorPage == "" {
+		options.ErrorPage = "error"
+	}
+
+	e := &Engine{options: options}
+	if err := e.Load(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Load parses all templates, replacing the current set only if every page parses
+func (e *Engine) Load() error {
+	partials, err := e.readDir("partials")
+	if err != nil {
+		return err
+	}
+	layouts, err := e.readDir("layouts")
+	if err != nil {
+		return err
+	}
+
+	pages := map[string]*template.Template{}
+	err = fs.WalkDir(e.options.FS, "pages", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(p) != ".html" {
+			return nil
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(p, "pages/"), ".html")
+		source, err := fs.ReadFile(e.options.FS, p)
+		if err != nil {
+			return err
+		}
+
+		tmpl, err := e.parsePage(name, string(source), partials, layouts)
+		if err != nil {
+			return fmt.Errorf("view: page %s: %w", name, err)
+		}
+		pages[name] = tmpl
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.pages = pages
+	e.mu.Unlock()
+	return nil
+}
+
+// readDir returns the .html files of a top-level directory keyed by name; a
+// missing directory is treated as empty
+func (e *Engine) readDir(dir string) (map[string]string, error) {
+	files := map[string]string{}
+	entries, err := fs.ReadDir(e.options.FS, dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return files, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".html" {
+			continue
+		}
+		source, err := fs.ReadFile(e.options.FS, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files[strings.TrimSuffix(entry.Name(), ".html")] = string(source)
+	}
+	return files, nil
+}
+
+// parsePage builds the template set for one page: partials, then its layouts
+// from the outermost in, then the page itself, so inner definitions win
+func (e *Engine) parsePage(name, source string, partials, layouts map[string]string) (*template.Template, error) {
+	chain := []string{}
+	parent := e.parentLayout(source, e.options.DefaultLayout)
+	for parent != "" {
+		if len(chain) >= maxLayoutDepth {
+			return nil, fmt.Errorf("layouts nested more than %d deep", maxLayoutDepth)
+		}
+		layout, ok := layouts[parent]
+		if !ok {
+			return nil, fmt.Errorf("unknown layout %q", parent)
+		}
+		chain = append(chain, parent)
+		parent = e.parentLayout(layout, "")
+	}
+
+	tmpl := template.New(name).Funcs(builtinFuncs()).Funcs(e.requestFuncs()).Funcs(e.options.Funcs)
+
+	for partialName, partial := range partials {
+		if _, err := tmpl.New("partial:" + partialName).Parse(partial); err != nil {
+			return nil, fmt.Errorf("partial %s: %w", partialName, err)
+		}
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if _, err := tmpl.New("layout:" + chain[i]).Parse(layouts[chain[i]]); err != nil {
+			return nil, fmt.Errorf("layout %s: %w", chain[i], err)
+		}
+	}
+	if _, err := tmpl.New("page:" + name).Parse(source); err != nil {
+		return nil, err
+	}
+
+	// Execution starts at the outermost layout, or at the page when it has none
+	entry := "page:" + name
+	if len(chain) > 0 {
+		entry = "layout:" + chain[len(chain)-1]
+	}
+	return tmpl.Lookup(entry), nil
+}
+
+// parentLayout reads the layout directive of a template source
+func (e *Engine) parentLayout(source, fallback string) string {
+	if m := layoutDirective.FindStringSubmatch(source); m != nil {
+		return m[1]
+	}
+	return fallback
+}
+
+// Has reports whether a page exists
+func (e *Engine) Has(name string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, ok := e.pages[name]
+	return ok
+}
+
+// Render executes a page with data and writes it with the given status. The
+// page is rendered to a buffer first, so a template error produces the error
+// page instead of a half-written response.
+func (e *Engine) Render(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) {
+	if err := e.render(w, r, status, name, data); err != nil {
+		log.Printf("view: rendering %s: %v", name, err)
+		if name == e.options.ErrorPage {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		e.RenderError(w, r, http.StatusInternalServerError, "Something went wrong on our side. Please try again.")
+	}
+}
+
+// RenderError renders the error page with an *ErrorData, falling back to plain
+// text if there is no error page
+func (e *Engine) RenderError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	data := &ErrorData{Title: http.StatusText(status), Status: status, Message: message, Request: NewRequest(w, r)}
+	if !e.Has(e.options.ErrorPage) {
+		http.Error(w, message, status)
+		return
+	}
+	if err := e.render(w, r, status, e.options.ErrorPage, data); err != nil {
+		log.Printf("view: rendering error page: %v", err)
+		http.Error(w, message, status)
+	}
+}
+
+func (e *Engine) render(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) error {
+	if e.options.Reload {
+		if err := e.Load(); err != nil {
+			return err
+		}
+	}
+
+	e.mu.RLock()
+	page, ok := e.pages[name]
+	e.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown page %q", name)
+	}
+
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// requestFuncs builds the functions that take the *Request of a page
+func (e *Engine) requestFuncs() template.FuncMap {
+	csrfToken := func(q *Request) string {
+		if q == nil || q.r == nil || e.options.CSRFToken == nil {
+			return ""
+		}
+		q.csrfOnce.Do(func() { q.csrfToken = e.options.CSRFToken(q.w, q.r) })
+		return q.csrfToken
+	}
+
+	return template.FuncMap{
+		"csrfToken": csrfToken,
+		"csrfField": func(q *Request) template.HTML {
+			return template.HTML(`<input type="hidden" name="csrf_token" value="` + template.HTMLEscapeString(csrfToken(q)) + `">`)
+		},
+		"currentUser": func(q *Request) interface{} {
+			if q == nil || q.r == nil || e.options.CurrentUser == nil {
+				return nil
+			}
+			return e.options.CurrentUser(q.r)
+		},
+		"currentPath": func(q *Request) string {
+			if q == nil || q.r == nil {
+				return ""
+			}
+			return q.r.URL.Path
+		},
+	}
+}
@@ -0,0 +1,148 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+)
+
+// emailChangeMaxAuthAge is how recent a login must be to change the account email
+const emailChangeMaxAuthAge = 15 * time.Minute
+
+func (a *App) account(w http.ResponseWriter, r *http.Request) {
+	if !a.methods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	user := currentUser(r)
+
+	if r.Method == http.MethodGet {
+		a.render(w, r, http.StatusOK, "account", pageData{
+			Title: "Your account",
+			Form: map[string]string{
+				"first_name": user.FirstName,
+				"last_name":  user.LastName,
+				"email":      user.Email,
+			},
+		})
+		return
+	}
+
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	values := form(r, "first_name", "last_name", "email")
+	errs := formErrors{}
+	errs.required(values, "first_name", "last_name", "email")
+	errs.email(values, "email")
+
+	update := auth.ProfileUpdate{FirstName: ptr(values["first_name"]), LastName: ptr(values["last_name"])}
+	if values["email"] != user.Email {
+		if err := auth.CheckRecentAuth(currentClaims(r), emailChangeMaxAuthAge); err != nil {
+			errs["email"] = "For your security, sign out and sign in again before changing your email."
+		}
+		update.Email = ptr(values["email"])
+	}
+
+	if len(errs) == 0 {
+		result, err := a.auth.ForRequest(r).UpdateProfile(user.ID, update)
+		if err == nil {
+			if result.EmailChangePending {
+				a.setFlash(w, "info", "Profile saved. Confirm your new email address using the link we sent to "+result.PendingEmail+".")
+			} else {
+				a.setFlash(w, "success", "Profile saved.")
+			}
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+			return
+		}
+		if !errs.fromServiceError(err) {
+			log.Printf("Web profile update failed: %v", err)
+			a.renderError(w, r, http.StatusInternalServerError, "Something went wrong while saving your profile.")
+			return
+		}
+	}
+
+	a.render(w, r, http.StatusUnprocessableEntity, "account", pageData{Title: "Your account", Form: values, Errors: errs})
+}
+
+// sessionView is a session as listed on the sessions page
+type sessionView struct {
+	auth.Session
+	Current bool
+}
+
+func (a *App) sessions(w http.ResponseWriter, r *http.Request) {
+	if !a.methods(w, r, http.MethodGet) {
+		return
+	}
+	claims := currentClaims(r)
+
+	sessions, err := a.auth.ListSessionsContext(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("Web session list failed: %v", err)
+		a.renderError(w, r, http.StatusInternalServerError, "Something went wrong while loading your sessions.")
+		return
+	}
+
+	views := make([]sessionView, 0, len(sessions))
+	for _, session := range sessions {
+		views = append(views, sessionView{Session: session, Current: session.ID == claims.SessionID})
+	}
+
+	a.render(w, r, http.StatusOK, "sessions", pageData{Title: "Where you're signed in", Data: views})
+}
+
+func (a *App) revokeSession(w http.ResponseWriter, r *http.Request) {
+	if !a.methods(w, r, http.MethodPost) || !a.verifyCSRF(w, r) {
+		return
+	}
+	claims := currentClaims(r)
+
+	id, err := strconv.ParseUint(r.PostFormValue("session_id"), 10, 64)
+	if err != nil || id == 0 {
+		a.renderError(w, r, http.StatusBadRequest, "That session could not be found.")
+		return
+	}
+
+	if err := a.auth.ForRequest(r).RevokeSession(claims.UserID, uint(id)); err != nil {
+		if !errors.Is(err, auth.ErrSessionNotFound) {
+			log.Printf("Web session revoke failed: %v", err)
+			a.renderError(w, r, http.StatusInternalServerError, "Something went wrong while signing out that session.")
+			return
+		}
+	}
+
+	// Revoking the current session is a logout
+	if uint(id) == claims.SessionID {
+		a.auth.ClearAuthCookies(w)
+		a.setFlash(w, "success", "You have been signed out.")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	a.setFlash(w, "success", "The session has been signed out.")
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+func (a *App) revokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	if !a.methods(w, r, http.MethodPost) || !a.verifyCSRF(w, r) {
+		return
+	}
+	claims := currentClaims(r)
+
+	if _, err := a.auth.ForRequest(r).RevokeOtherSessions(claims.UserID, claims.SessionID); err != nil {
+		log.Printf("Web session revoke failed: %v", err)
+		a.renderError(w, r, http.StatusInternalServerError, "Something went wrong while signing out your other sessions.")
+		return
+	}
+
+	a.setFlash(w, "success", "All your other sessions have been signed out.")
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -0,0 +1,293 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+)
+
+func (a *App) login(w http.ResponseWriter, r *http.Request) {
+	if !a.methods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if _, _, ok := a.authenticate(w, r); ok {
+		http.Redirect(w, r, safeRedirect(r.URL.Query().Get("next")), http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		a.render(w, r, http.StatusOK, "login", pageData{
+			Title: "Sign in",
+			Form:  map[string]string{"next": r.URL.Query().Get("next")},
+		})
+		return
+	}
+
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	values := form(r, "username", "password", "next")
+	errs := formErrors{}
+	errs.required(values, "username", "password")
+	if len(errs) > 0 {
+		a.render(w, r, http.StatusUnprocessableEntity, "login", pageData{Title: "Sign in", Form: values, Errors: errs})
+		return
+	}
+
+	_, token, err := a.auth.ForRequest(r).Login(values["username"], values["password"])
+	if err != nil {
+		var stepUp *auth.StepUpRequiredError
+		switch {
+		case errors.As(err, &stepUp):
+			cookie := a.auth.NewCookie(challengeCookie, stepUp.ChallengeToken, "/login", stepUp.ExpiresAt, true)
+			http.SetCookie(w, cookie)
+			a.setFlash(w, "info", "We sent a verification code to your email address to confirm it's you.")
+			http.Redirect(w, r, "/login/verify?next="+url.QueryEscape(values["next"]), http.StatusSeeOther)
+			return
+		case errors.Is(err, auth.ErrUserNotFound), errors.Is(err, auth.ErrInvalidPassword):
+			errs[""] = "Incorrect username or password."
+		case errors.Is(err, auth.ErrUserInactive):
+			errs[""] = "This account is inactive. Please contact support."
+		case errors.Is(err, auth.ErrLoginBlocked):
+			errs[""] = "This sign-in looks suspicious and was blocked. Please try again later."
+		case errs.fromServiceError(err):
+		default:
+			log.Printf("Web login failed: %v", err)
+			a.renderError(w, r, http.StatusInternalServerError, "Something went wrong while signing you in.")
+			return
+		}
+		values["password"] = ""
+		a.render(w, r, http.StatusUnprocessableEntity, "login", pageData{Title: "Sign in", Form: values, Errors: errs})
+		return
+	}
+
+	if !a.startSession(w, r, token) {
+		return
+	}
+	http.Redirect(w, r, safeRedirect(values["next"]), http.StatusSeeOther)
+}
+
+func (a *App) loginVerify(w http.ResponseWriter, r *http.Request) {
+	if !a.methods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	challenge, err := r.Cookie(challengeCookie)
+	if err != nil || challenge.Value == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		a.render(w, r, http.StatusOK, "login_verify", pageData{
+			Title: "Confirm it's you",
+			Form:  map[string]string{"next": r.URL.Query().Get("next")},
+		})
+		return
+	}
+
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	values := form(r, "code", "next")
+	errs := formErrors{}
+	errs.required(values, "code")
+	if len(errs) > 0 {
+		a.render(w, r, http.StatusUnprocessableEntity, "login_verify", pageData{Title: "Confirm it's you", Form: values, Errors: errs})
+		return
+	}
+
+	_, token, err := a.auth.ForRequest(r).CompleteLoginChallenge(challenge.Value, values["code"])
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidChallenge):
+			errs["code"] = "That code is invalid or has expired. If it keeps failing, sign in again to get a new one."
+		case errors.Is(err, auth.ErrUserInactive):
+			errs[""] = "This account is inactive. Please contact support."
+		case errs.fromServiceError(err):
+		default:
+			log.Printf("Web login verification failed: %v", err)
+			a.renderError(w, r, http.StatusInternalServerError, "Something went wrong while signing you in.")
+			return
+		}
+		a.render(w, r, http.StatusUnprocessableEntity, "login_verify", pageData{Title: "Confirm it's you", Form: values, Errors: errs})
+		return
+	}
+
+	clear := a.auth.NewCookie(challengeCookie, "", "/login", challenge.Expires, true)
+	clear.MaxAge = -1
+	http.SetCookie(w, clear)
+
+	if !a.startSession(w, r, token) {
+		return
+	}
+	http.Redirect(w, r, safeRedirect(values["next"]), http.StatusSeeOther)
+}
+
+// startSession stores the login's tokens in cookies
+func (a *App) startSession(w http.ResponseWriter, r *http.Request, token string) bool {
+	pair, err := a.auth.NewTokenPairContext(r.Context(), token)
+	if err == nil {
+		_, err = a.auth.SetAuthCookies(w, pair)
+	}
+	if err != nil {
+		log.Printf("Web session start failed: %v", err)
+		a.renderError(w, r, http.StatusInternalServerError, "Something went wrong while signing you in.")
+		return false
+	}
+	return true
+}
+
+func (a *App) register(w http.ResponseWriter, r *http.Request) {
+	if !a.methods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		a.withOptionalUser(w, r, func(r *http.Request) {
+			a.render(w, r, http.StatusOK, "register", pageData{Title: "Create an account"})
+		})
+		return
+	}
+
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	values := form(r, "email", "first_name", "last_name", "password", "password_confirm")
+	errs := formErrors{}
+	errs.required(values, "email", "first_name", "last_name")
+	errs.email(values, "email")
+	errs.newPassword(values, "password", "password_confirm")
+
+	if len(errs) == 0 {
+		_, err := a.auth.ForRequest(r).Register(auth.User{
+			Username:  values["email"],
+			Email:     values["email"],
+			FirstName: values["first_name"],
+			LastName:  values["last_name"],
+			IsActive:  true,
+		}, values["password"])
+		if err == nil {
+			a.setFlash(w, "success", "Your account has been created. You can now sign in.")
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if !errs.fromServiceError(err) {
+			log.Printf("Web registration failed: %v", err)
+			a.renderError(w, r, http.StatusInternalServerError, "Something went wrong while creating your account.")
+			return
+		}
+	}
+
+	values["password"], values["password_confirm"] = "", ""
+	a.render(w, r, http.StatusUnprocessableEntity, "register", pageData{Title: "Create an account", Form: values, Errors: errs})
+}
+
+func (a *App) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	if !a.methods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		a.render(w, r, http.StatusOK, "forgot_password", pageData{Title: "Forgot password"})
+		return
+	}
+
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	values := form(r, "email")
+	errs := formErrors{}
+	errs.required(values, "email")
+	errs.email(values, "email")
+	if len(errs) > 0 {
+		a.render(w, r, http.StatusUnprocessableEntity, "forgot_password", pageData{Title: "Forgot password", Form: values, Errors: errs})
+		return
+	}
+
+	if err := a.auth.ForRequest(r).RequestPasswordReset(values["email"]); err != nil {
+		if errors.Is(err, auth.ErrTooManyRequests) {
+			errs[""] = "Too many password reset requests. Try again later."
+			a.render(w, r, http.StatusTooManyRequests, "forgot_password", pageData{Title: "Forgot password", Form: values, Errors: errs})
+			return
+		}
+		log.Printf("Web password reset request failed: %v", err)
+		a.renderError(w, r, http.StatusInternalServerError, "Something went wrong while sending your reset code.")
+		return
+	}
+
+	a.setFlash(w, "info", "If an account exists for that address, we have emailed it a reset code.")
+	http.Redirect(w, r, "/reset-password?email="+url.QueryEscape(values["email"]), http.StatusSeeOther)
+}
+
+func (a *App) resetPassword(w http.ResponseWriter, r *http.Request) {
+	if !a.methods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		a.render(w, r, http.StatusOK, "reset_password", pageData{
+			Title: "Reset password",
+			Form:  map[string]string{"email": r.URL.Query().Get("email")},
+		})
+		return
+	}
+
+	if !a.verifyCSRF(w, r) {
+		return
+	}
+	values := form(r, "email", "code", "password", "password_confirm")
+	errs := formErrors{}
+	errs.required(values, "email", "code")
+	errs.email(values, "email")
+	errs.newPassword(values, "password", "password_confirm")
+
+	status := http.StatusUnprocessableEntity
+	if len(errs) == 0 {
+		err := a.auth.ForRequest(r).ResetPasswordWithOTP(values["email"], values["code"], values["password"])
+		if err == nil {
+			a.auth.ClearAuthCookies(w)
+			a.setFlash(w, "success", "Your password has been changed. Sign in with your new password.")
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		switch {
+		case errors.Is(err, auth.ErrInvalidChallenge):
+			errs["code"] = "That code is invalid or has expired."
+		case errors.Is(err, auth.ErrTooManyRequests):
+			errs[""] = "Too many password reset attempts. Try again later."
+			status = http.StatusTooManyRequests
+		case errs.fromServiceError(err):
+		default:
+			log.Printf("Web password reset failed: %v", err)
+			a.renderError(w, r, http.StatusInternalServerError, "Something went wrong while resetting your password.")
+			return
+		}
+	}
+
+	values["password"], values["password_confirm"] = "", ""
+	a.render(w, r, status, "reset_password", pageData{Title: "Reset password", Form: values, Errors: errs})
+}
+
+func (a *App) logout(w http.ResponseWriter, r *http.Request) {
+	if !a.methods(w, r, http.MethodPost) || !a.verifyCSRF(w, r) {
+		return
+	}
+
+	// The refresh token identifies the session even after the access token
+	// has expired, so signing out always ends it
+	var err error
+	if cookie, cookieErr := r.Cookie(auth.RefreshTokenCookie); cookieErr == nil && cookie.Value != "" {
+		err = a.auth.ForRequest(r).LogoutRefreshTokenContext(r.Context(), cookie.Value)
+	} else if claims, _, ok := a.authenticate(w, r); ok {
+		err = a.auth.ForRequest(r).LogoutContext(r.Context(), claims)
+	}
+	if err != nil {
+		log.Printf("Web logout failed: %v", err)
+	}
+	a.auth.ClearAuthCookies(w)
+	a.setFlash(w, "success", "You have been signed out.")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,109 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+)
+
+// minPasswordLength is the shortest password the forms accept
+const minPasswordLength = 8
+
+// formErrors maps field names to messages; the "" key holds form-wide errors
+type formErrors map[string]string
+
+// form reads the named fields from a submitted form, trimming surrounding
+// whitespace from everything except passwords
+func form(r *http.Request, fields ...string) map[string]string {
+	values := make(map[string]string, len(fields))
+	for _, field := range fields {
+		value := r.PostFormValue(field)
+		if !strings.Contains(field, "password") {
+			value = strings.TrimSpace(value)
+		}
+		values[field] = value
+	}
+	return values
+}
+
+// required records an error for every listed field left empty
+func (e formErrors) required(values map[string]string, fields ...string) {
+	for _, field := range fields {
+		if values[field] == "" {
+			e[field] = "This field is required."
+		}
+	}
+}
+
+// email records an error when the field is set but not an email address
+func (e formErrors) email(values map[string]string, field string) {
+	if values[field] != "" && !auth.ValidateEmail(values[field]) {
+		e[field] = "Enter a valid email address."
+	}
+}
+
+// newPassword checks a new password and its confirmation
+func (e formErrors) newPassword(values map[string]string, field, confirmField string) {
+	switch {
+	case values[field] == "":
+		e[field] = "This field is required."
+	case len(values[field]) < minPasswordLength:
+		e[field] = "Use at least 8 characters."
+	case values[field] != values[confirmField]:
+		e[confirmField] = "The passwords do not match."
+	}
+}
+
+// fromServiceError turns validation and hook errors from auth.Service into
+// form errors, reporting whether err was one of them
+func (e formErrors) fromServiceError(err error) bool {
+	var hookErr *auth.HookError
+	if errors.As(err, &hookErr) {
+		e[""] = hookErr.Message
+		return true
+	}
+
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		for _, fieldErr := range validationErrors {
+			field := strings.ToLower(fieldErr.Field())
+			switch fieldErr.Tag() {
+			case "required":
+				e[field] = "This field is required."
+			case "email":
+				e[field] = "Enter a valid email address."
+			case "min", "max":
+				e[field] = "Must be between 3 and 50 characters."
+			default:
+				e[field] = "This value is not valid."
+			}
+		}
+		return true
+	}
+
+	switch {
+	case errors.Is(err, auth.ErrEmailExists):
+		e["email"] = "An account with this email already exists."
+	case errors.Is(err, auth.ErrUsernameExists):
+		e["username"] = "This username is already taken."
+	case errors.Is(err, auth.ErrInvalidEmail):
+		e["email"] = "Enter a valid email address."
+	default:
+		return false
+	}
+	return true
+}
+
+// generateToken returns a random hex token for cookies
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,177 @@
+package web
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+)
+
+// Cookie names used only by the UI
+const (
+	flashCookie     = "flash"
+	challengeCookie = "login_challenge"
+)
+
+type contextKey string
+
+const (
+	userContextKey   contextKey = "web_user"
+	claimsContextKey contextKey = "web_claims"
+)
+
+// flash is a one-time message shown on the next rendered page
+type flash struct {
+	Kind    string `json:"kind"` // "success", "info" or "error"
+	Message string `json:"message"`
+}
+
+// setFlash stores a message for the next page the browser loads
+func (a *App) setFlash(w http.ResponseWriter, kind, message string) {
+	payload, err := json.Marshal(flash{Kind: kind, Message: message})
+	if err != nil {
+		return
+	}
+	http.SetCookie(w, a.auth.NewCookie(flashCookie, base64.RawURLEncoding.EncodeToString(payload), "/", time.Time{}, true))
+}
+
+// takeFlash returns and clears the pending flash message, if any
+func (a *App) takeFlash(w http.ResponseWriter, r *http.Request) *flash {
+	cookie, err := r.Cookie(flashCookie)
+	if err != nil {
+		return nil
+	}
+
+	clear := a.auth.NewCookie(flashCookie, "", "/", time.Time{}, true)
+	clear.MaxAge = -1
+	http.SetCookie(w, clear)
+
+	payload, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	var f flash
+	if err := json.Unmarshal(payload, &f); err != nil || f.Message == "" {
+		return nil
+	}
+	return &f
+}
+
+// csrfToken returns the CSRF token for forms, issuing a cookie for visitors
+// that do not have one yet. Login replaces it with the session's token.
+func (a *App) csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(auth.CSRFCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, a.auth.NewCookie(auth.CSRFCookie, token, "/", time.Time{}, false))
+	return token
+}
+
+// verifyCSRF rejects a form submission whose csrf_token field does not match its cookie
+func (a *App) verifyCSRF(w http.ResponseWriter, r *http.Request) bool {
+	if auth.ValidCSRFToken(r) {
+		return true
+	}
+	a.renderError(w, r, http.StatusForbidden, "Your form has expired. Please go back, reload the page and try again.")
+	return false
+}
+
+// authenticate returns the claims and user for the request's access token
+// cookie. Once the access token has expired it renews the session with the
+// refresh token cookie, writing the new cookies to w.
+func (a *App) authenticate(w http.ResponseWriter, r *http.Request) (*auth.TokenClaims, *auth.User, bool) {
+	var claims *auth.TokenClaims
+	if cookie, err := r.Cookie(auth.AccessTokenCookie); err == nil && cookie.Value != "" {
+		claims, _ = a.auth.ValidateAccessTokenContext(r.Context(), cookie.Value)
+	}
+	if claims == nil {
+		var err error
+		if claims, err = a.refreshSession(w, r); err != nil {
+			return nil, nil, false
+		}
+	}
+
+	user, err := a.auth.GetUserByIDContext(r.Context(), claims.UserID)
+	if err != nil || !user.IsActive {
+		return nil, nil, false
+	}
+	return claims, user, true
+}
+
+// refreshSession exchanges the refresh token cookie for new session cookies
+// and returns the claims of the new access token
+func (a *App) refreshSession(w http.ResponseWriter, r *http.Request) (*auth.TokenClaims, error) {
+	cookie, err := r.Cookie(auth.RefreshTokenCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, auth.ErrInvalidToken
+	}
+
+	pair, err := a.auth.ForRequest(r).RefreshSessionContext(r.Context(), cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	a.auth.SetSessionCookies(w, pair)
+	return a.auth.ValidateAccessTokenContext(r.Context(), pair.AccessToken)
+}
+
+// requireLogin runs handler for logged-in users and sends everyone else to the
+// login page. Impersonating administrators can look but not change anything.
+func (a *App) requireLogin(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, user, ok := a.authenticate(w, r)
+		if !ok {
+			target := "/login"
+			if r.Method == http.MethodGet {
+				target += "?next=" + url.QueryEscape(r.URL.RequestURI())
+			}
+			http.Redirect(w, r, target, http.StatusSeeOther)
+			return
+		}
+
+		if claims.IsImpersonated() && r.Method != http.MethodGet {
+			a.renderError(w, r, http.StatusForbidden, "This action is not allowed while impersonating a user.")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		ctx = context.WithValue(ctx, userContextKey, user)
+		handler(w, r.WithContext(ctx))
+	})
+}
+
+// withOptionalUser runs fn with the logged-in user in the context, if there is one
+func (a *App) withOptionalUser(w http.ResponseWriter, r *http.Request, fn func(r *http.Request)) {
+	if claims, user, ok := a.authenticate(w, r); ok {
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		r = r.WithContext(context.WithValue(ctx, userContextKey, user))
+	}
+	fn(r)
+}
+
+func currentUser(r *http.Request) *auth.User {
+	user, _ := r.Context().Value(userContextKey).(*auth.User)
+	return user
+}
+
+func currentClaims(r *http.Request) *auth.TokenClaims {
+	claims, _ := r.Context().Value(claimsContextKey).(*auth.TokenClaims)
+	return claims
+}
+
+// safeRedirect only allows local paths as post-login destinations
+func safeRedirect(next string) string {
+	if next == "" || !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/account"
+	}
+	return next
+}
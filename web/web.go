@@ -0,0 +1,145 @@
+package web
+
+import (
+	"html/template"
+	"io/fs"
+	"net/http"
+
+	"github.com/rb4807/Golang-Utlis-Postgresql/assets"
+	"github.com/rb4807/Golang-Utlis-Postgresql/auth"
+	"github.com/rb4807/Golang-Utlis-Postgresql/view"
+)
+
+// Options configures the server-rendered UI
+type Options struct {
+	Templates   fs.FS  // Template files, e.g. an embed.FS; defaults to the TemplateDir directory
+	TemplateDir string // Directory holding the layouts, partials and pages, defaults to "templates"
+	Static      fs.FS  // Files served under /static/; defaults to the StaticDir directory
+	StaticDir   string // Directory of static files, defaults to "static"
+	Reload      bool   // Re-read templates and static files on every request; for development only
+}
+
+// App serves the login, registration and account pages
+type App struct {
+	auth    *auth.Service
+	options Options
+	views   *view.Engine
+	assets  *assets.Assets
+}
+
+// New creates the UI and parses its templates
+func New(authService *auth.Service, options Options) (*App, error) {
+	if options.TemplateDir == "" {
+		options.TemplateDir = "templates"
+	}
+	if options.StaticDir == "" {
+		options.StaticDir = "static"
+	}
+
+	static, err := assets.New(assets.Options{
+		FS:     options.Static,
+		Dir:    options.StaticDir,
+		Prefix: "/static/",
+		Reload: options.Reload,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	app := &App{auth: authService, options: options, assets: static}
+	views, err := view.New(view.Options{
+		FS:     options.Templates,
+		Dir:    options.TemplateDir,
+		Reload: options.Reload,
+		Funcs: template.FuncMap{
+			"asset": static.Path,
+			"flash": func(q *view.Request) *flash { return app.takeFlash(q.ResponseWriter(), q.HTTPRequest()) },
+		},
+		CSRFToken: app.csrfToken,
+		CurrentUser: func(r *http.Request) interface{} {
+			// A typed nil would make {{if currentUser .Request}} true
+			if user := currentUser(r); user != nil {
+				return user
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	app.views = views
+	return app, nil
+}
+
+// Routes registers the UI pages and static files on mux
+func (a *App) Routes(mux *http.ServeMux) {
+	// Public
+	mux.HandleFunc("/{$}", a.home)
+	mux.HandleFunc("/about", a.about)
+	mux.HandleFunc("/login", a.login)
+	mux.HandleFunc("/login/verify", a.loginVerify)
+	mux.HandleFunc("/register", a.register)
+	mux.HandleFunc("/forgot-password", a.forgotPassword)
+	mux.HandleFunc("/reset-password", a.resetPassword)
+	mux.Handle("/static/", a.assets)
+
+	// Protected
+	mux.Handle("/account", a.requireLogin(a.account))
+	mux.Handle("/account/sessions", a.requireLogin(a.sessions))
+	mux.Handle("/account/sessions/revoke", a.requireLogin(a.revokeSession))
+	mux.Handle("/account/sessions/revoke-others", a.requireLogin(a.revokeOtherSessions))
+
+	// Works with an expired access token, ending the session by its refresh token
+	mux.HandleFunc("/logout", a.logout)
+}
+
+// pageData is passed to every page. The signed-in user, CSRF field and flash
+// message come from the view functions currentUser, csrfField and flash,
+// called with the Request field.
+type pageData struct {
+	Title   string
+	Form    map[string]string // Submitted values to redisplay
+	Errors  map[string]string // Validation errors by field name, "" for the whole form
+	Data    interface{}       // Page-specific values
+	Request *view.Request     // Set by render
+}
+
+// render shows a page
+func (a *App) render(w http.ResponseWriter, r *http.Request, status int, page string, data pageData) {
+	data.Request = view.NewRequest(w, r)
+	a.views.Render(w, r, status, page, data)
+}
+
+// renderError shows the generic error page
+func (a *App) renderError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	a.views.RenderError(w, r, status, message)
+}
+
+// methods rejects requests whose method is not allowed, like middleware.RequestMethodValidator for pages
+func (a *App) methods(w http.ResponseWriter, r *http.Request, allowed ...string) bool {
+	for _, method := range allowed {
+		if r.Method == method {
+			return true
+		}
+	}
+	a.renderError(w, r, http.StatusMethodNotAllowed, "This page does not support that request.")
+	return false
+}
+
+func (a *App) home(w http.ResponseWriter, r *http.Request) {
+	if !a.methods(w, r, http.MethodGet) {
+		return
+	}
+	a.withOptionalUser(w, r, func(r *http.Request) {
+		a.render(w, r, http.StatusOK, "home", pageData{Title: "Home"})
+	})
+}
+
+func (a *App) about(w http.ResponseWriter, r *http.Request) {
+	if !a.methods(w, r, http.MethodGet) {
+		return
+	}
+	a.withOptionalUser(w, r, func(r *http.Request) {
+		a.render(w, r, http.StatusOK, "about", pageData{Title: "About"})
+	})
+}